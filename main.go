@@ -129,6 +129,11 @@ func registerMaintenanceProxy(engine *gin.Engine, webPrefix, apiPrefix string, p
 	}
 }
 
+// underPrefix reports whether path is prefix or lies below it ("/" covers everything).
+func underPrefix(path, prefix string) bool {
+	return prefix == "/" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
 func MyGin(cfg *config.Config) *gin.Engine {
 	engine := gin.Default()
 	engine.Use(cors.New(cors.Config{
@@ -144,6 +149,11 @@ func MyGin(cfg *config.Config) *gin.Engine {
 	// 브라우저는 Server.HTTPPort origin 기준으로 APIPrefix를 호출하므로 API도 같이 넘긴다.
 	proxy := newMaintenanceWebProxy(cfg)
 	registerMaintenanceProxy(engine, webPrefix, apiPrefix, proxy)
+	// Prometheus scrape path at the root, outside both prefixes; skipped when a prefix already covers it.
+	if !underPrefix("/metrics", webPrefix) && !underPrefix("/metrics", apiPrefix) {
+		engine.GET("/metrics", gin.WrapH(proxy))
+		engine.HEAD("/metrics", gin.WrapH(proxy))
+	}
 
 	serviceGroup := routerGroupJSON(engine, "/c-agent/service")
 	apiGroupV1 := serviceGroup.Group("/api/v1")
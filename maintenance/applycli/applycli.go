@@ -9,7 +9,6 @@ import (
 	"fmt"
 	"io"
 	"mime/multipart"
-	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -19,6 +18,7 @@ import (
 	"contrabass-agent/maintenance/config"
 	"contrabass-agent/maintenance/appmeta"
 	"contrabass-agent/maintenance/cliutil"
+	"contrabass-agent/maintenance/discovery"
 	"contrabass-agent/maintenance/server"
 	"contrabass-agent/maintenance/versionsapi"
 )
@@ -117,7 +117,7 @@ func Run(buildVersionKey string, args []string) int {
 
 	default:
 		remoteIP := target
-		if net.ParseIP(remoteIP) == nil {
+		if !cliutil.IsRemoteIP(remoteIP) {
 			fmt.Fprintf(os.Stderr, "%s: remote target must be a valid IP address: %q\n", appmeta.BinaryName, remoteIP)
 			return 1
 		}
@@ -127,11 +127,17 @@ func Run(buildVersionKey string, args []string) int {
 			return 1
 		}
 		remoteBase := cliutil.RemoteBaseURL(cfg, remoteIP)
-		cur, err := fetchVersionGET(httpClient, remoteBase+apiPrefix+"/self")
+		rs, err := fetchSelfGET(httpClient, remoteBase+apiPrefix+"/self")
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s: get remote version failed (%s): %v\n", appmeta.BinaryName, remoteBase+apiPrefix+"/self", err)
 			return 1
 		}
+		// Remote apply posts the bundle to the target's apply-update, which then uploads it to its own staging.
+		if err := discovery.CheckCapabilities(rs.ProtoVersion, rs.Capabilities, discovery.CapApplyUpdateBundle, discovery.CapBundleUpload); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: remote %s (version %s) cannot take a bundle: %v\n", appmeta.BinaryName, remoteIP, rs.Version, err)
+			return 1
+		}
+		cur := rs.Version
 		if !config.StagingUpdateAvailable(versionKey, cur) {
 			fmt.Fprintf(os.Stderr, "%s: update not needed or not allowed by policy (bundle %q, remote current %q)\n", appmeta.BinaryName, versionKey, cur)
 			return 1
@@ -157,36 +163,42 @@ func currentVersionKeyForApply(buildVersionKey string, cfg *config.Config) strin
 	return strings.TrimSpace(buildVersionKey)
 }
 
-func fetchVersionGET(client *http.Client, url string) (string, error) {
+// remoteSelf is the part of GET {APIPrefix}/self the CLI checks before a remote apply.
+type remoteSelf struct {
+	Version      string   `json:"version"`
+	ProtoVersion int      `json:"proto_version"`
+	Capabilities []string `json:"capabilities"`
+}
+
+func fetchSelfGET(client *http.Client, url string) (remoteSelf, error) {
 	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
 	if err != nil {
-		return "", err
+		return remoteSelf{}, err
 	}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return remoteSelf{}, err
 	}
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return remoteSelf{}, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		return remoteSelf{}, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
 	}
 	var out struct {
-		Status string `json:"status"`
-		Data   struct {
-			Version string `json:"version"`
-		} `json:"data"`
+		Status string     `json:"status"`
+		Data   remoteSelf `json:"data"`
 	}
 	if err := json.Unmarshal(body, &out); err != nil {
-		return "", fmt.Errorf("JSON: %w", err)
+		return remoteSelf{}, fmt.Errorf("JSON: %w", err)
 	}
 	if out.Status != "success" {
-		return "", fmt.Errorf("status %q", out.Status)
+		return remoteSelf{}, fmt.Errorf("status %q", out.Status)
 	}
-	return strings.TrimSpace(out.Data.Version), nil
+	out.Data.Version = strings.TrimSpace(out.Data.Version)
+	return out.Data, nil
 }
 
 func postMultipartApplyRemote(client *http.Client, applyURL, remoteIP, bundlePath string) error {
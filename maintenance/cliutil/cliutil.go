@@ -3,6 +3,7 @@ package cliutil
 
 import (
 	"net"
+	"net/netip"
 	"strconv"
 	"strings"
 	"time"
@@ -35,9 +36,9 @@ func RemoteDialAddr(cfg *config.Config, ip string) string {
 	return net.JoinHostPort(ip, strconv.Itoa(HTTPPortOrDefault(cfg)))
 }
 
-// RemoteBaseURL returns "http://ip:port" for the remote agent HTTP API (Gin).
+// RemoteBaseURL returns "http://ip:port" for the remote agent HTTP API (Gin). IPv6 is bracketed and a zone is escaped as %25.
 func RemoteBaseURL(cfg *config.Config, ip string) string {
-	return "http://" + RemoteDialAddr(cfg, ip)
+	return "http://" + RemoteDialAddr(cfg, strings.Replace(ip, "%", "%25", 1))
 }
 
 // DialTCP tries a TCP connection and closes it immediately (reachability check).
@@ -49,3 +50,23 @@ func DialTCP(addr string, timeout time.Duration) error {
 	_ = conn.Close()
 	return nil
 }
+
+// IsRemoteIP reports whether s is an IP address usable as a remote target: IPv4, IPv6,
+// or link-local IPv6 with a zone as printed by --discovery (e.g. fe80::1%eth0).
+func IsRemoteIP(s string) bool {
+	_, err := netip.ParseAddr(s)
+	return err == nil
+}
+
+// LocalMaintenanceBaseURL returns "http://host:MaintenancePort" for this host's running maintenance HTTP server.
+// An unspecified listen address (0.0.0.0, ::) is reached on loopback.
+func LocalMaintenanceBaseURL(cfg *config.Config) string {
+	host := strings.TrimSpace(cfg.MaintenanceListenAddress)
+	switch host {
+	case "", "0.0.0.0":
+		host = "127.0.0.1"
+	case "::", "[::]":
+		host = "::1"
+	}
+	return "http://" + net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(cfg.MaintenancePort))
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
+	"path"
 	"regexp"
 	"strings"
 
@@ -12,8 +15,11 @@ import (
 // Config holds application configuration (YAML).
 type Config struct {
 	DiscoveryServiceName       string `yaml:"DiscoveryServiceName"`
-	DiscoveryBroadcastAddress  string `yaml:"DiscoveryBroadcastAddress"` // fallback when automatic brd collection (PRD 3.1.1) finds none
-	// DiscoveryBroadcastAddresses []string `yaml:"DiscoveryBroadcastAddresses"` // 주석: 물리 NIC brd 자동 수집 사용
+	DiscoveryBroadcastAddress  string `yaml:"DiscoveryBroadcastAddress"` // fallback when automatic brd collection (PRD 3.1.1) and DiscoveryBroadcastAddresses give none
+	// DiscoveryBroadcastAddresses are IPv4 addresses always sent to, merged with the automatically collected brd addresses (e.g. a routed directed broadcast).
+	DiscoveryBroadcastAddresses []string `yaml:"DiscoveryBroadcastAddresses"`
+	// DiscoveryInterfaces narrows or widens automatic brd collection and the discovery interface lists by interface name.
+	DiscoveryInterfaces DiscoveryInterfacesConfig `yaml:"DiscoveryInterfaces"`
 	DiscoveryUDPPort           int    `yaml:"DiscoveryUDPPort"`
 	MaintenanceListenAddress   string `yaml:"MaintenanceListenAddress"` // e.g. "127.0.0.1" (internal only) or "0.0.0.0"
 	MaintenancePort            int    `yaml:"MaintenancePort"`
@@ -22,6 +28,13 @@ type Config struct {
 	APIPrefix                  string `yaml:"APIPrefix"`
 	DiscoveryTimeoutSeconds    int    `yaml:"DiscoveryTimeoutSeconds"`
 	DiscoveryDeduplicate bool `yaml:"DiscoveryDeduplicate"`
+	// IPv6 link-local multicast discovery (in addition to IPv4 broadcast): join DiscoveryIPv6Group on each included interface with an fe80:: address.
+	DiscoveryIPv6      bool   `yaml:"DiscoveryIPv6"`      // default false
+	DiscoveryIPv6Group string `yaml:"DiscoveryIPv6Group"` // default ff02::6d6f:6c65; must be the same on all agents
+	// IPv4 request destinations: "broadcast" (brd addresses, default), "multicast" (DiscoveryMulticastGroup) or "both".
+	DiscoveryMode           string `yaml:"DiscoveryMode"`
+	DiscoveryMulticastGroup string `yaml:"DiscoveryMulticastGroup"` // default 239.255.77.79; must be the same on all agents
+	DiscoveryMulticastTTL   int    `yaml:"DiscoveryMulticastTTL"`   // default 1 (local segment); raise to cross multicast routers. Clamped to 1..255
 	// Systemctl service status (self + discovered hosts)
 	SystemctlServiceName string `yaml:"SystemctlServiceName"` // e.g. "contrabass-mole.service"
 	DeployBase           string `yaml:"DeployBase"`           // e.g. "/var/lib/contrabass/mole" for staging/, update.sh
@@ -34,6 +47,139 @@ type Config struct {
 	MaxUploadBytes uploadBytesExpr `yaml:"MaxUploadBytes"`
 	// RemoteHealth configures HTTP remote host health checks (maintenance web → remote Server.HTTPPort GET …/health). Browser polls only while the page is open.
 	RemoteHealth RemoteHealthConfig `yaml:"RemoteHealth"`
+	// DiscoveryAuth enables HMAC-signed DISCOVERY_REQUEST / DISCOVERY_RESPONSE when a shared secret is set. All agents (and CLIs) on the segment must use the same secret.
+	DiscoveryAuth DiscoveryAuthConfig `yaml:"DiscoveryAuth"`
+	// Presence: HELLO on startup, periodic heartbeat, BYE on shutdown; peers build a live table (GET …/peers).
+	Presence PresenceConfig `yaml:"Presence"`
+	// DiscoveryStaticPeers are probed by unicast on every discovery run, for segments where broadcast is filtered.
+	// Each entry is an IP, a CIDR (up to 4096 addresses) or an absolute path to a file with one entry per line.
+	DiscoveryStaticPeers            []string `yaml:"DiscoveryStaticPeers"`
+	DiscoveryStaticPeersConcurrency int      `yaml:"DiscoveryStaticPeersConcurrency"` // default 32; unicast probes in flight. Clamped to 1..256
+	// DiscoveryRelay reaches agents in other routed subnets: discovery runs are forwarded by unicast to relay peers, which re-broadcast there.
+	DiscoveryRelay DiscoveryRelayConfig `yaml:"DiscoveryRelay"`
+	// DiscoveryLimits rate-limits and restricts answers to DISCOVERY_REQUEST (anti-amplification; source IPs can be spoofed).
+	DiscoveryLimits DiscoveryLimitsConfig `yaml:"DiscoveryLimits"`
+	// DiscoveryMDNS announces this agent as a DNS-SD service (_contrabass._tcp.local) for avahi-browse and other mDNS tools,
+	// and optionally browses mDNS as an extra source of hosts for discovery runs.
+	DiscoveryMDNS DiscoveryMDNSConfig `yaml:"DiscoveryMDNS"`
+	// MetricsHistory samples host metrics (CPU, memory, load, disk, NIC rates) into an in-memory ring for GET …/metrics/history.
+	MetricsHistory MetricsHistoryConfig `yaml:"MetricsHistory"`
+	// Hardware controls the DMI/sysfs hardware inventory ("hardware" in GET …/self, --hardware).
+	Hardware HardwareConfig `yaml:"Hardware"`
+	// Labels tag this host (e.g. role: db, rack: A3). Sent in DISCOVERY_RESPONSE and GET …/self; discovery requests can select on them.
+	Labels map[string]string `yaml:"Labels"`
+}
+
+// PresenceConfig holds nested Maintenance.Presence settings.
+type PresenceConfig struct {
+	Enabled          bool `yaml:"Enabled"`          // default true; false = do not announce (peer table still filled from others)
+	HeartbeatSeconds int  `yaml:"HeartbeatSeconds"` // default 30; peers expire this host after 3 missed heartbeats. Clamped to 5..3600
+}
+
+// DiscoveryRelayConfig holds nested Maintenance.DiscoveryRelay settings.
+type DiscoveryRelayConfig struct {
+	Accept  bool     `yaml:"Accept"`  // default false; true = re-broadcast relayed requests from other agents and send the answers back (unsigned: only from Peers)
+	Peers   []string `yaml:"Peers"`   // relay agents to forward every discovery run to: "10.2.0.5" or "10.2.0.5:9999" (default port DiscoveryUDPPort)
+	MaxHops int      `yaml:"MaxHops"` // default 2; how many relays a request may pass through. Clamped to 1..8
+}
+
+// DiscoveryInterfacesConfig holds nested Maintenance.DiscoveryInterfaces settings (glob patterns, path.Match syntax: *, ?, [a-z]).
+type DiscoveryInterfacesConfig struct {
+	Include []string `yaml:"Include"` // e.g. ["bond*", "eth1"]; when set, only matching interfaces are used and a match skips the Ethernet/bridge checks
+	Exclude []string `yaml:"Exclude"` // e.g. ["docker*", "veth*"]; checked first, always wins
+}
+
+// DiscoveryMDNSConfig holds nested Maintenance.DiscoveryMDNS settings.
+type DiscoveryMDNSConfig struct {
+	Advertise     bool   `yaml:"Advertise"`     // default true; answer _contrabass._tcp queries on UDP 5353 (shared with avahi) and announce on start / address changes
+	Browse        bool   `yaml:"Browse"`        // default false; every discovery run also browses mDNS and adds agents that did not answer over UDP
+	BrowseSeconds int    `yaml:"BrowseSeconds"` // default 2; how long a run waits for mDNS answers (never past the run timeout). Clamped to 1..10
+	InstanceName  string `yaml:"InstanceName"`  // DNS-SD instance name; default the hostname. At most 63 bytes, no dots
+}
+
+// MetricsHistoryConfig holds nested Maintenance.MetricsHistory settings.
+type MetricsHistoryConfig struct {
+	Enabled           bool `yaml:"Enabled"`           // default true
+	ResolutionSeconds int  `yaml:"ResolutionSeconds"` // default 60; one point per interval (CPU averaged over it). Clamped to 5..3600
+	RetentionHours    int  `yaml:"RetentionHours"`    // default 24; older points are dropped. Clamped to 1..720; at most MaxMetricsHistoryPoints points
+	Persist           bool `yaml:"Persist"`           // default false; keep the history in {DeployBase}/metrics/history.json across restarts
+}
+
+// HardwareConfig holds nested Maintenance.Hardware settings.
+type HardwareConfig struct {
+	SerialRedaction   string `yaml:"SerialRedaction"`   // "none" (default), "omit" (serials left empty) or "hash" (hmac-sha256:<16 hex>, keyed per deployment)
+	SerialHashKey     string `yaml:"SerialHashKey"`     // HMAC key for "hash"; empty = the DiscoveryAuth secret
+	SerialHashKeyFile string `yaml:"SerialHashKeyFile"` // alternative to SerialHashKey: file whose trimmed content is the key (takes precedence)
+}
+
+// ResolveSerialHashKey returns the HMAC key of SerialRedaction "hash" ("" for the other modes): SerialHashKeyFile,
+// then SerialHashKey, then the DiscoveryAuth secret, so one deployment secret can serve both. Without a key a
+// serial's hash could be reversed by trying every serial of the vendor's format.
+func (c *Config) ResolveSerialHashKey() (string, error) {
+	h := c.Hardware
+	if h.SerialRedaction != "hash" {
+		return "", nil
+	}
+	if p := strings.TrimSpace(h.SerialHashKeyFile); p != "" {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return "", fmt.Errorf("Hardware.SerialHashKeyFile: %w", err)
+		}
+		k := strings.TrimSpace(string(b))
+		if k == "" {
+			return "", fmt.Errorf("Hardware.SerialHashKeyFile: %s is empty", p)
+		}
+		return k, nil
+	}
+	if k := strings.TrimSpace(h.SerialHashKey); k != "" {
+		return k, nil
+	}
+	k, err := c.DiscoveryAuth.ResolveSecret()
+	if err != nil {
+		return "", err
+	}
+	if k == "" {
+		return "", fmt.Errorf("Hardware: SerialRedaction hash needs SerialHashKey, SerialHashKeyFile or a DiscoveryAuth secret")
+	}
+	return k, nil
+}
+
+// MaxMetricsHistoryPoints bounds RetentionHours / ResolutionSeconds (about 10 MB of points).
+const MaxMetricsHistoryPoints = 20160
+
+// DiscoveryLimitsConfig holds nested Maintenance.DiscoveryLimits settings. A rate of 0 disables that bucket.
+type DiscoveryLimitsConfig struct {
+	PerSourcePerSecond float64  `yaml:"PerSourcePerSecond"` // default 2; answers per second to one requester IP
+	PerSourceBurst     int      `yaml:"PerSourceBurst"`     // default 10; at least 1
+	GlobalPerSecond    float64  `yaml:"GlobalPerSecond"`    // default 50; answers per second to all requesters together
+	GlobalBurst        int      `yaml:"GlobalBurst"`        // default 100; at least 1
+	ReplyPort          string   `yaml:"ReplyPort"`          // "any" (default, honour reply_udp_port), "source" (always answer the source port) or "range"
+	ReplyPortMin       int      `yaml:"ReplyPortMin"`       // "range" only; default 1024
+	ReplyPortMax       int      `yaml:"ReplyPortMax"`       // "range" only; default 65535
+	AllowedSources     []string `yaml:"AllowedSources"`     // requester CIDRs or IPs; empty = any source
+}
+
+// DiscoveryAuthConfig holds nested Maintenance.DiscoveryAuth settings.
+type DiscoveryAuthConfig struct {
+	SharedSecret        string `yaml:"SharedSecret"`        // non-empty enables signed mode
+	SharedSecretFile    string `yaml:"SharedSecretFile"`    // alternative to SharedSecret: file whose trimmed content is the secret (takes precedence)
+	MaxClockSkewSeconds int    `yaml:"MaxClockSkewSeconds"` // default 30; packets whose ts differs more than this are dropped as stale
+}
+
+// ResolveSecret returns the shared secret ("" when signed mode is off). SharedSecretFile wins over SharedSecret.
+func (a DiscoveryAuthConfig) ResolveSecret() (string, error) {
+	if p := strings.TrimSpace(a.SharedSecretFile); p != "" {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return "", fmt.Errorf("DiscoveryAuth.SharedSecretFile: %w", err)
+		}
+		s := strings.TrimSpace(string(b))
+		if s == "" {
+			return "", fmt.Errorf("DiscoveryAuth.SharedSecretFile: %s is empty", p)
+		}
+		return s, nil
+	}
+	return strings.TrimSpace(a.SharedSecret), nil
 }
 
 // RemoteHealthConfig holds nested Maintenance.RemoteHealth settings.
@@ -61,6 +207,12 @@ type ServerConfig struct {
 // DefaultDiscoveryServiceName is the default DISCOVERY_REQUEST `service` value (must match Maintenance.DiscoveryServiceName).
 const DefaultDiscoveryServiceName = "Mole-Discovery"
 
+// DefaultDiscoveryIPv6Group is the default Maintenance.DiscoveryIPv6Group (same value as discovery.DefaultIPv6Group).
+const DefaultDiscoveryIPv6Group = "ff02::6d6f:6c65"
+
+// DefaultDiscoveryMulticastGroup is the default Maintenance.DiscoveryMulticastGroup (same value as discovery.DefaultMulticastGroup).
+const DefaultDiscoveryMulticastGroup = "239.255.77.79"
+
 // DefaultMaxUploadBytes is the default max POST body size for /upload and multipart apply-update (same as the former maxUploadBytes constant).
 const DefaultMaxUploadBytes = 64 << 20
 
@@ -77,6 +229,11 @@ func Default() Config {
 		APIPrefix:                 "/api/v1",
 		DiscoveryTimeoutSeconds:   10,
 		DiscoveryDeduplicate:      true,
+		DiscoveryIPv6Group:        DefaultDiscoveryIPv6Group,
+		DiscoveryMode:             "broadcast",
+		DiscoveryMulticastGroup:   DefaultDiscoveryMulticastGroup,
+		DiscoveryMulticastTTL:     1,
+		DiscoveryStaticPeersConcurrency: 32,
 		SystemctlServiceName:      "contrabass-mole.service",
 		DeployBase:                "/var/lib/contrabass/mole",
 		SSHPort:                   22,
@@ -88,8 +245,48 @@ func Default() Config {
 			FailureThreshold: 3,
 			JitterSeconds:    2,
 		},
+		DiscoveryAuth: DiscoveryAuthConfig{
+			MaxClockSkewSeconds: 30,
+		},
+		Presence: PresenceConfig{
+			Enabled:          true,
+			HeartbeatSeconds: 30,
+		},
+		DiscoveryRelay: DiscoveryRelayConfig{
+			MaxHops: 2,
+		},
+		DiscoveryMDNS: DiscoveryMDNSConfig{
+			Advertise:     true,
+			BrowseSeconds: 2,
+		},
+		MetricsHistory: MetricsHistoryConfig{
+			Enabled:           true,
+			ResolutionSeconds: 60,
+			RetentionHours:    24,
+		},
+		Hardware: HardwareConfig{
+			SerialRedaction: "none",
+		},
+		DiscoveryLimits: DiscoveryLimitsConfig{
+			PerSourcePerSecond: 2,
+			PerSourceBurst:     10,
+			GlobalPerSecond:    50,
+			GlobalBurst:        100,
+			ReplyPort:          "any",
+			ReplyPortMin:       1024,
+			ReplyPortMax:       65535,
+		},
 	}
 	normalizeRemoteHealthCheck(&c)
+	normalizeDiscoveryAuth(&c)
+	normalizeDiscoveryMode(&c)
+	normalizePresence(&c)
+	normalizeDiscoveryRelay(&c)
+	_ = normalizeDiscoveryLimits(&c)
+	_ = normalizeDiscoveryInterfaces(&c)
+	_ = normalizeDiscoveryMDNS(&c)
+	_ = normalizeMetricsHistory(&c)
+	_ = normalizeHardware(&c)
 	return c
 }
 
@@ -114,6 +311,28 @@ func LoadFromBytes(data []byte) (*Config, error) {
 	}
 	f.Maintenance.ServerHTTPPort = f.Server.HTTPPort
 	normalizeRemoteHealthCheck(&f.Maintenance)
+	normalizeDiscoveryAuth(&f.Maintenance)
+	normalizeDiscoveryMode(&f.Maintenance)
+	normalizePresence(&f.Maintenance)
+	normalizeDiscoveryRelay(&f.Maintenance)
+	if err := normalizeDiscoveryLimits(&f.Maintenance); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+	if err := normalizeDiscoveryInterfaces(&f.Maintenance); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+	if err := normalizeDiscoveryMDNS(&f.Maintenance); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+	if err := normalizeMetricsHistory(&f.Maintenance); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+	if err := normalizeHardware(&f.Maintenance); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+	if err := normalizeLabels(&f.Maintenance); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
 	return &f.Maintenance, nil
 }
 
@@ -146,6 +365,240 @@ func normalizeRemoteHealthCheck(c *Config) {
 	}
 }
 
+// normalizeDiscoveryAuth applies defaults and sane bounds after YAML load.
+func normalizeDiscoveryAuth(c *Config) {
+	da := &c.DiscoveryAuth
+	if da.MaxClockSkewSeconds <= 0 {
+		da.MaxClockSkewSeconds = 30
+	}
+	if da.MaxClockSkewSeconds > 3600 {
+		da.MaxClockSkewSeconds = 3600
+	}
+}
+
+// normalizeDiscoveryMode applies defaults for DiscoveryMode, group addresses, TTL and static peer concurrency after YAML load.
+// An unknown DiscoveryMode is kept as written so the service can reject it at startup.
+func normalizeDiscoveryMode(c *Config) {
+	c.DiscoveryMode = strings.ToLower(strings.TrimSpace(c.DiscoveryMode))
+	if c.DiscoveryMode == "" {
+		c.DiscoveryMode = "broadcast"
+	}
+	if strings.TrimSpace(c.DiscoveryMulticastGroup) == "" {
+		c.DiscoveryMulticastGroup = DefaultDiscoveryMulticastGroup
+	}
+	if c.DiscoveryMulticastTTL <= 0 {
+		c.DiscoveryMulticastTTL = 1
+	}
+	if c.DiscoveryMulticastTTL > 255 {
+		c.DiscoveryMulticastTTL = 255
+	}
+	if strings.TrimSpace(c.DiscoveryIPv6Group) == "" {
+		c.DiscoveryIPv6Group = DefaultDiscoveryIPv6Group
+	}
+	if c.DiscoveryStaticPeersConcurrency <= 0 {
+		c.DiscoveryStaticPeersConcurrency = 32
+	}
+	if c.DiscoveryStaticPeersConcurrency > 256 {
+		c.DiscoveryStaticPeersConcurrency = 256
+	}
+}
+
+// normalizePresence applies defaults and sane bounds after YAML load.
+func normalizePresence(c *Config) {
+	p := &c.Presence
+	if p.HeartbeatSeconds <= 0 {
+		p.HeartbeatSeconds = 30
+	}
+	if p.HeartbeatSeconds < 5 {
+		p.HeartbeatSeconds = 5
+	}
+	if p.HeartbeatSeconds > 3600 {
+		p.HeartbeatSeconds = 3600
+	}
+}
+
+// normalizeDiscoveryRelay applies defaults and sane bounds after YAML load.
+func normalizeDiscoveryRelay(c *Config) {
+	r := &c.DiscoveryRelay
+	if r.MaxHops <= 0 {
+		r.MaxHops = 2
+	}
+	if r.MaxHops > 8 {
+		r.MaxHops = 8
+	}
+}
+
+// normalizeDiscoveryLimits applies defaults and bounds, and rejects an unknown ReplyPort mode or an empty port range.
+func normalizeDiscoveryLimits(c *Config) error {
+	l := &c.DiscoveryLimits
+	if l.PerSourcePerSecond < 0 {
+		l.PerSourcePerSecond = 0
+	}
+	if l.GlobalPerSecond < 0 {
+		l.GlobalPerSecond = 0
+	}
+	if l.PerSourceBurst < 1 {
+		l.PerSourceBurst = 1
+	}
+	if l.GlobalBurst < 1 {
+		l.GlobalBurst = 1
+	}
+	l.ReplyPort = strings.ToLower(strings.TrimSpace(l.ReplyPort))
+	switch l.ReplyPort {
+	case "":
+		l.ReplyPort = "any"
+	case "any", "source":
+	case "range":
+		if l.ReplyPortMin <= 0 {
+			l.ReplyPortMin = 1024
+		}
+		if l.ReplyPortMax <= 0 || l.ReplyPortMax > 65535 {
+			l.ReplyPortMax = 65535
+		}
+		if l.ReplyPortMin > l.ReplyPortMax {
+			return fmt.Errorf("DiscoveryLimits: ReplyPortMin %d is greater than ReplyPortMax %d", l.ReplyPortMin, l.ReplyPortMax)
+		}
+	default:
+		return fmt.Errorf("DiscoveryLimits: ReplyPort %q is not one of any, source, range", l.ReplyPort)
+	}
+	return nil
+}
+
+// normalizeDiscoveryInterfaces trims the glob and address lists and rejects a malformed glob or a non-IPv4 broadcast address.
+func normalizeDiscoveryInterfaces(c *Config) error {
+	clean := func(field string, in []string) ([]string, error) {
+		var out []string
+		for _, p := range in {
+			p = strings.TrimSpace(p)
+			if p == "" {
+				continue
+			}
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("DiscoveryInterfaces.%s: bad pattern %q: %v", field, p, err)
+			}
+			out = append(out, p)
+		}
+		return out, nil
+	}
+	var err error
+	if c.DiscoveryInterfaces.Include, err = clean("Include", c.DiscoveryInterfaces.Include); err != nil {
+		return err
+	}
+	if c.DiscoveryInterfaces.Exclude, err = clean("Exclude", c.DiscoveryInterfaces.Exclude); err != nil {
+		return err
+	}
+	var brds []string
+	for _, a := range c.DiscoveryBroadcastAddresses {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		if ip := net.ParseIP(a); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("DiscoveryBroadcastAddresses: %q is not an IPv4 address", a)
+		}
+		brds = append(brds, a)
+	}
+	c.DiscoveryBroadcastAddresses = brds
+	return nil
+}
+
+// normalizeDiscoveryMDNS clamps BrowseSeconds and rejects an InstanceName that is not one DNS label.
+func normalizeDiscoveryMDNS(c *Config) error {
+	m := &c.DiscoveryMDNS
+	if m.BrowseSeconds <= 0 {
+		m.BrowseSeconds = 2
+	}
+	if m.BrowseSeconds > 10 {
+		m.BrowseSeconds = 10
+	}
+	m.InstanceName = strings.TrimSpace(m.InstanceName)
+	if len(m.InstanceName) > 63 || strings.Contains(m.InstanceName, ".") {
+		return fmt.Errorf("DiscoveryMDNS: InstanceName %q must be at most 63 bytes without dots", m.InstanceName)
+	}
+	return nil
+}
+
+// normalizeMetricsHistory clamps resolution and retention and rejects combinations with too many points.
+func normalizeMetricsHistory(c *Config) error {
+	m := &c.MetricsHistory
+	if m.ResolutionSeconds <= 0 {
+		m.ResolutionSeconds = 60
+	}
+	m.ResolutionSeconds = min(max(m.ResolutionSeconds, 5), 3600)
+	if m.RetentionHours <= 0 {
+		m.RetentionHours = 24
+	}
+	m.RetentionHours = min(m.RetentionHours, 720)
+	if n := m.RetentionHours * 3600 / m.ResolutionSeconds; n > MaxMetricsHistoryPoints {
+		return fmt.Errorf("MetricsHistory: %d hours at %ds resolution is %d points (max %d); raise ResolutionSeconds",
+			m.RetentionHours, m.ResolutionSeconds, n, MaxMetricsHistoryPoints)
+	}
+	return nil
+}
+
+// normalizeHardware lower-cases SerialRedaction and rejects unknown modes, so a typo cannot expose serials.
+// "hash" without any key source is rejected too (the files themselves are read by ResolveSerialHashKey).
+func normalizeHardware(c *Config) error {
+	h := &c.Hardware
+	h.SerialRedaction = strings.ToLower(strings.TrimSpace(h.SerialRedaction))
+	switch h.SerialRedaction {
+	case "":
+		h.SerialRedaction = "none"
+	case "none", "omit":
+	case "hash":
+		if strings.TrimSpace(h.SerialHashKey) == "" && strings.TrimSpace(h.SerialHashKeyFile) == "" &&
+			strings.TrimSpace(c.DiscoveryAuth.SharedSecret) == "" && strings.TrimSpace(c.DiscoveryAuth.SharedSecretFile) == "" {
+			return fmt.Errorf("Hardware: SerialRedaction hash needs SerialHashKey, SerialHashKeyFile or a DiscoveryAuth secret")
+		}
+	default:
+		return fmt.Errorf("Hardware: SerialRedaction %q is not one of none, omit, hash", h.SerialRedaction)
+	}
+	return nil
+}
+
+// Label limits. The per-label limits keep keys and values selector-friendly; maxLabelsJSONBytes bounds all labels
+// together as serialized in DISCOVERY_RESPONSE ("labels" object), so the response fits its UDP budget (about 1300 bytes).
+const (
+	maxLabels          = 32
+	maxLabelKeyLen     = 63
+	maxLabelValueLen   = 128
+	maxLabelsJSONBytes = 512
+)
+
+// labelKeyRegex: letters, digits and . _ / - (no "=", "!", "," or spaces, which the selector syntax uses).
+var labelKeyRegex = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+
+// normalizeLabels trims keys and values and rejects keys or values the discovery label selectors cannot express.
+func normalizeLabels(c *Config) error {
+	if len(c.Labels) == 0 {
+		c.Labels = nil
+		return nil
+	}
+	if len(c.Labels) > maxLabels {
+		return fmt.Errorf("Labels: at most %d labels allowed (got %d)", maxLabels, len(c.Labels))
+	}
+	out := make(map[string]string, len(c.Labels))
+	for k, v := range c.Labels {
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if len(k) > maxLabelKeyLen || !labelKeyRegex.MatchString(k) {
+			return fmt.Errorf("Labels: invalid key %q (letters, digits, '.', '_', '/', '-'; up to %d chars)", k, maxLabelKeyLen)
+		}
+		if len(v) > maxLabelValueLen || strings.ContainsAny(v, ",") {
+			return fmt.Errorf("Labels: invalid value for %q (no ','; up to %d chars)", k, maxLabelValueLen)
+		}
+		out[k] = v
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return fmt.Errorf("Labels: %w", err)
+	}
+	if len(b) > maxLabelsJSONBytes {
+		return fmt.Errorf("Labels: %d bytes as JSON, at most %d allowed (fewer or shorter labels)", len(b), maxLabelsJSONBytes)
+	}
+	c.Labels = out
+	return nil
+}
+
 // configValidationError turns a YAML unmarshal error into a user-friendly message.
 func configValidationError(err error) error {
 	if err == nil {
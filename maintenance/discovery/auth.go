@@ -0,0 +1,217 @@
+package discovery
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultAuthMaxClockSkew is used when NewAuthenticator is given a non-positive skew.
+const DefaultAuthMaxClockSkew = 30 * time.Second
+
+var (
+	errAuthUnsigned = errors.New("unsigned packet")
+	errAuthBadSig   = errors.New("bad signature")
+	errAuthStale    = errors.New("timestamp outside allowed clock skew")
+	errAuthReplayed = errors.New("replayed nonce")
+)
+
+// Authenticator signs and verifies DISCOVERY_REQUEST / DISCOVERY_RESPONSE with a shared secret (HMAC-SHA256).
+// The MAC covers the canonical JSON of the packet without "sig" (keys sorted, as re-marshaled from the wire),
+// so fields added by newer agents are still covered and older receivers verify them unchanged.
+// A nil *Authenticator means unsigned mode (previous behavior): Marshal does not sign and Verify accepts everything.
+type Authenticator struct {
+	secret  []byte
+	maxSkew time.Duration
+
+	mu     sync.Mutex
+	nonces map[string]time.Time // nonce@ts → expiry
+
+	unsigned atomic.Uint64
+	badSig   atomic.Uint64
+	stale    atomic.Uint64
+	replayed atomic.Uint64
+}
+
+// AuthStats counts packets dropped by Verify.
+type AuthStats struct {
+	Unsigned     uint64 `json:"unsigned"`
+	BadSignature uint64 `json:"bad_signature"`
+	Stale        uint64 `json:"stale"`
+	Replayed     uint64 `json:"replayed"`
+}
+
+// NewAuthenticator returns nil when secret is empty (signing disabled).
+// maxSkew bounds |now - ts|; nonces are remembered for twice that window.
+func NewAuthenticator(secret string, maxSkew time.Duration) *Authenticator {
+	if secret == "" {
+		return nil
+	}
+	if maxSkew <= 0 {
+		maxSkew = DefaultAuthMaxClockSkew
+	}
+	return &Authenticator{
+		secret:  []byte(secret),
+		maxSkew: maxSkew,
+		nonces:  make(map[string]time.Time),
+	}
+}
+
+// signable is implemented by DiscoveryRequest, DiscoveryResponse and PresenceMessage.
+type signable interface {
+	setAuth(ts int64, nonce, sig string)
+}
+
+func (r *DiscoveryRequest) setAuth(ts int64, nonce, sig string) {
+	r.Timestamp, r.Nonce, r.Signature = ts, nonce, sig
+}
+
+func (r *DiscoveryResponse) setAuth(ts int64, nonce, sig string) {
+	r.Timestamp, r.Nonce, r.Signature = ts, nonce, sig
+}
+
+func (m *PresenceMessage) setAuth(ts int64, nonce, sig string) {
+	m.Timestamp, m.Nonce, m.Signature = ts, nonce, sig
+}
+
+// Marshal returns the JSON for msg. When a is non-nil, a fresh timestamp, nonce and signature are set on msg first.
+func (a *Authenticator) Marshal(msg signable) ([]byte, error) {
+	if a == nil {
+		return json.Marshal(msg)
+	}
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ts, nonceHex := time.Now().Unix(), hex.EncodeToString(nonce)
+	msg.setAuth(ts, nonceHex, "")
+	unsigned, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := a.mac(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	msg.setAuth(ts, nonceHex, mac)
+	return json.Marshal(msg)
+}
+
+// Resign returns a copy of the signed packet data with a fresh timestamp, nonce and signature (data unchanged when a is nil).
+// Senders that write one message to several destinations resign each copy: a multi-homed host receives one per segment,
+// and Verify accepts a nonce only once whatever the source address.
+func (a *Authenticator) Resign(data []byte) ([]byte, error) {
+	if a == nil {
+		return data, nil
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	ts, _ := json.Marshal(time.Now().Unix())
+	nonceJSON, _ := json.Marshal(hex.EncodeToString(nonce))
+	m["ts"], m["nonce"] = ts, nonceJSON
+	delete(m, "sig")
+	unsigned, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	mac, err := a.mac(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	m["sig"], _ = json.Marshal(mac)
+	return json.Marshal(m)
+}
+
+// Verify checks signature, clock skew and nonce reuse of a raw packet.
+// The replay cache is keyed on nonce and timestamp only: a captured packet re-sent from any other address is still a replay.
+// Failures are counted (see Stats). A nil Authenticator accepts everything.
+func (a *Authenticator) Verify(raw []byte) error {
+	if a == nil {
+		return nil
+	}
+	var head struct {
+		Ts    int64  `json:"ts"`
+		Nonce string `json:"nonce"`
+		Sig   string `json:"sig"`
+	}
+	if err := json.Unmarshal(raw, &head); err != nil {
+		return err
+	}
+	if head.Sig == "" || head.Nonce == "" {
+		a.unsigned.Add(1)
+		return errAuthUnsigned
+	}
+	want, err := a.mac(raw)
+	if err != nil {
+		a.badSig.Add(1)
+		return errAuthBadSig
+	}
+	if !hmac.Equal([]byte(want), []byte(head.Sig)) {
+		a.badSig.Add(1)
+		return errAuthBadSig
+	}
+	now := time.Now()
+	skew := now.Sub(time.Unix(head.Ts, 0))
+	if skew > a.maxSkew || skew < -a.maxSkew {
+		a.stale.Add(1)
+		return errAuthStale
+	}
+	key := head.Nonce + "@" + strconv.FormatInt(head.Ts, 10)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if exp, ok := a.nonces[key]; ok && now.Before(exp) {
+		a.replayed.Add(1)
+		return errAuthReplayed
+	}
+	a.nonces[key] = now.Add(2 * a.maxSkew)
+	if len(a.nonces)%256 == 0 {
+		for k, exp := range a.nonces {
+			if !now.Before(exp) {
+				delete(a.nonces, k)
+			}
+		}
+	}
+	return nil
+}
+
+// Stats returns drop counters. Safe on a nil Authenticator.
+func (a *Authenticator) Stats() AuthStats {
+	if a == nil {
+		return AuthStats{}
+	}
+	return AuthStats{
+		Unsigned:     a.unsigned.Load(),
+		BadSignature: a.badSig.Load(),
+		Stale:        a.stale.Load(),
+		Replayed:     a.replayed.Load(),
+	}
+}
+
+// mac returns hex HMAC-SHA256 over the canonical form of raw (all keys except "sig", sorted).
+func (a *Authenticator) mac(raw []byte) (string, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return "", err
+	}
+	delete(m, "sig")
+	canonical, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	h := hmac.New(sha256.New, a.secret)
+	h.Write(canonical)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
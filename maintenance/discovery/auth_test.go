@@ -0,0 +1,136 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// signAt signs req as Marshal would, but with the given timestamp and nonce.
+func signAt(t *testing.T, a *Authenticator, req DiscoveryRequest, ts int64, nonce string) []byte {
+	t.Helper()
+	req.setAuth(ts, nonce, "")
+	unsigned, err := json.Marshal(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac, err := a.mac(unsigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.setAuth(ts, nonce, mac)
+	data, err := json.Marshal(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testRequest() DiscoveryRequest {
+	return DiscoveryRequest{Type: "DISCOVERY_REQUEST", Service: "contrabass-test", RequestID: "r1", ReplyUDPPort: 9999}
+}
+
+func TestVerify(t *testing.T) {
+	a := NewAuthenticator("s3cret", 30*time.Second)
+	other := NewAuthenticator("other", 30*time.Second)
+	now := time.Now().Unix()
+	unsigned, _ := json.Marshal(testRequest())
+	tampered := signAt(t, a, testRequest(), now, "n-tampered")
+	var m map[string]any
+	_ = json.Unmarshal(tampered, &m)
+	m["reply_udp_port"] = 1
+	tampered, _ = json.Marshal(m)
+
+	tests := []struct {
+		name string
+		raw  []byte
+		want error
+	}{
+		{"signed", signAt(t, a, testRequest(), now, "n-ok"), nil},
+		{"within skew", signAt(t, a, testRequest(), now-20, "n-skew-ok"), nil},
+		{"unsigned", unsigned, errAuthUnsigned},
+		{"other secret", signAt(t, other, testRequest(), now, "n-other"), errAuthBadSig},
+		{"field changed after signing", tampered, errAuthBadSig},
+		{"too old", signAt(t, a, testRequest(), now-60, "n-old"), errAuthStale},
+		{"too far ahead", signAt(t, a, testRequest(), now+60, "n-ahead"), errAuthStale},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := a.Verify(tc.raw); !errors.Is(err, tc.want) {
+				t.Fatalf("Verify = %v, want %v", err, tc.want)
+			}
+		})
+	}
+	want := AuthStats{Unsigned: 1, BadSignature: 2, Stale: 2}
+	if got := a.Stats(); got != want {
+		t.Fatalf("Stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	a := NewAuthenticator("s3cret", 30*time.Second)
+	now := time.Now().Unix()
+	tests := []struct {
+		name string
+		raw  []byte
+		want error
+	}{
+		{"first copy", signAt(t, a, testRequest(), now, "n1"), nil},
+		{"same nonce and ts", signAt(t, a, testRequest(), now, "n1"), errAuthReplayed},
+		{"same nonce, other ts", signAt(t, a, testRequest(), now-1, "n1"), nil},
+		{"other nonce", signAt(t, a, testRequest(), now, "n2"), nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := a.Verify(tc.raw); !errors.Is(err, tc.want) {
+				t.Fatalf("Verify = %v, want %v", err, tc.want)
+			}
+		})
+	}
+	if got := a.Stats().Replayed; got != 1 {
+		t.Fatalf("Stats().Replayed = %d, want 1", got)
+	}
+}
+
+func TestResign(t *testing.T) {
+	a := NewAuthenticator("s3cret", 30*time.Second)
+	req := testRequest()
+	data, err := a.Marshal(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Verify(data); err != nil {
+		t.Fatalf("Verify(original) = %v", err)
+	}
+	if err := a.Verify(data); !errors.Is(err, errAuthReplayed) {
+		t.Fatalf("Verify(original again) = %v, want %v", err, errAuthReplayed)
+	}
+	// One copy per destination: each is a fresh packet to a multi-homed receiver sharing one replay cache.
+	for i := 0; i < 3; i++ {
+		resigned, err := a.Resign(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Verify(resigned); err != nil {
+			t.Fatalf("Verify(copy %d) = %v", i, err)
+		}
+		var got DiscoveryRequest
+		if err := json.Unmarshal(resigned, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Nonce == req.Nonce {
+			t.Fatalf("copy %d kept the original nonce", i)
+		}
+		if got.RequestID != req.RequestID || got.Service != req.Service || got.ReplyUDPPort != req.ReplyUDPPort {
+			t.Fatalf("copy %d changed the payload: %+v", i, got)
+		}
+	}
+	if other := NewAuthenticator("other", 0); other.Verify(data) == nil {
+		t.Fatal("a packet signed with another secret verified")
+	}
+	var unsigned *Authenticator
+	if out, err := unsigned.Resign(data); err != nil || string(out) != string(data) {
+		t.Fatalf("nil Resign changed the data (err %v)", err)
+	}
+}
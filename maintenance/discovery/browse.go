@@ -0,0 +1,42 @@
+package discovery
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// SourceMDNS marks a result found by an mDNS browse (DiscoveryResponse.Source).
+const SourceMDNS = "mdns"
+
+// HostBrowser looks for hosts by other means than DISCOVERY_REQUEST. It must return by ctx's end or within timeout.
+type HostBrowser func(ctx context.Context, timeout time.Duration) []DiscoveryResponse
+
+// startBrowse runs Config.Browser next to a run and hands its hosts to the run's pending channel when it returns.
+// Arriving last, they are kept only for hosts that did not answer over UDP (includeInDiscoveryResults).
+func (d *Discovery) startBrowse(ctx context.Context, requestID string, timeout time.Duration) {
+	if d.cfg.Browser == nil {
+		return
+	}
+	go func() {
+		found := d.cfg.Browser(ctx, timeout)
+		delivered := 0
+		// Same rule as handleResponse: send under mu, the run closes its channel under mu.
+		d.mu.Lock()
+		if ch := d.pending[requestID]; ch != nil {
+			for i := range found {
+				r := found[i]
+				r.RequestID = requestID
+				select {
+				case ch <- &r:
+					delivered++
+				default:
+				}
+			}
+		}
+		d.mu.Unlock()
+		if len(found) > 0 {
+			log.Printf("discovery: requestID=%s browse found %d host(s), %d delivered", requestID, len(found), delivered)
+		}
+	}()
+}
@@ -0,0 +1,85 @@
+package discovery
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ProtoVersion is the discovery/agent protocol version carried in proto_version (requests, responses and GET /self).
+// Agents that predate it send no proto_version (0): their capabilities are unknown and callers assume the legacy feature set.
+const ProtoVersion = 2
+
+// Capabilities advertised in the capabilities list. Names are stable wire strings; add new ones, never rename.
+const (
+	CapReplyUDPPort      = "reply-udp-port"      // honours DiscoveryRequest.reply_udp_port
+	CapBundleUpload      = "bundle-upload"       // POST {API}/upload accepts a tar.gz bundle (field "bundle")
+	CapApplyUpdateBundle = "apply-update-bundle" // POST {API}/apply-update accepts multipart bundle + ip (remote apply)
+	CapSwitchCurrent     = "switch-current"      // POST {API}/versions/switch-current
+	CapSSE               = "sse"                 // GET {API}/discovery/stream (server-sent events)
+	CapPresence          = "presence"            // DISCOVERY_HELLO / HEARTBEAT / BYE and GET {API}/peers
+	CapHostRegistry      = "host-registry"       // GET {API}/registry/*
+	CapIPv6Discovery     = "ipv6-discovery"      // IPv6 link-local multicast discovery and zoned unicast
+)
+
+// Features are the optional capabilities an agent actually runs (its config, not just its binary).
+type Features struct {
+	Presence      bool // sends HELLO / HEARTBEAT / BYE (Maintenance.Presence.Enabled)
+	HostRegistry  bool // keeps the host registry served at GET {API}/registry/*
+	IPv6Discovery bool // listens on the IPv6 discovery group (Maintenance.DiscoveryIPv6 and the udp6 socket opened)
+}
+
+// Capabilities returns the capabilities of an agent running f, in a fixed order.
+// The zero Features (e.g. a CLI client) advertises only what every build supports.
+func Capabilities(f Features) []string {
+	caps := []string{
+		CapReplyUDPPort,
+		CapBundleUpload,
+		CapApplyUpdateBundle,
+		CapSwitchCurrent,
+		CapSSE,
+	}
+	if f.Presence {
+		caps = append(caps, CapPresence)
+	}
+	if f.HostRegistry {
+		caps = append(caps, CapHostRegistry)
+	}
+	if f.IPv6Discovery {
+		caps = append(caps, CapIPv6Discovery)
+	}
+	return caps
+}
+
+// Capabilities returns the capabilities advertised in this agent's requests and responses (Config.Features).
+func (d *Discovery) Capabilities() []string {
+	return Capabilities(d.cfg.Features)
+}
+
+// HasCapability reports whether a peer advertising (proto, caps) supports c.
+// Legacy peers (proto 0) report true for CapReplyUDPPort, CapBundleUpload, CapApplyUpdateBundle and CapSwitchCurrent,
+// which every released agent already handles, and false for everything else.
+func HasCapability(proto int, caps []string, c string) bool {
+	if proto <= 0 {
+		switch c {
+		case CapReplyUDPPort, CapBundleUpload, CapApplyUpdateBundle, CapSwitchCurrent:
+			return true
+		}
+		return false
+	}
+	for _, v := range caps {
+		if strings.EqualFold(v, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckCapabilities returns an error naming the first of want the peer does not advertise (nil when all are supported).
+func CheckCapabilities(proto int, caps []string, want ...string) error {
+	for _, c := range want {
+		if !HasCapability(proto, caps, c) {
+			return fmt.Errorf("agent (proto_version %d) does not support %q", proto, c)
+		}
+	}
+	return nil
+}
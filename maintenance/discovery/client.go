@@ -7,7 +7,8 @@ import (
 
 // MatchDiscoveryResponseUDP parses buf[:n] as JSON; if it is a DISCOVERY_RESPONSE
 // matching requestID and service, returns (resp, true) with RespondedFromIP set from from.
-func MatchDiscoveryResponseUDP(buf []byte, n int, from *net.UDPAddr, requestID, service string) (DiscoveryResponse, bool) {
+// When auth is non-nil, unsigned, stale or replayed responses are rejected (and counted by auth).
+func MatchDiscoveryResponseUDP(buf []byte, n int, from *net.UDPAddr, requestID, service string, auth *Authenticator) (DiscoveryResponse, bool) {
 	if n <= 0 || from == nil {
 		return DiscoveryResponse{}, false
 	}
@@ -24,6 +25,10 @@ func MatchDiscoveryResponseUDP(buf []byte, n int, from *net.UDPAddr, requestID,
 	if resp.RequestID != requestID || resp.Service != service {
 		return DiscoveryResponse{}, false
 	}
-	resp.RespondedFromIP = from.IP.String()
+	if auth.Verify(buf[:n]) != nil {
+		return DiscoveryResponse{}, false
+	}
+	resp.setAuth(0, "", "")
+	resp.RespondedFromIP = respondedFrom(from)
 	return resp, true
 }
@@ -53,7 +53,9 @@ func OpenDiscoveryClientUDP(srcPort int, broadcastAddrs []string) ([]*net.UDPCon
 }
 
 func unionLocalIPsForBroadcastStrings(broadcastAddrs []string) []net.IP {
-	uniq := make(map[string]net.IP)
+	// Keep first-seen order so conns[0] (fallback sender) is deterministic.
+	seen := make(map[string]bool)
+	var out []net.IP
 	for _, s := range broadcastAddrs {
 		b := net.ParseIP(s)
 		if b == nil {
@@ -64,22 +66,30 @@ func unionLocalIPsForBroadcastStrings(broadcastAddrs []string) []net.IP {
 			continue
 		}
 		for _, ip := range LocalIPsInSubnet(b) {
-			uniq[ip.String()] = ip
+			if !seen[ip.String()] {
+				seen[ip.String()] = true
+				out = append(out, ip)
+			}
 		}
 	}
-	out := make([]net.IP, 0, len(uniq))
-	for _, ip := range uniq {
-		out = append(out, ip)
-	}
 	return out
 }
 
 // SendDiscoveryClientBroadcast sends the same payload to each broadcast:destPort using the same
-// per-interface rules as the agent (LocalIPsInSubnet + matching conn, else conns[0]).
-func SendDiscoveryClientBroadcast(conns []*net.UDPConn, payload []byte, destPort int, broadcastAddrs []string) error {
+// per-interface rules as the agent (LocalIPsInSubnet / MatchLocalIPs + matching conn, else conns[0]).
+// With auth set, every copy is re-signed with its own nonce (see Authenticator.Resign).
+func SendDiscoveryClientBroadcast(conns []*net.UDPConn, auth *Authenticator, payload []byte, destPort int, broadcastAddrs []string) error {
 	if len(conns) == 0 {
 		return fmt.Errorf("discovery: no UDP sockets")
 	}
+	write := func(conn *net.UDPConn, addr *net.UDPAddr) error {
+		b, err := auth.Resign(payload)
+		if err != nil {
+			return err
+		}
+		_, err = conn.WriteToUDP(b, addr)
+		return err
+	}
 	for _, brdStr := range broadcastAddrs {
 		addr, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(brdStr, strconv.Itoa(destPort)))
 		if err != nil {
@@ -87,7 +97,7 @@ func SendDiscoveryClientBroadcast(conns []*net.UDPConn, payload []byte, destPort
 		}
 		localIPs := LocalIPsInSubnet(addr.IP)
 		if len(localIPs) == 0 {
-			if _, err := conns[0].WriteToUDP(payload, addr); err != nil {
+			if err := write(conns[0], addr); err != nil {
 				return err
 			}
 			continue
@@ -105,14 +115,14 @@ func SendDiscoveryClientBroadcast(conns []*net.UDPConn, payload []byte, destPort
 				if !ok || la == nil || !la.IP.Equal(lip) {
 					continue
 				}
-				if _, err := conn.WriteToUDP(payload, addr); err != nil {
+				if err := write(conn, addr); err != nil {
 					return err
 				}
 				sent = true
 				break
 			}
 			if !sent {
-				if _, err := conns[0].WriteToUDP(payload, addr); err != nil {
+				if err := write(conns[0], addr); err != nil {
 					return err
 				}
 			}
@@ -0,0 +1,162 @@
+package discovery
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Conflict kinds (Conflict.Kind).
+const (
+	ConflictCPUUUID = "cpu_uuid" // one CPU UUID answered by hosts with different hostnames or MACs (cloned image, shared machine-id)
+	ConflictHostIP  = "host_ip"  // one host_ip answered by hosts with different identities (HostKey)
+)
+
+// ConflictHost is one of the hosts sharing a conflicting identity.
+type ConflictHost struct {
+	Hostname        string `json:"hostname"`
+	HostIP          string `json:"host_ip,omitempty"`
+	CPUUUID         string `json:"cpu_uuid,omitempty"`
+	MAC             string `json:"mac,omitempty"`
+	RespondedFromIP string `json:"responded_from_ip,omitempty"`
+	// IsSelf marks the host the run was started from.
+	IsSelf bool `json:"self,omitempty"`
+}
+
+// Conflict is an identity that more than one host claims: Value is the shared CPU UUID or host IP.
+type Conflict struct {
+	Kind  string         `json:"kind"`
+	Value string         `json:"value"`
+	Hosts []ConflictHost `json:"hosts"`
+}
+
+// ConflictDetector collects the responses of one discovery run and reports duplicate identities.
+// It sees every response of the run, before self exclusion and dedup, since those are exactly what hide a cloned host.
+type ConflictDetector struct {
+	mu     sync.Mutex
+	byUUID map[string][]ConflictHost
+	byIP   map[string]map[string]ConflictHost // host_ip -> HostKey -> host
+	uuids  []string                           // first-seen order
+	ips    []string
+}
+
+// NewConflictDetector returns an empty detector.
+func NewConflictDetector() *ConflictDetector {
+	return &ConflictDetector{
+		byUUID: make(map[string][]ConflictHost),
+		byIP:   make(map[string]map[string]ConflictHost),
+	}
+}
+
+// Add records one response. Responses of the same host (same hostname, and same MAC when both carry one) merge;
+// a host answering from several interfaces is not a conflict. Relayed responses are left out of the host_ip check:
+// private addresses repeat legitimately across routed sites. Browsed (mDNS) results are left out altogether: they
+// repeat a UDP answer under the short host name and carry no signature.
+func (c *ConflictDetector) Add(r *DiscoveryResponse, self bool) {
+	if r.Source == SourceMDNS {
+		return
+	}
+	h := ConflictHost{
+		Hostname:        strings.TrimSpace(r.Hostname),
+		HostIP:          r.HostIP,
+		CPUUUID:         strings.TrimSpace(r.CPUUUID),
+		MAC:             strings.ToLower(strings.TrimSpace(r.MAC)),
+		RespondedFromIP: r.RespondedFromIP,
+		IsSelf:          self,
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if u := strings.ToLower(h.CPUUUID); u != "" {
+		hosts, ok := c.byUUID[u]
+		if !ok {
+			c.uuids = append(c.uuids, u)
+		}
+		merged := false
+		for i := range hosts {
+			if sameHost(&hosts[i], &h) {
+				if hosts[i].MAC == "" {
+					hosts[i].MAC = h.MAC
+				}
+				hosts[i].IsSelf = hosts[i].IsSelf || self
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			hosts = append(hosts, h)
+		}
+		c.byUUID[u] = hosts
+	}
+	if h.HostIP != "" && len(r.RelayPath) == 0 {
+		m, ok := c.byIP[h.HostIP]
+		if !ok {
+			m = make(map[string]ConflictHost)
+			c.byIP[h.HostIP] = m
+			c.ips = append(c.ips, h.HostIP)
+		}
+		key := HostKey(r)
+		if prev, ok := m[key]; ok {
+			h.IsSelf = h.IsSelf || prev.IsSelf
+		}
+		m[key] = h
+	}
+}
+
+// sameHost: equal hostnames (case-insensitive) and no differing MACs.
+func sameHost(a, b *ConflictHost) bool {
+	if !strings.EqualFold(a.Hostname, b.Hostname) {
+		return false
+	}
+	return a.MAC == "" || b.MAC == "" || a.MAC == b.MAC
+}
+
+// Conflicts returns the identities claimed by more than one host, CPU UUID conflicts first, in first-seen order.
+func (c *ConflictDetector) Conflicts() []Conflict {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := []Conflict{}
+	for _, u := range c.uuids {
+		if hosts := c.byUUID[u]; len(hosts) > 1 {
+			out = append(out, Conflict{Kind: ConflictCPUUUID, Value: hosts[0].CPUUUID, Hosts: append([]ConflictHost(nil), hosts...)})
+		}
+	}
+	for _, ip := range c.ips {
+		m := c.byIP[ip]
+		if len(m) < 2 {
+			continue
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		cf := Conflict{Kind: ConflictHostIP, Value: ip}
+		for _, k := range keys {
+			cf.Hosts = append(cf.Hosts, m[k])
+		}
+		out = append(out, cf)
+	}
+	return out
+}
+
+// primaryMAC returns the hardware address of the interface holding ip (the host's primary address from the getter), or "".
+// Only that interface is used so that a multi-homed host reports the same MAC on every reply path.
+func (d *Discovery) primaryMAC(ip string) string {
+	want := net.ParseIP(ip)
+	if want == nil {
+		return ""
+	}
+	ifaces, err := d.network().Interfaces()
+	if err != nil {
+		return ""
+	}
+	for _, ifi := range ifaces {
+		for _, a := range ifi.Addrs {
+			if a.IP.Equal(want) && len(ifi.HardwareAddr) > 0 {
+				return ifi.HardwareAddr.String()
+			}
+		}
+	}
+	return ""
+}
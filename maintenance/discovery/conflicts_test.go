@@ -0,0 +1,106 @@
+package discovery_test
+
+import (
+	"context"
+	"testing"
+
+	"contrabass-agent/maintenance/discovery"
+)
+
+// conflictsOf runs one discovery from d and returns the conflicts it reported.
+func conflictsOf(t *testing.T, d *discovery.Discovery) []discovery.Conflict {
+	t.Helper()
+	_, conflicts, err := d.DoDiscovery(context.Background(), discovery.DiscoveryRunOptions{Timeout: testTimeout})
+	if err != nil {
+		t.Fatalf("DoDiscovery: %v", err)
+	}
+	return conflicts
+}
+
+func TestConflictSameUUIDDifferentHostnames(t *testing.T) {
+	lan, a, _ := newLAN(t)
+	req := addHost(t, lan, "requester", a, "10.0.1.10")
+	web1 := addHost(t, lan, "web1", a, "10.0.1.21")
+	web2 := addHost(t, lan, "web2", a, "10.0.1.22")
+	startAgent(t, web1, "uuid-clone", "10.0.1.21", discovery.Config{})
+	startAgent(t, web2, "uuid-clone", "10.0.1.22", discovery.Config{})
+	d := startAgent(t, req, "uuid-req", "10.0.1.10", discovery.Config{DiscoveryBroadcastAddresses: []string{a.Broadcast()}})
+
+	conflicts := conflictsOf(t, d)
+	if len(conflicts) != 1 {
+		t.Fatalf("conflicts = %+v, want one", conflicts)
+	}
+	c := conflicts[0]
+	if c.Kind != discovery.ConflictCPUUUID || c.Value != "uuid-clone" || len(c.Hosts) != 2 {
+		t.Fatalf("conflict = %+v, want cpu_uuid uuid-clone with two hosts", c)
+	}
+	if c.Hosts[0].Hostname == c.Hosts[1].Hostname {
+		t.Errorf("conflicting hosts share hostname %q", c.Hosts[0].Hostname)
+	}
+}
+
+func TestConflictSameUUIDDifferentMACs(t *testing.T) {
+	lan, a, _ := newLAN(t)
+	req := addHost(t, lan, "requester", a, "10.0.1.10")
+	// Same image cloned twice: hostname and CPU UUID match, only the NICs differ.
+	clone1 := addHost(t, lan, "clone", a, "10.0.1.21")
+	clone2 := addHost(t, lan, "clone", a, "10.0.1.22")
+	startAgent(t, clone1, "uuid-clone", "10.0.1.21", discovery.Config{})
+	startAgent(t, clone2, "uuid-clone", "10.0.1.22", discovery.Config{})
+	d := startAgent(t, req, "uuid-req", "10.0.1.10", discovery.Config{DiscoveryBroadcastAddresses: []string{a.Broadcast()}})
+
+	conflicts := conflictsOf(t, d)
+	if len(conflicts) != 1 {
+		t.Fatalf("conflicts = %+v, want one", conflicts)
+	}
+	c := conflicts[0]
+	if c.Kind != discovery.ConflictCPUUUID || len(c.Hosts) != 2 {
+		t.Fatalf("conflict = %+v, want cpu_uuid with two hosts", c)
+	}
+	if m0, m1 := c.Hosts[0].MAC, c.Hosts[1].MAC; m0 == "" || m1 == "" || m0 == m1 {
+		t.Errorf("conflicting hosts carry MACs %q and %q, want two different ones", m0, m1)
+	}
+}
+
+func TestConflictSameHostIPDifferentUUIDs(t *testing.T) {
+	lan, a, b := newLAN(t)
+	req := addHost(t, lan, "requester", a, "10.0.1.10", b, "10.0.2.10")
+	owner := addHost(t, lan, "owner", a, "10.0.1.20")
+	// A host on the other segment that claims owner's address (stale static config behind NAT, say).
+	stale := addHost(t, lan, "stale", b, "10.0.2.30")
+	startAgent(t, owner, "uuid-owner", "10.0.1.20", discovery.Config{})
+	impostor(t, stale, discovery.DiscoveryResponse{Hostname: "stale", HostIP: "10.0.1.20", CPUUUID: "uuid-stale"})
+	d := startAgent(t, req, "uuid-req", "10.0.1.10", discovery.Config{
+		DiscoveryBroadcastAddresses: []string{a.Broadcast(), b.Broadcast()},
+	})
+
+	conflicts := conflictsOf(t, d)
+	if len(conflicts) != 1 {
+		t.Fatalf("conflicts = %+v, want one", conflicts)
+	}
+	c := conflicts[0]
+	if c.Kind != discovery.ConflictHostIP || c.Value != "10.0.1.20" || len(c.Hosts) != 2 {
+		t.Fatalf("conflict = %+v, want host_ip 10.0.1.20 with two hosts", c)
+	}
+}
+
+func TestNoConflictForMultiHomedHost(t *testing.T) {
+	lan, a, b := newLAN(t)
+	req := addHost(t, lan, "requester", a, "10.0.1.10", b, "10.0.2.10")
+	multi := addHost(t, lan, "multi", a, "10.0.1.20", b, "10.0.2.20")
+	startAgent(t, multi, "uuid-multi", "10.0.1.20", discovery.Config{})
+	d := startAgent(t, req, "uuid-req", "10.0.1.10", discovery.Config{
+		DiscoveryBroadcastAddresses: []string{a.Broadcast(), b.Broadcast()},
+	})
+
+	list, conflicts, err := d.DoDiscovery(context.Background(), discovery.DiscoveryRunOptions{Timeout: testTimeout})
+	if err != nil {
+		t.Fatalf("DoDiscovery: %v", err)
+	}
+	if n := len(from(list, "multi")); n != 2 {
+		t.Fatalf("multi answered %d time(s), want once per subnet", n)
+	}
+	if len(conflicts) != 0 {
+		t.Fatalf("multi-homed host reported as conflict: %+v", conflicts)
+	}
+}
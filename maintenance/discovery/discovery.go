@@ -1,6 +1,8 @@
 package discovery
 
 import (
+	"container/list"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -15,7 +17,7 @@ import (
 type HostInfoGetter func() (hostname, hostIP, cpuInfo string, cpuUsage float64, memTotalMB, memUsedMB uint64, memUsagePct float64, cpuUUID string)
 
 // Config holds discovery-related config.
-// DiscoveryBroadcastAddresses must have at least one element.
+// DiscoveryBroadcastAddresses must have at least one element unless Mode is ModeMulticast.
 type Config struct {
 	DiscoveryServiceName        string
 	DiscoveryBroadcastAddresses []string // one or more broadcast addresses to send DISCOVERY_REQUEST to
@@ -24,56 +26,120 @@ type Config struct {
 	DiscoveryDeduplicate        bool
 	Version                     string
 	ServicePort                 int
+	// Auth enables HMAC-signed discovery (nil = unsigned, previous behavior). Unsigned, stale or replayed packets are dropped.
+	Auth *Authenticator
+	// IPv6Group (ff02::…) and IPv6Interfaces enable IPv6 link-local multicast discovery alongside broadcast:
+	// requests are also sent to IPv6Group%iface for each interface. Requires a udp6 conn (OpenIPv6DiscoveryUDP). nil = IPv4 only.
+	IPv6Group      net.IP
+	IPv6Interfaces []string
+	// Mode selects IPv4 destinations: ModeBroadcast (default, DiscoveryBroadcastAddresses), ModeMulticast (MulticastGroup) or ModeBoth.
+	// In multicast modes the caller joins MulticastGroup on the 0.0.0.0 conn (JoinIPv4Group) and sets the TTL (SetMulticastTTL).
+	Mode           string
+	MulticastGroup net.IP
+	// Observer, when set, is told about every DISCOVERY_RESPONSE to a pending run and every presence message received (e.g. the persistent host registry).
+	Observer HostObserver
+	// Labels of this agent, matched against label selectors in a request's filter.
+	Labels map[string]string
+	// RelayPeers are agents in other routed subnets: every DoDiscovery / DoDiscoveryStream run is also sent to them by unicast
+	// (RelayRequestType) and their relayed responses are merged into the run. RelayMaxHops bounds onward forwarding (default DefaultRelayMaxHops).
+	RelayPeers   []*net.UDPAddr
+	RelayMaxHops int
+	// RelayAccept makes this agent act as a relay for RelayRequestType packets from other agents: any verified sender in signed mode
+	// (Auth set), otherwise only RelayPeers. At most maxRelayedResponses answers are sent back per relayed request.
+	RelayAccept bool
+	// StaticPeers are probed by unicast on every DoDiscovery / DoDiscoveryStream run, next to the broadcast (see ExpandStaticPeers).
+	// StaticPeerConcurrency bounds writes in flight (default DefaultStaticPeerConcurrency).
+	StaticPeers           []string
+	StaticPeerConcurrency int
+	// Limits rate-limits and restricts DISCOVERY_REQUEST / RelayRequestType answers (zero value = no limits).
+	Limits Limits
+	// Network is the host's interfaces and routing (nil = SystemNetwork). Set it to a simnet.Host, with simnet conns, to run in a simulated LAN.
+	Network Network
+	// Summary, when set, supplies the compact inventory put in every DISCOVERY_RESPONSE (e.g. SummaryOf(hostinfo.GetInventory())).
+	Summary func() *HostSummary
+	// Features are the optional features this agent runs, advertised in capabilities (see Capabilities).
+	Features Features
+	// Browser, when set, is an extra source of hosts called once per DoDiscovery / DoDiscoveryStream run (e.g. mDNS, see browse.go).
+	Browser HostBrowser
+}
+
+// HostObserver receives every host this agent hears from. Calls are made from the Run loop and must not block.
+type HostObserver interface {
+	ObserveResponse(resp DiscoveryResponse)
+	ObservePresence(msg PresenceMessage, respondedFrom string)
 }
 
 // Discovery handles UDP discovery (listen + respond, and run discovery).
 type Discovery struct {
 	cfg    Config
-	conns  []*net.UDPConn // conns[0] = main (:9999), rest = per-localIP (:9999) with SO_REUSEPORT so we can send from each and receive responses on :9999
 	getter HostInfoGetter
 
+	// connMu guards conns, cfg.DiscoveryBroadcastAddresses and running: per-IP sockets and broadcast addresses follow
+	// address changes at runtime (AddConn, RemoveConn, SetBroadcastAddresses).
+	connMu  sync.RWMutex
+	conns   []PacketConn // conns[0] = main (:9999), rest = per-localIP (:9999) with SO_REUSEPORT so we can send from each and receive responses on :9999; optionally one udp6 [::]:9999
+	running bool         // Run started: AddConn starts a reader for the new conn
+	recv    chan received
+
 	mu      sync.Mutex
 	pending map[string]chan *DiscoveryResponse
+
+	peerMu sync.Mutex
+	peers  map[string]*Peer // CPU UUID (lowercase) or "noid:hostname@ip" → presence state
+
+	selfOnce sync.Once
+	selfUUID string
+
+	relayMu   sync.Mutex
+	relaySeen map[string]time.Time // request IDs originated or relayed here (loop prevention)
+
+	limitMu   sync.Mutex
+	buckets   map[string]*list.Element // requester IP → element of bucketLRU holding its *sourceBucket (Limits.PerSourceRate)
+	bucketLRU *list.List
+	global    tokenBucket // Limits.GlobalRate
+	counters  responderCounters
+
+	stats *statsRecorder
 }
 
 // New creates a Discovery. Caller passes one or more UDP conns (all bound to discovery port, SO_REUSEPORT). conns[0] is the main listener; additional conns allow sending broadcast from each local IP so responses come back to :9999.
 func New(cfg Config, conns []*net.UDPConn, getter HostInfoGetter) *Discovery {
+	return NewWithConns(cfg, UDPConns(conns), getter)
+}
+
+// NewWithConns is New over any PacketConn (e.g. simnet sockets together with Config.Network set to the simulated host).
+func NewWithConns(cfg Config, conns []PacketConn, getter HostInfoGetter) *Discovery {
 	if len(conns) == 0 {
 		panic("discovery: at least one conn required")
 	}
+	if cfg.Network == nil {
+		cfg.Network = SystemNetwork{}
+	}
 	return &Discovery{
 		cfg:     cfg,
 		conns:   conns,
 		getter:  getter,
 		pending: make(map[string]chan *DiscoveryResponse),
+		peers:   make(map[string]*Peer),
+
+		relaySeen: make(map[string]time.Time),
+		buckets:   make(map[string]*list.Element),
+		bucketLRU: list.New(),
+		stats:     newStatsRecorder(cfg.Network),
+		recv:      make(chan received, 32),
 	}
 }
 
-// Run starts the read loop: read from all conns, handle DISCOVERY_REQUEST (respond) and DISCOVERY_RESPONSE (forward to pending).
+// Run starts the read loop: read from all conns, handle DISCOVERY_REQUEST (respond), DISCOVERY_RESPONSE (forward to pending) and presence messages (peer table).
+// It returns when a conn fails or is closed, except conns taken out with RemoveConn.
 func (d *Discovery) Run() {
-	type recv struct {
-		data   []byte
-		from   *net.UDPAddr
-		recvOn string // which conn received (LocalAddr), for debugging SO_REUSEPORT delivery
-		err    error
-	}
-	ch := make(chan recv, 32)
+	d.connMu.Lock()
+	d.running = true
 	for _, c := range d.conns {
-		conn := c
-		localAddr := conn.LocalAddr().String()
-		go func() {
-			buf := make([]byte, 4096)
-			for {
-				n, from, err := conn.ReadFromUDP(buf)
-				if err != nil {
-					ch <- recv{err: err}
-					return
-				}
-				ch <- recv{data: append([]byte(nil), buf[:n]...), from: from, recvOn: localAddr}
-			}
-		}()
+		d.startReader(c)
 	}
-	for r := range ch {
+	d.connMu.Unlock()
+	for r := range d.recv {
 		if r.err != nil {
 			return
 		}
@@ -81,27 +147,47 @@ func (d *Discovery) Run() {
 			Type string `json:"type"`
 		}
 		if err := json.Unmarshal(r.data, &msg); err != nil {
+			d.stats.count(r.from, r.recvOn, func(c *DiscoveryCounters) { c.ParseFailures++ })
 			continue
 		}
 		switch msg.Type {
+		case "DISCOVERY_REQUEST", RelayRequestType:
+			d.stats.count(r.from, r.recvOn, func(c *DiscoveryCounters) { c.RequestsReceived++ })
+		case "DISCOVERY_RESPONSE":
+			d.stats.count(r.from, r.recvOn, func(c *DiscoveryCounters) { c.ResponsesReceived++ })
+		}
+		switch msg.Type {
+		case "DISCOVERY_REQUEST", "DISCOVERY_RESPONSE", RelayRequestType, PresenceHello, PresenceHeartbeat, PresenceBye:
+			if err := d.cfg.Auth.Verify(r.data); err != nil {
+				d.stats.count(r.from, r.recvOn, func(c *DiscoveryCounters) { c.AuthDropped++ })
+				log.Printf("discovery: dropped %s from %s recv_on=%s: %v", msg.Type, r.from, r.recvOn, err)
+				continue
+			}
+		}
+		switch msg.Type {
 		case "DISCOVERY_REQUEST":
-			d.handleRequest(r.data, r.from)
+			d.handleRequest(r.data, r.from, r.recvOn)
 		case "DISCOVERY_RESPONSE":
 			d.handleResponse(r.data, r.from, r.recvOn)
+		case RelayRequestType:
+			d.handleRelayRequest(r.data, r.from, r.recvOn)
+		case PresenceHello, PresenceHeartbeat, PresenceBye:
+			d.handlePresence(r.data, r.from)
 		}
 	}
 }
 
-func (d *Discovery) handleRequest(raw []byte, from *net.UDPAddr) {
+func (d *Discovery) handleRequest(raw []byte, from *net.UDPAddr, recvOn string) {
+	count := func(f func(*DiscoveryCounters)) { d.stats.count(from, recvOn, f) }
 	var req DiscoveryRequest
 	if err := json.Unmarshal(raw, &req); err != nil {
+		count(func(c *DiscoveryCounters) { c.ParseFailures++ })
 		return
 	}
 	if req.Service != d.cfg.DiscoveryServiceName {
+		count(func(c *DiscoveryCounters) { c.ServiceMismatch++ })
 		return
 	}
-	log.Printf("discovery: received DISCOVERY_REQUEST from %s (reply_udp_port=%d)", from, req.ReplyUDPPort)
-	hostname, hostIP, cpuInfo, cpuUsage, memTotalMB, memUsedMB, memUsagePct, cpuUUID := d.getter()
 	// Prefer explicit reply_udp_port from JSON (CLI and fixed-port clients); else UDP source port; else discovery port.
 	replyPort := from.Port
 	if req.ReplyUDPPort > 0 {
@@ -110,23 +196,44 @@ func (d *Discovery) handleRequest(raw []byte, from *net.UDPAddr) {
 	if replyPort == 0 {
 		replyPort = d.cfg.DiscoveryUDPPort
 	}
+	replyPort, ok := d.screenRequest(from, replyPort)
+	if !ok {
+		return
+	}
+	log.Printf("discovery: received DISCOVERY_REQUEST from %s (reply_udp_port=%d)", from, req.ReplyUDPPort)
+	hostname, hostIP, cpuInfo, cpuUsage, memTotalMB, memUsedMB, memUsagePct, cpuUUID := d.getter()
+	primaryIP := hostIP
+	// Filtered requests are answered only by matching agents; the rest stay silent (no negative reply) and cost no tokens.
+	if !req.Filter.Match(hostname, d.cfg.Version, cpuUUID, d.cfg.Labels) {
+		count(func(c *DiscoveryCounters) { c.RequestsFiltered++ })
+		log.Printf("discovery: DISCOVERY_REQUEST from %s does not match filter, not answering", from)
+		return
+	}
+	if !d.takeToken(from) {
+		return
+	}
 	replyIP := from.IP
 	if v4 := replyIP.To4(); v4 != nil {
 		replyIP = v4
 	}
-	to := &net.UDPAddr{IP: replyIP, Port: replyPort}
+	// Zone is kept so replies to link-local IPv6 requesters leave on the interface the request arrived on.
+	to := &net.UDPAddr{IP: replyIP, Port: replyPort, Zone: from.Zone}
 	// Prefer sending from the local IP that is in the same subnet as the requester, so the response has the expected source IP (e.g. .236 when replying to .236, .237 when replying to .237).
-	sendFrom := localIPInSameSubnetAs(from.IP)
+	sendFrom := d.localIPInSameSubnetAs(from.IP)
 	if sendFrom != nil {
 		hostIP = sendFrom.String()
+	} else if replyIP.To4() == nil {
+		if ll := d.localIPv6OnZone(from.Zone); ll != nil {
+			hostIP = ll.String()
+		}
 	}
 	if hostIP == "" {
 		if out := d.outboundIP(from.IP); out != "" {
 			hostIP = out
 		}
 	}
-	// HostIP is this reply path only. Do not send HostIPs over UDP — clients use UDP source
-	// (responded_from_ip) as the reachable addresses; full NIC lists belong in HTTP /self only.
+	// HostIP is this reply path only. Clients use the UDP source (responded_from_ip) as the reachable addresses;
+	// full address and NIC lists belong in HTTP /self only (hostinfoapi.SelfResponse).
 	resp := DiscoveryResponse{
 		Type:               "DISCOVERY_RESPONSE",
 		Service:            d.cfg.DiscoveryServiceName,
@@ -141,41 +248,63 @@ func (d *Discovery) handleRequest(raw []byte, from *net.UDPAddr) {
 		MemoryTotalMB:      memTotalMB,
 		MemoryUsedMB:       memUsedMB,
 		MemoryUsagePercent: memUsagePct,
+		MAC:                d.primaryMAC(primaryIP),
+		Labels:             d.cfg.Labels,
+		ProtoVersion:       ProtoVersion,
+		Capabilities:       d.Capabilities(),
+	}
+	if d.cfg.Summary != nil {
+		resp.Summary = d.cfg.Summary()
+	}
+	data, err := d.cfg.Auth.Marshal(&resp)
+	if err == nil && resp.Summary != nil && len(data) >= MaxDiscoveryResponsePayloadBytes {
+		// Many labels: the summary is the part that can go.
+		resp.Summary = nil
+		data, err = d.cfg.Auth.Marshal(&resp)
 	}
-	data, err := json.Marshal(resp)
 	if err != nil {
 		log.Printf("discovery: failed to marshal DISCOVERY_RESPONSE: %v", err)
 		return
 	}
+	if len(data) > MaxDiscoveryResponsePayloadBytes {
+		log.Printf("discovery: DISCOVERY_RESPONSE is %d bytes (budget %d), it may be fragmented", len(data), MaxDiscoveryResponsePayloadBytes)
+	}
+	sendFailed := func() { count(func(c *DiscoveryCounters) { c.SendErrors++ }) }
 	if sendFrom != nil {
-		for _, conn := range d.conns {
+		for _, conn := range d.connList() {
 			la, ok := conn.LocalAddr().(*net.UDPAddr)
 			if !ok || la == nil || !la.IP.Equal(sendFrom) {
 				continue
 			}
 			if _, err := conn.WriteToUDP(data, to); err != nil {
 				log.Printf("discovery: failed to write DISCOVERY_RESPONSE from %s to %s: %v", sendFrom, to, err)
+				sendFailed()
 				return
 			}
 			log.Printf("discovery: sending DISCOVERY_RESPONSE from %s to %s (hostname=%s)", sendFrom, to, hostname)
+			d.answered(count)
 			return
 		}
 	}
-	log.Printf("discovery: sending DISCOVERY_RESPONSE to %s (hostname=%s)", to, hostname)
-	network := "udp"
-	if to.IP.To4() != nil {
-		network = "udp4"
-	}
-	connOut, err := net.DialUDP(network, nil, to)
-	if err != nil {
-		log.Printf("discovery: failed to DialUDP to %s: %v", to, err)
-		return
+	if to.IP.To4() == nil {
+		if conn := connForFamily(d.connList(), to.IP); conn != nil {
+			if _, err := conn.WriteToUDP(data, to); err != nil {
+				log.Printf("discovery: failed to write DISCOVERY_RESPONSE to %s: %v", to, err)
+				sendFailed()
+				return
+			}
+			log.Printf("discovery: sending DISCOVERY_RESPONSE to %s (hostname=%s, ipv6)", to, hostname)
+			d.answered(count)
+			return
+		}
 	}
-	defer connOut.Close()
-	if _, err := connOut.Write(data); err != nil {
+	log.Printf("discovery: sending DISCOVERY_RESPONSE to %s (hostname=%s)", to, hostname)
+	if err := d.network().SendUnbound(data, to); err != nil {
 		log.Printf("discovery: failed to write DISCOVERY_RESPONSE to %s: %v", to, err)
+		sendFailed()
 		return
 	}
+	d.answered(count)
 }
 
 // OutboundIP returns the local IP used when sending to remote:port (e.g. broadcast address). Use for "my IP on this network".
@@ -194,102 +323,63 @@ func OutboundIP(remote net.IP, port int) string {
 }
 
 func (d *Discovery) outboundIP(remote net.IP) string {
-	return OutboundIP(remote, d.cfg.DiscoveryUDPPort)
+	return d.network().OutboundIP(remote, d.cfg.DiscoveryUDPPort)
 }
 
-// localIPInSameSubnetAs returns a local IPv4 address that is in the same subnet as the given remote IP (requester), or nil. Prefers the same /24 as remote so that with /23 networks we reply from .236 when requester is .236 and from .237 when requester is .237.
-func localIPInSameSubnetAs(remote net.IP) net.IP {
-	remote = remote.To4()
-	if remote == nil {
-		return nil
-	}
-	mask24 := net.CIDRMask(24, 32)
-	remote24 := remote.Mask(mask24)
-	ifaces, err := net.Interfaces()
-	if err != nil {
-		return nil
-	}
-	var first, same24 net.IP
-	for _, iface := range ifaces {
-		if iface.Flags&net.FlagUp == 0 {
-			continue
+// requestTargets returns the IPv4 destinations for one run: each broadcast address and/or the multicast group (Config.Mode).
+// With no IPv4 destination it errors, unless the run has other ways out (static peers, relay peers, the IPv6 group):
+// then the list is empty and only those are used (e.g. a routed-only host with StaticPeers and no broadcast domain).
+func (d *Discovery) requestTargets() ([]*net.UDPAddr, error) {
+	var addrs []*net.UDPAddr
+	if d.sendsBroadcast() {
+		brds := d.BroadcastAddresses()
+		if len(brds) == 0 && !d.hasUnicastTargets() {
+			return nil, fmt.Errorf("discovery: no broadcast addresses configured")
 		}
-		addrs, _ := iface.Addrs()
-		for _, a := range addrs {
-			ipnet, ok := a.(*net.IPNet)
-			if !ok || ipnet.IP.To4() == nil || ipnet.IP.IsLoopback() {
-				continue
-			}
-			if !ipnet.Contains(remote) {
-				continue
+		for _, a := range brds {
+			addr, err := net.ResolveUDPAddr("udp", a+":"+strconv.Itoa(d.cfg.DiscoveryUDPPort))
+			if err != nil {
+				return nil, err
 			}
-			ip := ipnet.IP.To4()
-			if ip == nil {
-				continue
-			}
-			if first == nil {
-				first = append(net.IP(nil), ip...)
-			}
-			if ip.Mask(mask24).Equal(remote24) {
-				same24 = append(net.IP(nil), ip...)
-				break
-			}
-		}
-		if same24 != nil {
-			break
+			addrs = append(addrs, addr)
 		}
 	}
-	if same24 != nil {
-		return same24
+	if d.sendsMulticast() {
+		addrs = append(addrs, &net.UDPAddr{IP: d.cfg.MulticastGroup, Port: d.cfg.DiscoveryUDPPort})
 	}
-	return first
+	if len(addrs) == 0 && !d.hasUnicastTargets() {
+		return nil, fmt.Errorf("discovery: no broadcast addresses or multicast group configured (mode %q)", d.cfg.Mode)
+	}
+	return addrs, nil
 }
 
-// LocalIPsInSubnet returns local IPv4 addresses that belong to the same subnet as the given broadcast IP.
-// It checks both /24 and /23 so that:
-//   - /24: e.g. broadcast 172.29.236.255 → local 172.29.236.x (other system may be 172.29.236.0/24)
-//   - /23: e.g. broadcast 172.29.237.255 → local 172.29.236.x and 172.29.237.x (same /23)
-//
-// A local IP is included if it matches the broadcast in /24 or in /23, so both network sizes work.
-func LocalIPsInSubnet(broadcast net.IP) []net.IP {
-	broadcast = broadcast.To4()
-	if broadcast == nil {
-		return nil
-	}
-	mask24 := net.CIDRMask(24, 32)
-	mask23 := net.CIDRMask(23, 32)
-	var out []net.IP
-	ifaces, err := net.Interfaces()
-	if err != nil {
-		return nil
+// hasUnicastTargets reports whether runs reach hosts other than through IPv4 broadcast / multicast.
+func (d *Discovery) hasUnicastTargets() bool {
+	return len(d.cfg.StaticPeers) > 0 || len(d.cfg.RelayPeers) > 0 || (d.cfg.IPv6Group != nil && len(d.cfg.IPv6Interfaces) > 0)
+}
+
+// selfOutboundIP returns the local IP toward the run's first IPv4 destination ("" when the run has none).
+func (d *Discovery) selfOutboundIP(addrs []*net.UDPAddr) string {
+	if len(addrs) == 0 {
+		return ""
 	}
-	for _, iface := range ifaces {
-		if iface.Flags&net.FlagUp == 0 {
-			continue
-		}
-		addrs, _ := iface.Addrs()
-		for _, a := range addrs {
-			ipnet, ok := a.(*net.IPNet)
-			if !ok || ipnet.IP.To4() == nil || ipnet.IP.IsLoopback() {
-				continue
-			}
-			ip := ipnet.IP.To4()
-			if ip == nil {
-				continue
-			}
-			// Same /24 or same /23 as broadcast → works for both /24 and /23 networks
-			if ip.Mask(mask24).Equal(broadcast.Mask(mask24)) || ip.Mask(mask23).Equal(broadcast.Mask(mask23)) {
-				out = append(out, append(net.IP(nil), ip...))
-			}
-		}
+	return d.outboundIP(addrs[0].IP)
+}
+
+// sourceIPsFor returns the local IPs to send to dest from: subnet matches for a broadcast address,
+// every bound interface address for a multicast group (one copy per interface).
+func (d *Discovery) sourceIPsFor(dest net.IP) []net.IP {
+	if dest.IsMulticast() {
+		return d.boundUnicastIPs()
 	}
-	return out
+	return matchLocalIPs(d.network(), dest)
 }
 
-// sendDiscoveryRequest sends data to addr. If localIPs is non-empty, sends from each conn that is bound to one of those IPs (source port stays 9999 so responses are received). Otherwise sends once from d.conns[0].
+// sendDiscoveryRequest sends data to addr (broadcast address or multicast group). If localIPs is non-empty, sends from each conn that is bound to one of those IPs (source port stays 9999 so responses are received). Otherwise sends once from d.conns[0].
 func (d *Discovery) sendDiscoveryRequest(data []byte, addr *net.UDPAddr, localIPs []net.IP) error {
+	conns := d.connList()
 	if len(localIPs) == 0 {
-		_, err := d.conns[0].WriteToUDP(data, addr)
+		_, err := conns[0].WriteToUDP(d.perSend(data), addr)
 		return err
 	}
 	seen := make(map[string]bool)
@@ -300,19 +390,19 @@ func (d *Discovery) sendDiscoveryRequest(data []byte, addr *net.UDPAddr, localIP
 		}
 		seen[key] = true
 		sent := false
-		for _, conn := range d.conns {
+		for _, conn := range conns {
 			la, ok := conn.LocalAddr().(*net.UDPAddr)
 			if !ok || la == nil || !la.IP.Equal(lip) {
 				continue
 			}
-			if _, err := conn.WriteToUDP(data, addr); err != nil {
+			if _, err := conn.WriteToUDP(d.perSend(data), addr); err != nil {
 				log.Printf("discovery: send from %s to %s: %v", lip, addr, err)
 			}
 			sent = true
 			break
 		}
 		if !sent {
-			if _, err := d.conns[0].WriteToUDP(data, addr); err != nil {
+			if _, err := conns[0].WriteToUDP(d.perSend(data), addr); err != nil {
 				log.Printf("discovery: fallback send to %s: %v", addr, err)
 			}
 		}
@@ -321,20 +411,55 @@ func (d *Discovery) sendDiscoveryRequest(data []byte, addr *net.UDPAddr, localIP
 }
 
 func (d *Discovery) handleResponse(raw []byte, from *net.UDPAddr, recvOn string) {
+	count := func(f func(*DiscoveryCounters)) { d.stats.count(from, recvOn, f) }
 	var resp DiscoveryResponse
 	if err := json.Unmarshal(raw, &resp); err != nil {
+		count(func(c *DiscoveryCounters) { c.ParseFailures++ })
 		log.Printf("discovery: failed to parse DISCOVERY_RESPONSE from %s: %v", from, err)
 		return
 	}
-	resp.RespondedFromIP = from.IP.String()
+	if resp.Service != "" && resp.Service != d.cfg.DiscoveryServiceName {
+		count(func(c *DiscoveryCounters) { c.ServiceMismatch++ })
+		log.Printf("discovery: dropped DISCOVERY_RESPONSE from %s (service %q)", from, resp.Service)
+		return
+	}
+	resp.setAuth(0, "", "") // verified in Run; not part of API output
+	// Receiver-side fields are never taken from the wire: a forged "source":"mdns" would skip conflict detection and dedup.
+	resp.Source, resp.IsSelf = "", false
+	// Relayed responses keep the address the relay heard the host on; the packet source is the relay itself. Only a
+	// configured relay peer, or any sender in signed mode (verified in Run), is trusted with it.
+	trusted := d.cfg.Auth != nil || d.isRelayPeer(from.IP)
+	if len(resp.RelayPath) == 0 || resp.RespondedFromIP == "" || !trusted {
+		resp.RespondedFromIP = respondedFrom(from)
+	}
+	// The send stays under mu: a run that ends early (limit, quiet period, cancel) removes and closes its channel under mu.
 	d.mu.Lock()
 	ch := d.pending[resp.RequestID]
-	d.mu.Unlock()
+	delivered := false
 	if ch != nil {
 		select {
 		case ch <- &resp:
-			log.Printf("discovery: received DISCOVERY_RESPONSE from %s host_ip=%s recv_on=%s (delivered)", from, resp.HostIP, recvOn)
+			delivered = true
 		default:
+		}
+	}
+	d.mu.Unlock()
+	// Only answers to a run of this agent reach the observer: unsolicited responses are not evidence of a host.
+	if ch != nil && d.cfg.Observer != nil {
+		d.cfg.Observer.ObserveResponse(resp)
+	}
+	switch {
+	case ch == nil:
+		count(func(c *DiscoveryCounters) { c.DroppedStale++ })
+	case delivered:
+		count(func(c *DiscoveryCounters) { c.ResponsesDelivered++ })
+	default:
+		count(func(c *DiscoveryCounters) { c.DroppedChannelFull++ })
+	}
+	if ch != nil {
+		if delivered {
+			log.Printf("discovery: received DISCOVERY_RESPONSE from %s host_ip=%s recv_on=%s (delivered)", from, resp.HostIP, recvOn)
+		} else {
 			log.Printf("discovery: received DISCOVERY_RESPONSE from %s requestID=%s recv_on=%s (pending channel full, dropped)", from, resp.RequestID, recvOn)
 		}
 	} else {
@@ -342,6 +467,36 @@ func (d *Discovery) handleResponse(raw []byte, from *net.UDPAddr, recvOn string)
 	}
 }
 
+// perSend returns data re-signed with a fresh nonce (Authenticator.Resign) for one write of a multi-destination send;
+// unsigned data is returned as is.
+func (d *Discovery) perSend(data []byte) []byte {
+	out, err := d.cfg.Auth.Resign(data)
+	if err != nil {
+		log.Printf("discovery: re-sign: %v", err)
+		return data
+	}
+	return out
+}
+
+// marshalRequest signs req when Auth is configured and checks the UDP size budget.
+func (d *Discovery) marshalRequest(req *DiscoveryRequest) ([]byte, error) {
+	req.ProtoVersion = ProtoVersion
+	req.Capabilities = d.Capabilities()
+	data, err := d.cfg.Auth.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateDiscoveryRequestPayload(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// AuthStats returns counters of packets dropped by signature/skew/replay checks (all zero in unsigned mode).
+func (d *Discovery) AuthStats() AuthStats {
+	return d.cfg.Auth.Stats()
+}
+
 func (d *Discovery) discoveryTimeout() time.Duration {
 	sec := d.cfg.DiscoveryTimeoutSeconds
 	if sec <= 0 {
@@ -362,16 +517,102 @@ func (d *Discovery) effectiveTimeout(opts DiscoveryRunOptions) time.Duration {
 type DiscoveryRunOptions struct {
 	ExcludeSelf bool
 	Timeout     time.Duration
+	// Filter is sent in the request so only matching agents answer; responses are checked again here for agents that ignore it.
+	Filter *DiscoveryFilter
+	// Limit ends the run once this many distinct hosts (HostKey) were included; 0 = no limit. Responses of those hosts already received are kept.
+	Limit int
+	// QuietPeriod ends the run when no new result arrived for this long (counted from the request being sent); 0 = wait for Timeout.
+	QuietPeriod time.Duration
+}
+
+// HostKey identifies a host across its responses: the CPU UUID (lowercase), or "noid:hostname@host_ip" when it has none.
+func HostKey(r *DiscoveryResponse) string {
+	if u := strings.ToLower(strings.TrimSpace(r.CPUUUID)); u != "" {
+		return u
+	}
+	return "noid:" + r.Hostname + "@" + r.HostIP
+}
+
+// collectResponses reads ch until the timeout, ctx is done, opts.Limit hosts were seen or opts.QuietPeriod passed without a new result.
+// include applies the run's inclusion rules; emit receives each included response and returns false to stop. Returns why the run ended.
+func collectResponses(ctx context.Context, ch <-chan *DiscoveryResponse, timeout time.Duration, opts DiscoveryRunOptions,
+	include func(*DiscoveryResponse) bool, emit func(*DiscoveryResponse) bool) string {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var quietC <-chan time.Time
+	var quiet *time.Timer
+	if opts.QuietPeriod > 0 {
+		quiet = time.NewTimer(opts.QuietPeriod)
+		defer quiet.Stop()
+		quietC = quiet.C
+	}
+	hosts := make(map[string]struct{})
+	// handle returns false when the run must end (limit reached or emit refused).
+	handle := func(r *DiscoveryResponse) bool {
+		if !include(r) {
+			return true
+		}
+		if !emit(r) {
+			return false
+		}
+		hosts[HostKey(r)] = struct{}{}
+		if quiet != nil {
+			quiet.Reset(opts.QuietPeriod)
+		}
+		return opts.Limit <= 0 || len(hosts) < opts.Limit
+	}
+	stopped := func() string {
+		if ctx.Err() != nil {
+			return "cancelled"
+		}
+		return "limit"
+	}
+	// drain: select may choose a timer when both are ready, so responses already in the channel would be missed.
+	drain := func(reason string) string {
+		for {
+			select {
+			case r, ok := <-ch:
+				if !ok {
+					return reason
+				}
+				if !handle(r) {
+					return stopped()
+				}
+			default:
+				return reason
+			}
+		}
+	}
+	for {
+		select {
+		case r, ok := <-ch:
+			if !ok {
+				return "closed"
+			}
+			if !handle(r) {
+				return stopped()
+			}
+		case <-timer.C:
+			return drain("timeout")
+		case <-quietC:
+			return drain("quiet period")
+		case <-ctx.Done():
+			return "cancelled"
+		}
+	}
 }
 
-// includeInDiscoveryResults applies self handling and dedup. If excludeSelf, drops this host's responses; else includes self with IsSelf=true (same rules as stream when excludeSelf is false).
-func (d *Discovery) includeInDiscoveryResults(r *DiscoveryResponse, addrs []*net.UDPAddr, selfCPUUUID string, seen map[string]struct{}, excludeSelf bool) bool {
-	if excludeSelf {
+// includeInDiscoveryResults applies the filter, self handling and dedup. If opts.ExcludeSelf, drops this host's responses; else includes self with IsSelf=true (same rules as stream when excludeSelf is false).
+func (d *Discovery) includeInDiscoveryResults(r *DiscoveryResponse, addrs []*net.UDPAddr, selfCPUUUID string, seen map[string]struct{}, opts DiscoveryRunOptions) bool {
+	if !opts.Filter.MatchResponse(r) {
+		return false
+	}
+	if opts.ExcludeSelf {
 		if selfCPUUUID != "" && r.CPUUUID != "" && r.CPUUUID == selfCPUUUID {
 			return false
 		}
 		if selfCPUUUID == "" {
-			selfHostIP := d.outboundIP(addrs[0].IP)
+			selfHostIP := d.selfOutboundIP(addrs)
 			if selfHostIP == "" {
 				_, selfHostIP, _, _, _, _, _, _ = d.getter()
 			}
@@ -381,11 +622,10 @@ func (d *Discovery) includeInDiscoveryResults(r *DiscoveryResponse, addrs []*net
 		}
 	} else {
 		if selfCPUUUID != "" && r.CPUUUID != "" && r.CPUUUID == selfCPUUUID {
+			// Self goes through dedup too: a static peer list that includes this host's own address answers twice on the same path.
 			r.IsSelf = true
-			return true
-		}
-		if selfCPUUUID == "" {
-			selfHostIP := d.outboundIP(addrs[0].IP)
+		} else if selfCPUUUID == "" {
+			selfHostIP := d.selfOutboundIP(addrs)
 			if selfHostIP == "" {
 				_, selfHostIP, _, _, _, _, _, _ = d.getter()
 			}
@@ -394,6 +634,11 @@ func (d *Discovery) includeInDiscoveryResults(r *DiscoveryResponse, addrs []*net
 			}
 		}
 	}
+	// Browsed hosts only fill in for hosts that did not answer over UDP, whatever DiscoveryDeduplicate says.
+	hostKey := "host:" + HostKey(r)
+	if _, ok := seen[hostKey]; ok && r.Source == SourceMDNS {
+		return false
+	}
 	key := r.HostIP + ":" + fmt.Sprint(r.ServicePort)
 	if r.RespondedFromIP != "" {
 		key = key + "@" + r.RespondedFromIP
@@ -404,38 +649,33 @@ func (d *Discovery) includeInDiscoveryResults(r *DiscoveryResponse, addrs []*net
 		}
 		seen[key] = struct{}{}
 	}
+	seen[hostKey] = struct{}{}
 	return true
 }
 
-// DoDiscovery sends a DISCOVERY_REQUEST to each configured broadcast address and collects responses until timeout. Same inclusion rules as DoDiscoveryStream for the same opts. Deduplicates by host_ip:service_port if configured.
-func (d *Discovery) DoDiscovery(opts DiscoveryRunOptions) ([]DiscoveryResponse, error) {
+// DoDiscovery sends a DISCOVERY_REQUEST to each configured broadcast address (and the IPv6 group when configured) and collects responses until timeout,
+// ctx cancellation, opts.Limit or opts.QuietPeriod. Same inclusion rules as DoDiscoveryStream for the same opts. Deduplicates by host_ip:service_port if configured.
+// Also returns the duplicate identities seen in the run (this host included), over all responses before self exclusion and dedup.
+func (d *Discovery) DoDiscovery(ctx context.Context, opts DiscoveryRunOptions) ([]DiscoveryResponse, []Conflict, error) {
 	requestID := NewRequestID()
 	req := DiscoveryRequest{
 		Type:         "DISCOVERY_REQUEST",
 		Service:      d.cfg.DiscoveryServiceName,
 		RequestID:    requestID,
 		ReplyUDPPort: d.cfg.DiscoveryUDPPort,
+		Filter:       opts.Filter,
 	}
-	data, err := json.Marshal(req)
+	data, err := d.marshalRequest(&req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	if err := ValidateDiscoveryRequestPayload(data); err != nil {
-		return nil, err
-	}
-	if len(d.cfg.DiscoveryBroadcastAddresses) == 0 {
-		return nil, fmt.Errorf("discovery: no broadcast addresses configured")
-	}
-	addrs := make([]*net.UDPAddr, 0, len(d.cfg.DiscoveryBroadcastAddresses))
-	for _, a := range d.cfg.DiscoveryBroadcastAddresses {
-		addr, err := net.ResolveUDPAddr("udp", a+":"+strconv.Itoa(d.cfg.DiscoveryUDPPort))
-		if err != nil {
-			return nil, err
-		}
-		addrs = append(addrs, addr)
+	addrs, err := d.requestTargets()
+	if err != nil {
+		return nil, nil, err
 	}
 	// Register pending before sending so we don't miss fast responses (e.g. self-response or same-LAN reply).
-	ch := make(chan *DiscoveryResponse, 32)
+	// Buffered for bursts: static peers and relays can answer many hosts at once.
+	ch := make(chan *DiscoveryResponse, 256)
 	d.mu.Lock()
 	d.pending[requestID] = ch
 	d.mu.Unlock()
@@ -446,9 +686,9 @@ func (d *Discovery) DoDiscovery(opts DiscoveryRunOptions) ([]DiscoveryResponse,
 		close(ch)
 	}()
 	for _, addr := range addrs {
-		localIPs := LocalIPsInSubnet(addr.IP)
+		localIPs := d.sourceIPsFor(addr.IP)
 		if err = d.sendDiscoveryRequest(data, addr, localIPs); err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if len(localIPs) > 0 {
 			log.Printf("discovery: sent DISCOVERY_REQUEST requestID=%s to %s (from %d local IPs)", requestID, addr, len(localIPs))
@@ -456,71 +696,57 @@ func (d *Discovery) DoDiscovery(opts DiscoveryRunOptions) ([]DiscoveryResponse,
 			log.Printf("discovery: sent DISCOVERY_REQUEST requestID=%s to %s", requestID, addr)
 		}
 	}
+	if n := d.sendIPv6(data, "DISCOVERY_REQUEST"); n > 0 {
+		log.Printf("discovery: sent DISCOVERY_REQUEST requestID=%s to %s on %d interface(s) (ipv6)", requestID, d.cfg.IPv6Group, n)
+	}
+	if n := d.probeStaticPeers(data); n > 0 {
+		log.Printf("discovery: sent DISCOVERY_REQUEST requestID=%s to %d static peer(s)", requestID, n)
+	}
 	timeout := d.effectiveTimeout(opts)
-	timer := time.NewTimer(timeout)
-	defer timer.Stop()
-	_, _, _, _, _, _, _, selfCPUUUID := d.getter()
+	if n := d.relayOut(req, timeout); n > 0 {
+		log.Printf("discovery: sent %s requestID=%s to %d relay peer(s)", RelayRequestType, requestID, n)
+	}
+	d.startBrowse(ctx, requestID, timeout)
+	selfName, selfIP, _, _, _, _, _, selfCPUUUID := d.getter()
+	// This host takes part even when its own answer is excluded or lost: a clone of it is what hides.
+	conflicts := NewConflictDetector()
+	conflicts.Add(&DiscoveryResponse{Hostname: selfName, HostIP: selfIP, CPUUUID: selfCPUUUID, MAC: d.primaryMAC(selfIP)}, true)
 	seen := make(map[string]struct{})
 	var list []DiscoveryResponse
-	processResponse := func(r *DiscoveryResponse) {
-		if !d.includeInDiscoveryResults(r, addrs, selfCPUUUID, seen, opts.ExcludeSelf) {
-			return
-		}
-		list = append(list, *r)
-	}
-
-	for {
-		select {
-		case r, ok := <-ch:
-			if !ok {
-				return list, nil
-			}
-			processResponse(r)
-		case <-timer.C:
-			// Drain channel before returning: select may choose timer when both are ready, so we'd miss responses already in the channel.
-			for {
-				select {
-				case r, ok := <-ch:
-					if !ok {
-						return list, nil
-					}
-					processResponse(r)
-				default:
-					return list, nil
-				}
-			}
-		}
-	}
+	reason := collectResponses(ctx, ch, timeout, opts,
+		func(r *DiscoveryResponse) bool {
+			conflicts.Add(r, false)
+			return d.includeInDiscoveryResults(r, addrs, selfCPUUUID, seen, opts)
+		},
+		func(r *DiscoveryResponse) bool { list = append(list, *r); return true })
+	found := conflicts.Conflicts()
+	log.Printf("discovery: requestID=%s ended (%s), %d result(s)", requestID, reason, len(list))
+	for _, c := range found {
+		log.Printf("discovery: WARNING: requestID=%s: %s %s is claimed by %d hosts", requestID, c.Kind, c.Value, len(c.Hosts))
+	}
+	return list, found, nil
 }
 
-// DoDiscoveryStream sends a DISCOVERY_REQUEST to each configured broadcast address and yields each response on the returned channel as it arrives (same inclusion/dedup rules as DoDiscovery for the same opts). The channel is closed when the timeout expires. Caller must consume the channel until closed.
-func (d *Discovery) DoDiscoveryStream(opts DiscoveryRunOptions) (<-chan DiscoveryResponse, error) {
+// DoDiscoveryStream sends a DISCOVERY_REQUEST to each configured broadcast address and yields each response on the returned channel as it arrives (same inclusion/dedup rules as DoDiscovery for the same opts).
+// The channel is closed when the timeout expires, opts.Limit or opts.QuietPeriod ends the run, or ctx is done. Caller must consume the channel until closed or cancel ctx.
+func (d *Discovery) DoDiscoveryStream(ctx context.Context, opts DiscoveryRunOptions) (<-chan DiscoveryResponse, error) {
 	requestID := NewRequestID()
 	req := DiscoveryRequest{
 		Type:         "DISCOVERY_REQUEST",
 		Service:      d.cfg.DiscoveryServiceName,
 		RequestID:    requestID,
 		ReplyUDPPort: d.cfg.DiscoveryUDPPort,
+		Filter:       opts.Filter,
 	}
-	data, err := json.Marshal(req)
+	data, err := d.marshalRequest(&req)
 	if err != nil {
 		return nil, err
 	}
-	if err := ValidateDiscoveryRequestPayload(data); err != nil {
+	addrs, err := d.requestTargets()
+	if err != nil {
 		return nil, err
 	}
-	if len(d.cfg.DiscoveryBroadcastAddresses) == 0 {
-		return nil, fmt.Errorf("discovery: no broadcast addresses configured")
-	}
-	addrs := make([]*net.UDPAddr, 0, len(d.cfg.DiscoveryBroadcastAddresses))
-	for _, a := range d.cfg.DiscoveryBroadcastAddresses {
-		addr, err := net.ResolveUDPAddr("udp", a+":"+strconv.Itoa(d.cfg.DiscoveryUDPPort))
-		if err != nil {
-			return nil, err
-		}
-		addrs = append(addrs, addr)
-	}
-	ch := make(chan *DiscoveryResponse, 32)
+	ch := make(chan *DiscoveryResponse, 256)
 	d.mu.Lock()
 	d.pending[requestID] = ch
 	d.mu.Unlock()
@@ -535,7 +761,7 @@ func (d *Discovery) DoDiscoveryStream(opts DiscoveryRunOptions) (<-chan Discover
 		}()
 
 		for _, addr := range addrs {
-			localIPs := LocalIPsInSubnet(addr.IP)
+			localIPs := d.sourceIPsFor(addr.IP)
 			if err = d.sendDiscoveryRequest(data, addr, localIPs); err != nil {
 				return
 			}
@@ -545,40 +771,35 @@ func (d *Discovery) DoDiscoveryStream(opts DiscoveryRunOptions) (<-chan Discover
 				log.Printf("discovery: sent DISCOVERY_REQUEST requestID=%s to %s (stream)", requestID, addr)
 			}
 		}
+		if n := d.sendIPv6(data, "DISCOVERY_REQUEST"); n > 0 {
+			log.Printf("discovery: sent DISCOVERY_REQUEST requestID=%s to %s on %d interface(s) (stream, ipv6)", requestID, d.cfg.IPv6Group, n)
+		}
+		if n := d.probeStaticPeers(data); n > 0 {
+			log.Printf("discovery: sent DISCOVERY_REQUEST requestID=%s to %d static peer(s) (stream)", requestID, n)
+		}
 
 		timeout := d.effectiveTimeout(opts)
-		timer := time.NewTimer(timeout)
-		defer timer.Stop()
-
+		if n := d.relayOut(req, timeout); n > 0 {
+			log.Printf("discovery: sent %s requestID=%s to %d relay peer(s) (stream)", RelayRequestType, requestID, n)
+		}
+		d.startBrowse(ctx, requestID, timeout)
 		_, _, _, _, _, _, _, selfCPUUUID := d.getter()
 		seen := make(map[string]struct{})
-
-		for {
-			select {
-			case r, ok := <-ch:
-				if !ok {
-					return
-				}
-				if d.includeInDiscoveryResults(r, addrs, selfCPUUUID, seen, opts.ExcludeSelf) {
-					log.Printf("discovery: stream forwarding host %s (hostname=%s) responded_from=%s", r.HostIP, r.Hostname, r.RespondedFromIP)
-					out <- *r
-				}
-			case <-timer.C:
-				for {
-					select {
-					case r, ok := <-ch:
-						if !ok {
-							return
-						}
-						if d.includeInDiscoveryResults(r, addrs, selfCPUUUID, seen, opts.ExcludeSelf) {
-							out <- *r
-						}
-					default:
-						return
-					}
+		sent := 0
+		reason := collectResponses(ctx, ch, timeout, opts,
+			func(r *DiscoveryResponse) bool { return d.includeInDiscoveryResults(r, addrs, selfCPUUUID, seen, opts) },
+			func(r *DiscoveryResponse) bool {
+				log.Printf("discovery: stream forwarding host %s (hostname=%s) responded_from=%s", r.HostIP, r.Hostname, r.RespondedFromIP)
+				// A consumer that went away cancels ctx; never block on out after that.
+				select {
+				case out <- *r:
+					sent++
+					return true
+				case <-ctx.Done():
+					return false
 				}
-			}
-		}
+			})
+		log.Printf("discovery: requestID=%s stream ended (%s), %d result(s)", requestID, reason, sent)
 	}(opts)
 
 	return out, nil
@@ -586,7 +807,7 @@ func (d *Discovery) DoDiscoveryStream(opts DiscoveryRunOptions) (<-chan Discover
 
 // DoDiscoveryUnicast sends a DISCOVERY_REQUEST to the given IP (unicast) and returns that host's DiscoveryResponse, or error on timeout/no response.
 // The IP should be the host's address; the request is sent to ip:DiscoveryUDPPort.
-// ReplyUDPPort in the JSON is the local bound port of conns[0] when available, so clients can bind a different src port (e.g. 9998) while sending to dest DiscoveryUDPPort (e.g. 9999).
+// ReplyUDPPort in the JSON is the local bound port of the sending conn (conns[0] for IPv4, the udp6 conn for IPv6) when available, so clients can bind a different src port (e.g. 9998) while sending to dest DiscoveryUDPPort (e.g. 9999).
 func (d *Discovery) DoDiscoveryUnicast(ip string) (*DiscoveryResponse, error) {
 	ip = strings.TrimSpace(ip)
 	if ip == "" {
@@ -596,9 +817,14 @@ func (d *Discovery) DoDiscoveryUnicast(ip string) (*DiscoveryResponse, error) {
 	if err != nil {
 		return nil, err
 	}
+	// Link-local IPv6 targets carry their zone ("fe80::1%eth0"); send from the conn of the matching family.
+	conn := connForFamily(d.connList(), addr.IP)
+	if conn == nil {
+		return nil, fmt.Errorf("no UDP socket for %s", addr.IP)
+	}
 	requestID := NewRequestID()
 	replyUDPPort := d.cfg.DiscoveryUDPPort
-	if la, ok := d.conns[0].LocalAddr().(*net.UDPAddr); ok && la != nil && la.Port > 0 {
+	if la, ok := conn.LocalAddr().(*net.UDPAddr); ok && la != nil && la.Port > 0 {
 		replyUDPPort = la.Port
 	}
 	req := DiscoveryRequest{
@@ -607,13 +833,10 @@ func (d *Discovery) DoDiscoveryUnicast(ip string) (*DiscoveryResponse, error) {
 		RequestID:    requestID,
 		ReplyUDPPort: replyUDPPort,
 	}
-	data, err := json.Marshal(req)
+	data, err := d.marshalRequest(&req)
 	if err != nil {
 		return nil, err
 	}
-	if err := ValidateDiscoveryRequestPayload(data); err != nil {
-		return nil, err
-	}
 	ch := make(chan *DiscoveryResponse, 1)
 	d.mu.Lock()
 	d.pending[requestID] = ch
@@ -624,7 +847,7 @@ func (d *Discovery) DoDiscoveryUnicast(ip string) (*DiscoveryResponse, error) {
 		d.mu.Unlock()
 		close(ch)
 	}()
-	if _, err = d.conns[0].WriteToUDP(data, addr); err != nil {
+	if _, err = conn.WriteToUDP(data, addr); err != nil {
 		return nil, err
 	}
 	log.Printf("discovery: sent DISCOVERY_REQUEST requestID=%s to %s (unicast)", requestID, addr)
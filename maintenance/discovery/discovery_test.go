@@ -0,0 +1,398 @@
+package discovery_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"testing"
+	"time"
+
+	"contrabass-agent/maintenance/discovery"
+	"contrabass-agent/maintenance/discovery/simnet"
+)
+
+const (
+	testPort    = 9999
+	testService = "contrabass-test"
+	testTimeout = 300 * time.Millisecond
+)
+
+// newLAN returns a LAN with segments a (10.0.1.0/24) and b (10.0.2.0/24).
+func newLAN(t *testing.T) (*simnet.LAN, *simnet.Segment, *simnet.Segment) {
+	t.Helper()
+	lan := simnet.New(1)
+	a, err := lan.AddSegment("a", "10.0.1.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := lan.AddSegment("b", "10.0.2.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lan, a, b
+}
+
+// addHost adds a host with one interface per (segment, address) pair: eth0, eth1, ...
+func addHost(t *testing.T, lan *simnet.LAN, name string, attach ...any) *simnet.Host {
+	t.Helper()
+	h := lan.AddHost(name)
+	for i := 0; i+1 < len(attach); i += 2 {
+		if err := h.Attach(fmt.Sprintf("eth%d", i/2), attach[i].(*simnet.Segment), attach[i+1].(string)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return h
+}
+
+// startAgent runs Discovery on h with cfg (network, port and service filled in). uuid is the host's CPU UUID, ip its primary address.
+func startAgent(t *testing.T, h *simnet.Host, uuid, ip string, cfg discovery.Config) *discovery.Discovery {
+	t.Helper()
+	conns, err := h.DiscoveryConns(testPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Network = h
+	cfg.DiscoveryUDPPort = testPort
+	cfg.DiscoveryServiceName = testService
+	cfg.ServicePort = 8889
+	cfg.Version = "1.0.0-1"
+	getter := func() (string, string, string, float64, uint64, uint64, float64, string) {
+		return h.Name(), ip, "test cpu", 1, 1024, 512, 50, uuid
+	}
+	d := discovery.NewWithConns(cfg, conns, getter)
+	go d.Run()
+	t.Cleanup(func() {
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	})
+	return d
+}
+
+func discover(t *testing.T, d *discovery.Discovery, opts discovery.DiscoveryRunOptions) []discovery.DiscoveryResponse {
+	t.Helper()
+	if opts.Timeout == 0 {
+		opts.Timeout = testTimeout
+	}
+	list, _, err := d.DoDiscovery(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("DoDiscovery: %v", err)
+	}
+	return list
+}
+
+// from returns the responded_from_ip of every response of the named host, sorted.
+func from(list []discovery.DiscoveryResponse, hostname string) []string {
+	var out []string
+	for _, r := range list {
+		if r.Hostname == hostname {
+			out = append(out, r.RespondedFromIP)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// impostor answers every DISCOVERY_REQUEST heard on h with a copy of resp (type, service and request_id filled in),
+// as a hand-written sender would: nothing in resp is checked.
+func impostor(t *testing.T, h *simnet.Host, resp discovery.DiscoveryResponse) {
+	t.Helper()
+	conns, err := h.DiscoveryConns(testPort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	})
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, from, err := conns[0].ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var req discovery.DiscoveryRequest
+			if json.Unmarshal(buf[:n], &req) != nil || req.Type != "DISCOVERY_REQUEST" {
+				continue
+			}
+			r := resp
+			r.Type, r.Service, r.RequestID = "DISCOVERY_RESPONSE", testService, req.RequestID
+			data, _ := json.Marshal(&r)
+			to := &net.UDPAddr{IP: from.IP, Port: from.Port}
+			if req.ReplyUDPPort > 0 {
+				to.Port = req.ReplyUDPPort
+			}
+			_, _ = conns[0].WriteToUDP(data, to)
+		}
+	}()
+}
+
+func TestMultiHomedHostRepliesFromEachSubnet(t *testing.T) {
+	lan, a, b := newLAN(t)
+	req := addHost(t, lan, "requester", a, "10.0.1.10", b, "10.0.2.10")
+	multi := addHost(t, lan, "multi", a, "10.0.1.20", b, "10.0.2.20")
+	startAgent(t, multi, "uuid-multi", "10.0.1.20", discovery.Config{})
+	d := startAgent(t, req, "uuid-req", "10.0.1.10", discovery.Config{
+		DiscoveryBroadcastAddresses: []string{a.Broadcast(), b.Broadcast()},
+	})
+
+	list := discover(t, d, discovery.DiscoveryRunOptions{ExcludeSelf: true})
+	if got, want := from(list, "multi"), []string{"10.0.1.20", "10.0.2.20"}; !slices.Equal(got, want) {
+		t.Fatalf("responded_from_ip of multi = %v, want %v", got, want)
+	}
+	for _, r := range list {
+		if r.Hostname == "multi" && r.HostIP != r.RespondedFromIP {
+			t.Errorf("reply from %s carries host_ip %s, want the address of that subnet", r.RespondedFromIP, r.HostIP)
+		}
+	}
+}
+
+func TestSignedMultiHomedHostRepliesFromEachSubnet(t *testing.T) {
+	lan, a, b := newLAN(t)
+	req := addHost(t, lan, "requester", a, "10.0.1.10", b, "10.0.2.10")
+	multi := addHost(t, lan, "multi", a, "10.0.1.20", b, "10.0.2.20")
+	// One Authenticator per agent, as in separate processes: each keeps its own replay cache.
+	startAgent(t, multi, "uuid-multi", "10.0.1.20", discovery.Config{Auth: discovery.NewAuthenticator("s3cret", 0)})
+	d := startAgent(t, req, "uuid-req", "10.0.1.10", discovery.Config{
+		DiscoveryBroadcastAddresses: []string{a.Broadcast(), b.Broadcast()},
+		Auth:                        discovery.NewAuthenticator("s3cret", 0),
+	})
+
+	list := discover(t, d, discovery.DiscoveryRunOptions{ExcludeSelf: true})
+	if got, want := from(list, "multi"), []string{"10.0.1.20", "10.0.2.20"}; !slices.Equal(got, want) {
+		t.Fatalf("responded_from_ip of multi = %v, want %v (each copy of the request needs its own nonce)", got, want)
+	}
+	if st := d.AuthStats(); st.Replayed != 0 {
+		t.Errorf("requester dropped %d responses as replayed", st.Replayed)
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	for _, dedup := range []bool{true, false} {
+		lan, a, _ := newLAN(t)
+		req := addHost(t, lan, "requester", a, "10.0.1.10")
+		peer := addHost(t, lan, "peer", a, "10.0.1.20")
+		startAgent(t, peer, "uuid-peer", "10.0.1.20", discovery.Config{})
+		// The static peer is also on the broadcast segment, so it answers twice on the same path.
+		d := startAgent(t, req, "uuid-req", "10.0.1.10", discovery.Config{
+			DiscoveryBroadcastAddresses: []string{a.Broadcast()},
+			StaticPeers:                 []string{"10.0.1.20"},
+			DiscoveryDeduplicate:        dedup,
+		})
+
+		got := from(discover(t, d, discovery.DiscoveryRunOptions{ExcludeSelf: true}), "peer")
+		want := []string{"10.0.1.20", "10.0.1.20"}
+		if dedup {
+			want = want[:1]
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("DiscoveryDeduplicate=%v: responses of peer from %v, want %v", dedup, got, want)
+		}
+	}
+}
+
+func TestExcludeSelf(t *testing.T) {
+	lan, a, _ := newLAN(t)
+	req := addHost(t, lan, "requester", a, "10.0.1.10")
+	peer := addHost(t, lan, "peer", a, "10.0.1.20")
+	startAgent(t, peer, "uuid-peer", "10.0.1.20", discovery.Config{})
+	d := startAgent(t, req, "uuid-req", "10.0.1.10", discovery.Config{
+		DiscoveryBroadcastAddresses: []string{a.Broadcast()},
+		DiscoveryDeduplicate:        true,
+	})
+
+	list := discover(t, d, discovery.DiscoveryRunOptions{})
+	self := 0
+	for _, r := range list {
+		if r.Hostname == "requester" {
+			self++
+			if !r.IsSelf {
+				t.Errorf("own response not marked self: %+v", r)
+			}
+		}
+	}
+	if self != 1 || len(from(list, "peer")) != 1 {
+		t.Fatalf("without ExcludeSelf: %d own and %d peer response(s), want 1 and 1", self, len(from(list, "peer")))
+	}
+
+	list = discover(t, d, discovery.DiscoveryRunOptions{ExcludeSelf: true})
+	if n := len(from(list, "requester")); n != 0 {
+		t.Errorf("ExcludeSelf: %d own response(s) included", n)
+	}
+	if n := len(from(list, "peer")); n != 1 {
+		t.Errorf("ExcludeSelf: %d peer response(s), want 1", n)
+	}
+}
+
+// routedLAN adds segment c (10.0.3.0/24), routed to a, with host "remote" at 10.0.3.5 running an agent.
+func routedLAN(t *testing.T, lan *simnet.LAN, a *simnet.Segment) *simnet.Segment {
+	t.Helper()
+	c, err := lan.AddSegment("c", "10.0.3.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lan.Route(a, c)
+	remote := addHost(t, lan, "remote", c, "10.0.3.5")
+	startAgent(t, remote, "uuid-remote", "10.0.3.5", discovery.Config{})
+	return c
+}
+
+func TestRoutedSegmentThroughStaticPeers(t *testing.T) {
+	lan, a, _ := newLAN(t)
+	routedLAN(t, lan, a)
+	req := addHost(t, lan, "requester", a, "10.0.1.10")
+	d := startAgent(t, req, "uuid-req", "10.0.1.10", discovery.Config{
+		DiscoveryBroadcastAddresses: []string{a.Broadcast()},
+	})
+	if n := len(from(discover(t, d, discovery.DiscoveryRunOptions{ExcludeSelf: true}), "remote")); n != 0 {
+		t.Fatalf("broadcast alone reached the routed segment (%d response(s))", n)
+	}
+
+	req2 := addHost(t, lan, "requester2", a, "10.0.1.11")
+	d2 := startAgent(t, req2, "uuid-req2", "10.0.1.11", discovery.Config{
+		DiscoveryBroadcastAddresses: []string{a.Broadcast()},
+		StaticPeers:                 []string{"10.0.3.0/29"},
+	})
+	if got, want := from(discover(t, d2, discovery.DiscoveryRunOptions{ExcludeSelf: true}), "remote"), []string{"10.0.3.5"}; !slices.Equal(got, want) {
+		t.Fatalf("with static peers: responses of remote from %v, want %v", got, want)
+	}
+}
+
+func TestStaticPeersWithoutBroadcastAddresses(t *testing.T) {
+	lan, a, _ := newLAN(t)
+	routedLAN(t, lan, a)
+	req := addHost(t, lan, "requester", a, "10.0.1.10")
+	d := startAgent(t, req, "uuid-req", "10.0.1.10", discovery.Config{
+		StaticPeers: []string{"10.0.3.5"},
+	})
+	if got, want := from(discover(t, d, discovery.DiscoveryRunOptions{ExcludeSelf: true}), "remote"), []string{"10.0.3.5"}; !slices.Equal(got, want) {
+		t.Fatalf("responses of remote from %v, want %v", got, want)
+	}
+}
+
+func TestRoutedSegmentThroughRelay(t *testing.T) {
+	lan, a, _ := newLAN(t)
+	c := routedLAN(t, lan, a)
+	relay := addHost(t, lan, "relay", c, "10.0.3.7")
+	req := addHost(t, lan, "requester", a, "10.0.1.10")
+	// Unsigned relays only serve their own relay peers.
+	startAgent(t, relay, "uuid-relay", "10.0.3.7", discovery.Config{
+		DiscoveryBroadcastAddresses: []string{c.Broadcast()},
+		RelayAccept:                 true,
+		RelayPeers:                  []*net.UDPAddr{{IP: net.ParseIP("10.0.1.10").To4(), Port: testPort}},
+	})
+	d := startAgent(t, req, "uuid-req", "10.0.1.10", discovery.Config{
+		DiscoveryBroadcastAddresses: []string{a.Broadcast()},
+		RelayPeers:                  []*net.UDPAddr{{IP: net.ParseIP("10.0.3.7").To4(), Port: testPort}},
+	})
+
+	list := discover(t, d, discovery.DiscoveryRunOptions{ExcludeSelf: true, Timeout: 1500 * time.Millisecond})
+	var remote *discovery.DiscoveryResponse
+	for i := range list {
+		if list[i].Hostname == "remote" {
+			remote = &list[i]
+		}
+	}
+	if remote == nil {
+		t.Fatalf("remote not found through the relay; got %d response(s)", len(list))
+	}
+	if len(remote.RelayPath) != 1 || remote.RespondedFromIP != "10.0.3.5" {
+		t.Errorf("relayed response: relay_path %v, responded_from_ip %s; want one hop and 10.0.3.5", remote.RelayPath, remote.RespondedFromIP)
+	}
+}
+
+func TestRelayRefusesUnsignedStranger(t *testing.T) {
+	lan, a, _ := newLAN(t)
+	c := routedLAN(t, lan, a)
+	relay := addHost(t, lan, "relay", c, "10.0.3.7")
+	req := addHost(t, lan, "requester", a, "10.0.1.10")
+	startAgent(t, relay, "uuid-relay", "10.0.3.7", discovery.Config{
+		DiscoveryBroadcastAddresses: []string{c.Broadcast()},
+		RelayAccept:                 true,
+	})
+	d := startAgent(t, req, "uuid-req", "10.0.1.10", discovery.Config{
+		DiscoveryBroadcastAddresses: []string{a.Broadcast()},
+		RelayPeers:                  []*net.UDPAddr{{IP: net.ParseIP("10.0.3.7").To4(), Port: testPort}},
+	})
+	list := discover(t, d, discovery.DiscoveryRunOptions{ExcludeSelf: true, Timeout: 1500 * time.Millisecond})
+	if n := len(from(list, "remote")); n != 0 {
+		t.Fatalf("relay without the requester as relay peer forwarded %d response(s)", n)
+	}
+}
+
+func TestSegmentLoss(t *testing.T) {
+	lan, a, b := newLAN(t)
+	req := addHost(t, lan, "requester", a, "10.0.1.10", b, "10.0.2.10")
+	peerA := addHost(t, lan, "peer-a", a, "10.0.1.20")
+	peerB := addHost(t, lan, "peer-b", b, "10.0.2.20")
+	startAgent(t, peerA, "uuid-a", "10.0.1.20", discovery.Config{})
+	startAgent(t, peerB, "uuid-b", "10.0.2.20", discovery.Config{})
+	d := startAgent(t, req, "uuid-req", "10.0.1.10", discovery.Config{
+		DiscoveryBroadcastAddresses: []string{a.Broadcast(), b.Broadcast()},
+	})
+
+	b.SetLoss(1)
+	_, droppedBefore := lan.Stats()
+	list := discover(t, d, discovery.DiscoveryRunOptions{ExcludeSelf: true})
+	if len(from(list, "peer-a")) != 1 || len(from(list, "peer-b")) != 0 {
+		t.Fatalf("segment b lossy: peer-a %v, peer-b %v; want only peer-a", from(list, "peer-a"), from(list, "peer-b"))
+	}
+	if _, dropped := lan.Stats(); dropped == droppedBefore {
+		t.Errorf("no packet counted as dropped on the lossy segment")
+	}
+
+	b.SetLoss(0)
+	list = discover(t, d, discovery.DiscoveryRunOptions{ExcludeSelf: true})
+	if len(from(list, "peer-a")) != 1 || len(from(list, "peer-b")) != 1 {
+		t.Fatalf("loss cleared: peer-a %v, peer-b %v; want both", from(list, "peer-a"), from(list, "peer-b"))
+	}
+}
+
+func TestForgedRelayOriginIgnored(t *testing.T) {
+	lan, a, _ := newLAN(t)
+	forger := addHost(t, lan, "forger", a, "10.0.1.66")
+	req := addHost(t, lan, "requester", a, "10.0.1.10")
+	impostor(t, forger, discovery.DiscoveryResponse{
+		Hostname: "forger", HostIP: "10.0.1.66", CPUUUID: "uuid-forger",
+		RelayPath: []string{"relay"}, RespondedFromIP: "203.0.113.9",
+	})
+	d := startAgent(t, req, "uuid-req", "10.0.1.10", discovery.Config{
+		DiscoveryBroadcastAddresses: []string{a.Broadcast()},
+	})
+	list := discover(t, d, discovery.DiscoveryRunOptions{ExcludeSelf: true})
+	if got, want := from(list, "forger"), []string{"10.0.1.66"}; !slices.Equal(got, want) {
+		t.Fatalf("responded_from_ip of a relay_path response from a non-peer = %v, want the packet source %v", got, want)
+	}
+}
+
+func TestForgedSourceIgnored(t *testing.T) {
+	lan, a, _ := newLAN(t)
+	// Two hosts on one address would be a host_ip conflict; a response claiming to be an mDNS result must not hide it.
+	owner := addHost(t, lan, "owner", a, "10.0.1.20")
+	forger := addHost(t, lan, "forger", a, "10.0.1.66")
+	req := addHost(t, lan, "requester", a, "10.0.1.10")
+	startAgent(t, owner, "uuid-owner", "10.0.1.20", discovery.Config{})
+	impostor(t, forger, discovery.DiscoveryResponse{
+		Hostname: "forger", HostIP: "10.0.1.20", CPUUUID: "uuid-forger", Source: discovery.SourceMDNS, IsSelf: true,
+	})
+	d := startAgent(t, req, "uuid-req", "10.0.1.10", discovery.Config{
+		DiscoveryBroadcastAddresses: []string{a.Broadcast()},
+	})
+	list, conflicts, err := d.DoDiscovery(context.Background(), discovery.DiscoveryRunOptions{Timeout: testTimeout})
+	if err != nil {
+		t.Fatalf("DoDiscovery: %v", err)
+	}
+	for _, r := range list {
+		if r.Hostname == "forger" && (r.Source != "" || r.IsSelf) {
+			t.Errorf("forged response kept source %q, self %v", r.Source, r.IsSelf)
+		}
+	}
+	if len(conflicts) != 1 || conflicts[0].Kind != discovery.ConflictHostIP {
+		t.Fatalf("conflicts = %+v, want the host_ip conflict the forged source tried to hide", conflicts)
+	}
+}
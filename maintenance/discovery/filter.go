@@ -0,0 +1,162 @@
+package discovery
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"contrabass-agent/maintenance/config"
+)
+
+// DiscoveryFilter narrows which agents answer a DISCOVERY_REQUEST. All set fields must match (AND).
+// Agents that do not match stay silent; agents older than ProtoVersion 2 ignore the filter and answer anyway,
+// so requesters also apply MatchResponse to what they receive.
+type DiscoveryFilter struct {
+	// Hostname is a glob (path.Match syntax: *, ?, [a-z]), compared case-insensitively.
+	Hostname string `json:"hostname,omitempty"`
+	// MinVersion / MaxVersion bound the agent's version key (inclusive, config.CompareVersionKeys).
+	MinVersion string `json:"min_version,omitempty"`
+	MaxVersion string `json:"max_version,omitempty"`
+	// CPUUUID matches exactly (case-insensitive).
+	CPUUUID string `json:"cpu_uuid,omitempty"`
+	// Labels are selectors: "key=value", "key!=value", "key" (present) or "!key" (absent).
+	Labels []string `json:"labels,omitempty"`
+}
+
+// IsZero reports whether the filter has no conditions (f may be nil).
+func (f *DiscoveryFilter) IsZero() bool {
+	return f == nil || (f.Hostname == "" && f.MinVersion == "" && f.MaxVersion == "" && f.CPUUUID == "" && len(f.Labels) == 0)
+}
+
+// Validate checks the hostname glob and label selector syntax.
+func (f *DiscoveryFilter) Validate() error {
+	if f == nil {
+		return nil
+	}
+	if f.Hostname != "" {
+		if _, err := path.Match(f.Hostname, ""); err != nil {
+			return fmt.Errorf("hostname filter %q: %v", f.Hostname, err)
+		}
+	}
+	for _, sel := range f.Labels {
+		if _, _, _, err := parseLabelSelector(sel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match reports whether an agent with these attributes satisfies the filter. A nil filter matches everything.
+func (f *DiscoveryFilter) Match(hostname, version, cpuUUID string, labels map[string]string) bool {
+	if f == nil {
+		return true
+	}
+	if f.Hostname != "" {
+		ok, err := path.Match(strings.ToLower(f.Hostname), strings.ToLower(hostname))
+		if err != nil || !ok {
+			return false
+		}
+	}
+	if f.MinVersion != "" && (version == "" || config.CompareVersionKeys(version, f.MinVersion) < 0) {
+		return false
+	}
+	if f.MaxVersion != "" && (version == "" || config.CompareVersionKeys(version, f.MaxVersion) > 0) {
+		return false
+	}
+	if f.CPUUUID != "" && !strings.EqualFold(strings.TrimSpace(f.CPUUUID), strings.TrimSpace(cpuUUID)) {
+		return false
+	}
+	for _, sel := range f.Labels {
+		key, op, val, err := parseLabelSelector(sel)
+		if err != nil {
+			return false
+		}
+		have, present := labels[key]
+		switch op {
+		case "=":
+			if !present || have != val {
+				return false
+			}
+		case "!=":
+			if present && have == val {
+				return false
+			}
+		case "exists":
+			if !present {
+				return false
+			}
+		case "!exists":
+			if present {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// MatchResponse applies the filter to a received DISCOVERY_RESPONSE (for agents that ignored the filter).
+// Label selectors are skipped for legacy responders (no proto_version), whose responses carry no labels.
+func (f *DiscoveryFilter) MatchResponse(r *DiscoveryResponse) bool {
+	if f == nil {
+		return true
+	}
+	if r.ProtoVersion <= 0 {
+		g := *f
+		g.Labels = nil
+		return g.Match(r.Hostname, r.Version, r.CPUUUID, nil)
+	}
+	return f.Match(r.Hostname, r.Version, r.CPUUUID, r.Labels)
+}
+
+// FormatLabels renders labels as "k1=v1,k2=v2" sorted by key ("" when empty); the same form --label accepts.
+func FormatLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+labels[k])
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseLabelSelectors splits comma-separated selectors ("role=db,env!=prod") and validates each.
+func ParseLabelSelectors(s string) ([]string, error) {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if _, _, _, err := parseLabelSelector(part); err != nil {
+			return nil, err
+		}
+		out = append(out, part)
+	}
+	return out, nil
+}
+
+// parseLabelSelector returns key, operator ("=", "!=", "exists", "!exists") and value.
+func parseLabelSelector(sel string) (key, op, val string, err error) {
+	sel = strings.TrimSpace(sel)
+	switch {
+	case strings.Contains(sel, "!="):
+		i := strings.Index(sel, "!=")
+		key, op, val = sel[:i], "!=", sel[i+2:]
+	case strings.Contains(sel, "="):
+		i := strings.Index(sel, "=")
+		key, op, val = sel[:i], "=", sel[i+1:]
+	case strings.HasPrefix(sel, "!"):
+		key, op = sel[1:], "!exists"
+	default:
+		key, op = sel, "exists"
+	}
+	key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+	if key == "" || strings.ContainsAny(key, "=! ") {
+		return "", "", "", fmt.Errorf("invalid label selector %q (use key=value, key!=value, key or !key)", sel)
+	}
+	return key, op, val, nil
+}
@@ -0,0 +1,133 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/net/ipv6"
+)
+
+// DefaultIPv6Group is the link-local (ff02::/16) multicast group agents join for IPv6 discovery ("mole" in the low 32 bits).
+const DefaultIPv6Group = "ff02::6d6f:6c65"
+
+// ParseIPv6Group parses a multicast group for IPv6 discovery. Empty s means DefaultIPv6Group.
+func ParseIPv6Group(s string) (net.IP, error) {
+	if s == "" {
+		s = DefaultIPv6Group
+	}
+	ip := net.ParseIP(s)
+	if ip == nil || ip.To4() != nil || !ip.IsMulticast() {
+		return nil, fmt.Errorf("discovery: %q is not an IPv6 multicast group", s)
+	}
+	return ip, nil
+}
+
+// OpenIPv6DiscoveryUDP binds [::]:port (udp6, SO_REUSEPORT) and joins group on each named interface.
+// Responders pass the included interfaces; requesters that only send to the group and read unicast replies pass none.
+// Interfaces that cannot join are logged and skipped; an error is returned only when the socket itself cannot be opened.
+func OpenIPv6DiscoveryUDP(port int, group net.IP, ifaces []string) (*net.UDPConn, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			return c.Control(func(fd uintptr) {
+				_ = SetReusePort(fd)
+			})
+		},
+	}
+	pc, err := lc.ListenPacket(context.Background(), "udp6", net.JoinHostPort("::", strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	conn := pc.(*net.UDPConn)
+	if len(ifaces) == 0 {
+		return conn, nil
+	}
+	pc6 := ipv6.NewPacketConn(conn)
+	for _, name := range ifaces {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			log.Printf("discovery: ipv6 join %s on %s: %v", group, name, err)
+			continue
+		}
+		if err := pc6.JoinGroup(ifi, &net.UDPAddr{IP: group}); err != nil {
+			log.Printf("discovery: ipv6 join %s on %s: %v", group, name, err)
+		}
+	}
+	return conn, nil
+}
+
+// IPv6GroupTargets returns group:port once per interface, with the interface as zone so the kernel sends on that link.
+func IPv6GroupTargets(group net.IP, port int, ifaces []string) []*net.UDPAddr {
+	out := make([]*net.UDPAddr, 0, len(ifaces))
+	for _, name := range ifaces {
+		out = append(out, &net.UDPAddr{IP: group, Port: port, Zone: name})
+	}
+	return out
+}
+
+// respondedFrom formats the UDP source for responded_from_ip. Link-local IPv6 keeps the zone
+// ("fe80::1%eth0", the receiver's interface) because the address is unusable without it.
+func respondedFrom(from *net.UDPAddr) string {
+	if from.Zone != "" && from.IP.To4() == nil && from.IP.IsLinkLocalUnicast() {
+		return from.IP.String() + "%" + from.Zone
+	}
+	return from.IP.String()
+}
+
+// localIPv6OnZone returns a link-local IPv6 address of the named interface (the zone a request arrived on), or nil.
+func (d *Discovery) localIPv6OnZone(zone string) net.IP {
+	if zone == "" {
+		return nil
+	}
+	ifi, ok := interfaceByName(d.network(), zone)
+	if !ok {
+		return nil
+	}
+	for _, ipnet := range ifi.Addrs {
+		if ipnet.IP.To4() != nil || !ipnet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		return ipnet.IP
+	}
+	return nil
+}
+
+// connForFamily returns the first conn whose local address has the same family as ip (IPv4 vs IPv6), or nil.
+func connForFamily(conns []PacketConn, ip net.IP) PacketConn {
+	want6 := ip.To4() == nil
+	for _, c := range conns {
+		la, ok := c.LocalAddr().(*net.UDPAddr)
+		if !ok || la == nil {
+			continue
+		}
+		if (la.IP.To4() == nil) == want6 {
+			return c
+		}
+	}
+	return nil
+}
+
+// sendIPv6 sends data to the configured IPv6 group on each IPv6 interface and returns how many sends succeeded
+// (0 when IPv6 is off). what names the message in error logs.
+func (d *Discovery) sendIPv6(data []byte, what string) int {
+	if d.cfg.IPv6Group == nil || len(d.cfg.IPv6Interfaces) == 0 {
+		return 0
+	}
+	conn := connForFamily(d.connList(), d.cfg.IPv6Group)
+	if conn == nil {
+		log.Printf("discovery: ipv6 enabled but no udp6 socket; %s not sent to %s", what, d.cfg.IPv6Group)
+		return 0
+	}
+	sent := 0
+	for _, addr := range IPv6GroupTargets(d.cfg.IPv6Group, d.cfg.DiscoveryUDPPort, d.cfg.IPv6Interfaces) {
+		if _, err := conn.WriteToUDP(d.perSend(data), addr); err != nil {
+			log.Printf("discovery: send %s to %s: %v", what, addr, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
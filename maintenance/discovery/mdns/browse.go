@@ -0,0 +1,175 @@
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"contrabass-agent/maintenance/discovery"
+)
+
+// Browse sends a _contrabass._tcp PTR query on each interface in ifaces (empty = every multicast interface) and collects
+// the agents that answer until wait passes or ctx is done. The query goes out from an ephemeral port, so responders
+// (agents and avahi alike) answer by unicast (legacy query, RFC 6762 §6.7) and nothing needs to bind 5353.
+func Browse(ctx context.Context, ifaces []string, wait time.Duration) ([]Entry, error) {
+	ls := links(ifaces)
+	if len(ls) == 0 {
+		return nil, fmt.Errorf("mdns: no multicast interface with an IPv4 address")
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if err := discovery.SetMulticastTTL(conn, 255); err != nil {
+		log.Printf("mdns: set multicast TTL: %v", err)
+	}
+	id := uint16(rand.UintN(1 << 16))
+	query, err := browseQuery(id)
+	if err != nil {
+		return nil, err
+	}
+	sent := 0
+	for i := range ls {
+		if err := setMulticastInterface(conn, ls[i].index); err != nil {
+			log.Printf("mdns: browse on %s: %v", ls[i].name, err)
+			continue
+		}
+		if _, err := conn.WriteToUDP(query, &net.UDPAddr{IP: groupIPv4, Port: Port}); err != nil {
+			log.Printf("mdns: browse on %s: %v", ls[i].name, err)
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		return nil, fmt.Errorf("mdns: query could not be sent on any interface")
+	}
+	deadline := time.Now().Add(wait)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetReadDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+	c := newCollector()
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		c.add(buf[:n], id, from)
+	}
+	return c.entries(), nil
+}
+
+func browseQuery(id uint16) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 0, 64), dnsmessage.Header{ID: id})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: mustName(serviceName), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// collector gathers the records of all answers to one browse; map keys are lowercase names.
+type collector struct {
+	instances []string          // PTR targets as received, first-seen order
+	from      map[string]string // instance → source IP of the first answer naming it
+	srv       map[string]dnsmessage.SRVResource
+	txt       map[string][]string
+	addrs     map[string][]string // host name → IPv4 addresses
+}
+
+func newCollector() *collector {
+	return &collector{
+		from:  make(map[string]string),
+		srv:   make(map[string]dnsmessage.SRVResource),
+		txt:   make(map[string][]string),
+		addrs: make(map[string][]string),
+	}
+}
+
+func (c *collector) add(raw []byte, id uint16, from *net.UDPAddr) {
+	var p dnsmessage.Parser
+	h, err := p.Start(raw)
+	if err != nil || !h.Response || h.ID != id {
+		return
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return
+	}
+	_ = p.SkipAllAuthorities()
+	extras, _ := p.AllAdditionals()
+	for _, rr := range append(answers, extras...) {
+		name := strings.ToLower(rr.Header.Name.String())
+		switch body := rr.Body.(type) {
+		case *dnsmessage.PTRResource:
+			if name != serviceName || rr.Header.TTL == 0 {
+				continue
+			}
+			inst := body.PTR.String()
+			if _, ok := c.from[strings.ToLower(inst)]; !ok {
+				c.instances = append(c.instances, inst)
+				c.from[strings.ToLower(inst)] = from.IP.String()
+			}
+		case *dnsmessage.SRVResource:
+			c.srv[name] = *body
+		case *dnsmessage.TXTResource:
+			c.txt[name] = body.TXT
+		case *dnsmessage.AResource:
+			if ip := net.IP(body.A[:]).String(); !slices.Contains(c.addrs[name], ip) {
+				c.addrs[name] = append(c.addrs[name], ip)
+			}
+		}
+	}
+}
+
+// entries assembles one Entry per announced instance. Instances without SRV fall back to the TXT http_port.
+func (c *collector) entries() []Entry {
+	out := make([]Entry, 0, len(c.instances))
+	for _, inst := range c.instances {
+		key := strings.ToLower(inst)
+		e := Entry{Instance: trimSuffixFold(inst, "."+serviceName), From: c.from[key], TXT: make(map[string]string)}
+		for _, kv := range c.txt[key] {
+			k, v, _ := strings.Cut(kv, "=")
+			e.TXT[strings.ToLower(k)] = v
+		}
+		e.APIPrefix = e.TXT[TXTAPIPrefix]
+		e.Version = e.TXT[TXTVersionKey]
+		e.CPUUUID = e.TXT[TXTCPUUUID]
+		e.HTTPPort, _ = strconv.Atoi(e.TXT[TXTHTTPPort])
+		e.ServicePort, _ = strconv.Atoi(e.TXT[TXTServicePort])
+		if srv, ok := c.srv[key]; ok {
+			e.Hostname = trimSuffixFold(trimSuffixFold(srv.Target.String(), ".local."), hostSuffix)
+			e.HTTPPort = int(srv.Port)
+			e.IPs = c.addrs[strings.ToLower(srv.Target.String())]
+		}
+		if e.Hostname == "" {
+			e.Hostname = e.Instance
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func trimSuffixFold(s, suffix string) string {
+	if len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix) {
+		return s[:len(s)-len(suffix)]
+	}
+	return s
+}
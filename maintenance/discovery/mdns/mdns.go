@@ -0,0 +1,221 @@
+// Package mdns announces agents as a DNS-SD service (_contrabass._tcp.local, RFC 6762 / 6763) and browses for them,
+// so avahi-browse, service browsers and DNS-SD based scrapers see agents without speaking the UDP 9999 protocol.
+// IPv4 only (224.0.0.251:5353).
+package mdns
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+
+	"contrabass-agent/maintenance/discovery"
+)
+
+// ServiceType is the DNS-SD service type agents announce.
+const ServiceType = "_contrabass._tcp"
+
+// Port is the mDNS port.
+const Port = 5353
+
+// TXT record keys (RFC 6763 §6: key=value, one string each).
+const (
+	TXTVersion     = "txtvers"      // always "1"
+	TXTHTTPPort    = "http_port"    // Server.HTTPPort (also the SRV port)
+	TXTAPIPrefix   = "api_prefix"   // Maintenance.APIPrefix
+	TXTVersionKey  = "version"      // build version key
+	TXTCPUUUID     = "cpu_uuid"     // hostinfo CPU UUID
+	TXTServicePort = "service_port" // MaintenancePort, the port a DISCOVERY_RESPONSE reports
+)
+
+// Record TTLs (RFC 6762 §10): host address records 120s, the others 75 minutes.
+const (
+	hostTTL    = 120
+	serviceTTL = 4500
+	// legacyTTL caps TTLs in answers to legacy unicast queries (RFC 6762 §6.7).
+	legacyTTL = 10
+)
+
+var groupIPv4 = net.IPv4(224, 0, 0, 251).To4()
+
+// classCacheFlush is the top bit of a resource class (cache-flush, RFC 6762 §10.2); in a question it asks for a unicast answer.
+const classCacheFlush = 0x8000
+
+const (
+	servicesName = "_services._dns-sd._udp.local."
+	serviceName  = ServiceType + ".local."
+)
+
+// hostSuffix keeps the SRV target off "<hostname>.local.", which avahi or systemd-resolved already owns on most hosts:
+// the agent answers A only for "<instance>-contrabass.local.".
+const hostSuffix = "-contrabass"
+
+// Service is what this agent announces.
+type Service struct {
+	Instance    string // DNS-SD instance label; dots are replaced (default: first label of Hostname)
+	Hostname    string // default instance; the SRV target is <instance>-contrabass.local.
+	HTTPPort    int
+	ServicePort int
+	APIPrefix   string
+	Version     string
+	CPUUUID     string
+}
+
+// instanceLabel is Instance, or the first label of Hostname, as one DNS label.
+func (s Service) instanceLabel() string {
+	inst := s.Instance
+	if inst == "" {
+		inst, _, _ = strings.Cut(s.Hostname, ".")
+	}
+	return label(inst)
+}
+
+// instanceName is "<Instance>._contrabass._tcp.local.".
+func (s Service) instanceName() string {
+	return s.instanceLabel() + "." + serviceName
+}
+
+// hostName is "<instance>-contrabass.local.", the SRV target and the name of the A records.
+func (s Service) hostName() string {
+	inst := s.instanceLabel()
+	if len(inst) > 63-len(hostSuffix) {
+		inst = inst[:63-len(hostSuffix)]
+	}
+	return inst + hostSuffix + ".local."
+}
+
+func (s Service) txt() []string {
+	return []string{
+		TXTVersion + "=1",
+		TXTHTTPPort + "=" + strconv.Itoa(s.HTTPPort),
+		TXTAPIPrefix + "=" + s.APIPrefix,
+		TXTVersionKey + "=" + s.Version,
+		TXTCPUUUID + "=" + s.CPUUUID,
+		TXTServicePort + "=" + strconv.Itoa(s.ServicePort),
+	}
+}
+
+// label makes s usable as one DNS label: no dots, at most 63 bytes, never empty.
+func label(s string) string {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ".", "-")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	if s == "" {
+		s = "contrabass"
+	}
+	return s
+}
+
+// Entry is one agent found by Browse.
+type Entry struct {
+	Instance    string            `json:"instance"`
+	Hostname    string            `json:"hostname"` // SRV target without "-contrabass.local."
+	HTTPPort    int               `json:"http_port"`
+	ServicePort int               `json:"service_port,omitempty"`
+	APIPrefix   string            `json:"api_prefix,omitempty"`
+	Version     string            `json:"version,omitempty"`
+	CPUUUID     string            `json:"cpu_uuid,omitempty"`
+	IPs         []string          `json:"ips,omitempty"` // A records of the SRV target
+	From        string            `json:"from"`          // source IP of the first answer
+	TXT         map[string]string `json:"txt,omitempty"`
+}
+
+// Response turns e into a discovery result (Source "mdns"), for merging into a discovery run.
+// Usage figures are not in the record and stay zero.
+func (e Entry) Response(service string) discovery.DiscoveryResponse {
+	hostIP := e.From
+	if len(e.IPs) > 0 {
+		hostIP = e.IPs[0]
+	}
+	return discovery.DiscoveryResponse{
+		Type:            "DISCOVERY_RESPONSE",
+		Service:         service,
+		HostIP:          hostIP,
+		Hostname:        e.Hostname,
+		ServicePort:     e.ServicePort,
+		Version:         e.Version,
+		CPUUUID:         e.CPUUUID,
+		RespondedFromIP: e.From,
+		Source:          discovery.SourceMDNS,
+	}
+}
+
+func mustName(s string) dnsmessage.Name {
+	n, err := dnsmessage.NewName(s)
+	if err != nil {
+		// label() keeps every name we build well under 255 bytes.
+		panic(fmt.Sprintf("mdns: bad name %q: %v", s, err))
+	}
+	return n
+}
+
+// sameName compares DNS names case-insensitively.
+func sameName(a dnsmessage.Name, b string) bool {
+	return strings.EqualFold(a.String(), b)
+}
+
+// link is an interface mDNS runs on, with its IPv4 addresses.
+type link struct {
+	name  string
+	index int
+	nets  []*net.IPNet
+}
+
+func (l *link) ips() []net.IP {
+	out := make([]net.IP, 0, len(l.nets))
+	for _, n := range l.nets {
+		out = append(out, n.IP.To4())
+	}
+	return out
+}
+
+// links returns the up, multicast-capable interfaces with an IPv4 address, limited to names when names is not empty.
+func links(names []string) []link {
+	var out []link
+	ifaces, _ := net.Interfaces()
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 || ifi.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if len(names) > 0 && !slices.Contains(names, ifi.Name) {
+			continue
+		}
+		l := link{name: ifi.Name, index: ifi.Index}
+		addrs, _ := ifi.Addrs()
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				l.nets = append(l.nets, ipnet)
+			}
+		}
+		if len(l.nets) > 0 {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// linkFor returns the link ip is on (one of its subnets, or one of its own addresses), or nil.
+func linkFor(ls []link, ip net.IP) *link {
+	for i := range ls {
+		for _, n := range ls[i].nets {
+			if n.Contains(ip) {
+				return &ls[i]
+			}
+		}
+	}
+	return nil
+}
+
+// setMulticastInterface makes the next group sends on conn leave through ifindex (IP_MULTICAST_IF).
+func setMulticastInterface(conn *net.UDPConn, ifindex int) error {
+	ifi, err := net.InterfaceByIndex(ifindex)
+	if err != nil {
+		return err
+	}
+	return ipv4.NewPacketConn(conn).SetMulticastInterface(ifi)
+}
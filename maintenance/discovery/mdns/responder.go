@@ -0,0 +1,455 @@
+package mdns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"contrabass-agent/maintenance/discovery"
+)
+
+// recordSet selects the records of a response.
+type recordSet uint8
+
+const (
+	recServices recordSet = 1 << iota // _services._dns-sd._udp PTR → _contrabass._tcp
+	recPTR                            // _contrabass._tcp PTR → instance
+	recSRV
+	recTXT
+	recA
+)
+
+// answerMode sets TTLs and the cache-flush bit.
+type answerMode int
+
+const (
+	modeMulticast answerMode = iota // normal TTLs, cache-flush on unique records
+	modeLegacy                      // TTLs capped at legacyTTL, no cache-flush, questions echoed
+	modeGoodbye                     // TTL 0: the service is going away
+)
+
+// Probing (RFC 6762 §8.1): three queries probeInterval apart, listening for an answer that claims our names.
+const (
+	probeInterval = 250 * time.Millisecond
+	probeCount    = 3
+	// maxProbeAttempts bounds the renames ("<instance>-2", "-3" …) after conflicts.
+	maxProbeAttempts = 10
+)
+
+// Responder answers mDNS queries for this agent's service and announces it. UDP 5353 is shared with avahi or another
+// responder on the host (SO_REUSEADDR / SO_REUSEPORT); the group is joined on the interfaces ifaces returns.
+type Responder struct {
+	conn   *net.UDPConn
+	svc    Service
+	ifaces func() []string // nil or empty result = every multicast interface
+
+	mu     sync.Mutex // joined; also keeps IP_MULTICAST_IF and the write together
+	joined map[string]bool
+}
+
+// Listen binds 0.0.0.0:5353, joins 224.0.0.251 on the interfaces ifaces returns and probes the instance and host
+// names (about 750ms; a taken name gets a "-2", "-3" … suffix). Call Run to answer queries.
+func Listen(svc Service, ifaces func() []string) (*Responder, error) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			return c.Control(func(fd uintptr) {
+				_ = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+				_ = discovery.SetReusePort(fd)
+			})
+		},
+	}
+	pc, err := lc.ListenPacket(context.Background(), "udp4", ":"+strconv.Itoa(Port))
+	if err != nil {
+		return nil, err
+	}
+	conn := pc.(*net.UDPConn)
+	// RFC 6762 §11: mDNS packets go out with TTL 255 (receivers drop anything else as possibly off-link).
+	if err := discovery.SetMulticastTTL(conn, 255); err != nil {
+		log.Printf("mdns: set multicast TTL: %v", err)
+	}
+	r := &Responder{conn: conn, svc: svc, ifaces: ifaces, joined: make(map[string]bool)}
+	r.join()
+	if err := r.probe(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// probe makes sure no other responder on the joined links answers for the instance or host name before they are
+// announced, renaming the instance on a conflict. Simultaneous probes are not tie-broken: the first to announce wins.
+func (r *Responder) probe() error {
+	base := r.svc.instanceLabel()
+	if len(base) > 60 {
+		base = base[:60]
+	}
+	for attempt := 1; attempt <= maxProbeAttempts; attempt++ {
+		if attempt > 1 {
+			r.svc.Instance = fmt.Sprintf("%s-%d", base, attempt)
+		}
+		conflict, err := r.probeOnce()
+		if err != nil {
+			return err
+		}
+		if !conflict {
+			return nil
+		}
+		log.Printf("mdns: %s or %s is already taken on the link", r.svc.instanceName(), r.svc.hostName())
+	}
+	return fmt.Errorf("mdns: no free instance name after %d probes", maxProbeAttempts)
+}
+
+// probeOnce sends the probe queries (QU, type ANY for both names) and reports whether a response claimed either name.
+func (r *Responder) probeOnce() (bool, error) {
+	inst, host := r.svc.instanceName(), r.svc.hostName()
+	query, err := probeQuery(inst, host)
+	if err != nil {
+		return false, err
+	}
+	ls := links(r.Interfaces())
+	if len(ls) == 0 {
+		return false, nil
+	}
+	defer func() { _ = r.conn.SetReadDeadline(time.Time{}) }()
+	buf := make([]byte, 9000)
+	conflict := false
+	for i := 0; i < probeCount; i++ {
+		for j := range ls {
+			if err := r.sendGroup(&ls[j], query); err != nil {
+				log.Printf("mdns: probe on %s: %v", ls[j].name, err)
+			}
+		}
+		_ = r.conn.SetReadDeadline(time.Now().Add(probeInterval))
+		for {
+			n, _, err := r.conn.ReadFromUDP(buf)
+			if err != nil {
+				break
+			}
+			if claims(buf[:n], inst, host) {
+				conflict = true
+			}
+		}
+		if conflict {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// probeQuery asks for any record of the instance and host names, preferring unicast answers.
+func probeQuery(names ...string) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, n := range names {
+		q := dnsmessage.Question{Name: mustName(n), Type: dnsmessage.TypeALL, Class: dnsmessage.ClassINET | classCacheFlush}
+		if err := b.Question(q); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// claims reports whether raw is a response carrying a record for one of names.
+func claims(raw []byte, names ...string) bool {
+	var p dnsmessage.Parser
+	h, err := p.Start(raw)
+	if err != nil || !h.Response {
+		return false
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return false
+	}
+	var records []dnsmessage.Resource
+	answers, _ := p.AllAnswers()
+	records = append(records, answers...)
+	authorities, _ := p.AllAuthorities()
+	records = append(records, authorities...)
+	additionals, _ := p.AllAdditionals()
+	records = append(records, additionals...)
+	for _, rr := range records {
+		for _, n := range names {
+			if sameName(rr.Header.Name, n) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Interfaces returns the interfaces the group is joined on.
+func (r *Responder) Interfaces() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]string, 0, len(r.joined))
+	for name := range r.joined {
+		out = append(out, name)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// Instance returns the announced instance name ("<instance>._contrabass._tcp.local.").
+func (r *Responder) Instance() string {
+	return r.svc.instanceName()
+}
+
+func (r *Responder) names() []string {
+	if r.ifaces == nil {
+		return nil
+	}
+	return r.ifaces()
+}
+
+// join joins the group on interfaces not joined yet and forgets the ones that went away.
+func (r *Responder) join() {
+	current := links(r.names())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var fresh []string
+	for _, l := range current {
+		if !r.joined[l.name] {
+			fresh = append(fresh, l.name)
+		}
+	}
+	for name := range r.joined {
+		if !slices.ContainsFunc(current, func(l link) bool { return l.name == name }) {
+			delete(r.joined, name)
+		}
+	}
+	for _, name := range discovery.JoinIPv4Group(r.conn, groupIPv4, fresh) {
+		r.joined[name] = true
+	}
+}
+
+// isJoined reports whether the group was joined on the named interface.
+func (r *Responder) isJoined(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.joined[name]
+}
+
+// Run answers queries until the socket is closed.
+func (r *Responder) Run() {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		r.handleQuery(buf[:n], from)
+	}
+}
+
+func (r *Responder) handleQuery(raw []byte, from *net.UDPAddr) {
+	var p dnsmessage.Parser
+	h, err := p.Start(raw)
+	if err != nil || h.Response || h.OpCode != 0 {
+		return
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return
+	}
+	// Known answers (RFC 6762 §7.1): a browser that already holds our PTR with more than half its TTL left gets no PTR.
+	known, _ := p.AllAnswers()
+	inst, host := r.svc.instanceName(), r.svc.hostName()
+	legacy := from.Port != Port
+	unicast := legacy
+	var answers, extras recordSet
+	for _, q := range questions {
+		if q.Class&classCacheFlush != 0 {
+			unicast = true
+		}
+		ptrOrAny := q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL
+		switch {
+		case sameName(q.Name, servicesName) && ptrOrAny:
+			answers |= recServices
+		case sameName(q.Name, serviceName) && ptrOrAny:
+			if !knownPTR(known, inst) {
+				answers |= recPTR
+				extras |= recSRV | recTXT | recA
+			}
+		case sameName(q.Name, inst):
+			if q.Type == dnsmessage.TypeSRV || q.Type == dnsmessage.TypeALL {
+				answers |= recSRV
+				extras |= recA
+			}
+			if q.Type == dnsmessage.TypeTXT || q.Type == dnsmessage.TypeALL {
+				answers |= recTXT
+			}
+		case sameName(q.Name, host) && (q.Type == dnsmessage.TypeA || q.Type == dnsmessage.TypeALL):
+			answers |= recA
+		}
+	}
+	if answers == 0 {
+		return
+	}
+	// Only queries from a joined link are answered, legacy (unicast) ones included (RFC 6762 §11): an off-link source
+	// is either routed in or spoofed, and a unicast answer to it would hand out our addresses or reflect traffic.
+	l := linkFor(links(r.names()), from.IP)
+	if l == nil || !r.isJoined(l.name) {
+		return
+	}
+	// Answer with the addresses of the link the query came from (RFC 6762 §6.2).
+	addrs := l.ips()
+	mode, id, echo := modeMulticast, uint16(0), []dnsmessage.Question(nil)
+	if legacy {
+		mode, id, echo = modeLegacy, h.ID, questions
+	}
+	msg, err := r.svc.message(id, echo, answers, extras, addrs, mode)
+	if err != nil {
+		log.Printf("mdns: build answer for %s: %v", from, err)
+		return
+	}
+	if unicast {
+		_, err = r.conn.WriteToUDP(msg, from)
+	} else {
+		err = r.sendGroup(l, msg)
+	}
+	if err != nil {
+		log.Printf("mdns: answer to %s: %v", from, err)
+	}
+}
+
+// knownPTR reports whether known holds our PTR with at least half of serviceTTL left.
+func knownPTR(known []dnsmessage.Resource, inst string) bool {
+	for _, k := range known {
+		if ptr, ok := k.Body.(*dnsmessage.PTRResource); ok && sameName(k.Header.Name, serviceName) &&
+			sameName(ptr.PTR, inst) && k.Header.TTL >= serviceTTL/2 {
+			return true
+		}
+	}
+	return false
+}
+
+// sendGroup multicasts msg on l.
+func (r *Responder) sendGroup(l *link, msg []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := setMulticastInterface(r.conn, l.index); err != nil {
+		return err
+	}
+	_, err := r.conn.WriteToUDP(msg, &net.UDPAddr{IP: groupIPv4, Port: Port})
+	return err
+}
+
+// announce multicasts the full record set (or its goodbye) on every joined interface.
+func (r *Responder) announce(mode answerMode) {
+	set := recPTR | recSRV | recTXT | recA
+	joined := r.Interfaces()
+	ls := links(joined)
+	for i := range ls {
+		msg, err := r.svc.message(0, nil, set, 0, ls[i].ips(), mode)
+		if err == nil {
+			err = r.sendGroup(&ls[i], msg)
+		}
+		if err != nil {
+			log.Printf("mdns: announce on %s: %v", ls[i].name, err)
+		}
+	}
+}
+
+// Announce sends the records unsolicited twice, one second apart (RFC 6762 §8.3). It blocks for that second.
+func (r *Responder) Announce() {
+	r.announce(modeMulticast)
+	time.Sleep(time.Second)
+	r.announce(modeMulticast)
+}
+
+// Refresh follows an address or link change: joins the group on new interfaces and announces the new addresses.
+func (r *Responder) Refresh() {
+	r.join()
+	r.Announce()
+}
+
+// Close sends a goodbye (TTL 0) so browsers drop the service at once, and closes the socket.
+func (r *Responder) Close() error {
+	r.announce(modeGoodbye)
+	return r.conn.Close()
+}
+
+// message builds one response. Multicast responses carry no questions (RFC 6762 §6); legacy ones echo them with the query ID.
+func (s Service) message(id uint16, questions []dnsmessage.Question, answers, extras recordSet, addrs []net.IP, mode answerMode) ([]byte, error) {
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{ID: id, Response: true, Authoritative: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, q := range questions {
+		if err := b.Question(q); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if err := s.appendRecords(&b, answers, addrs, mode); err != nil {
+		return nil, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	if err := s.appendRecords(&b, extras&^answers, addrs, mode); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+func (s Service) appendRecords(b *dnsmessage.Builder, set recordSet, addrs []net.IP, mode answerMode) error {
+	hdr := func(name string, ttl uint32, unique bool) dnsmessage.ResourceHeader {
+		switch mode {
+		case modeGoodbye:
+			ttl = 0
+		case modeLegacy:
+			ttl, unique = min(ttl, legacyTTL), false
+		}
+		class := dnsmessage.ClassINET
+		if unique {
+			class |= classCacheFlush
+		}
+		return dnsmessage.ResourceHeader{Name: mustName(name), Class: class, TTL: ttl}
+	}
+	inst, host := s.instanceName(), s.hostName()
+	if set&recServices != 0 {
+		if err := b.PTRResource(hdr(servicesName, serviceTTL, false), dnsmessage.PTRResource{PTR: mustName(serviceName)}); err != nil {
+			return err
+		}
+	}
+	if set&recPTR != 0 {
+		if err := b.PTRResource(hdr(serviceName, serviceTTL, false), dnsmessage.PTRResource{PTR: mustName(inst)}); err != nil {
+			return err
+		}
+	}
+	if set&recSRV != 0 {
+		srv := dnsmessage.SRVResource{Port: uint16(s.HTTPPort), Target: mustName(host)}
+		if err := b.SRVResource(hdr(inst, hostTTL, true), srv); err != nil {
+			return err
+		}
+	}
+	if set&recTXT != 0 {
+		if err := b.TXTResource(hdr(inst, serviceTTL, true), dnsmessage.TXTResource{TXT: s.txt()}); err != nil {
+			return err
+		}
+	}
+	if set&recA != 0 {
+		for _, ip := range addrs {
+			var a dnsmessage.AResource
+			copy(a.A[:], ip.To4())
+			if err := b.AResource(hdr(host, hostTTL, true), a); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -8,26 +8,77 @@ type DiscoveryRequest struct {
 	// ReplyUDPPort is the UDP port the responder must send DISCOVERY_RESPONSE to (requester's listen port).
 	// When set (>0), it overrides the packet's source port so discovery works even if from.Port is wrong or 0.
 	ReplyUDPPort int `json:"reply_udp_port,omitempty"`
+	// ProtoVersion and Capabilities describe the sender (see capabilities.go). Absent on agents older than ProtoVersion 2.
+	ProtoVersion int      `json:"proto_version,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Filter, when set, limits which agents answer (see filter.go). Counts toward MaxDiscoveryRequestPayloadBytes.
+	Filter *DiscoveryFilter `json:"filter,omitempty"`
+	// HopsLeft, TimeoutMS and RelayPath are set only on RelayRequestType (see relay.go): how many more relays may forward it,
+	// how long the relay should collect answers, and the hostnames of relays it already passed through.
+	HopsLeft  int      `json:"hops_left,omitempty"`
+	TimeoutMS int      `json:"timeout_ms,omitempty"`
+	RelayPath []string `json:"relay_path,omitempty"`
+	// Timestamp (unix seconds), Nonce and Signature are set only in signed mode (Maintenance.DiscoveryAuth; see auth.go).
+	Timestamp int64  `json:"ts,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	Signature string `json:"sig,omitempty"`
 }
 
 // DiscoveryResponse is sent unicast to requester IP:reply port (UDP source port or reply_udp_port from request).
 type DiscoveryResponse struct {
-	Type               string   `json:"type"` // "DISCOVERY_RESPONSE"
-	Service            string   `json:"service"`
-	HostIP             string   `json:"host_ip"`
-	HostIPs            []string `json:"host_ips,omitempty"` // HTTP /self 등에서만 채움; UDP Discovery 응답에는 넣지 않음(접속 가능 IP는 responded_from_ip)
-	Hostname           string   `json:"hostname"`
-	ServicePort        int      `json:"service_port"`
-	Version            string   `json:"version"`
-	RequestID          string   `json:"request_id"`
-	CPUInfo            string   `json:"cpu_info"`
-	CPUUsagePercent    float64  `json:"cpu_usage_percent"`
-	CPUUUID            string   `json:"cpu_uuid"`
-	MemoryTotalMB      uint64   `json:"memory_total_mb"`
-	MemoryUsedMB       uint64   `json:"memory_used_mb"`
-	MemoryUsagePercent float64  `json:"memory_usage_percent"`
-	// RespondedFromIP is set by the receiver: UDP source IP of the packet (the IP that actually sent this response). Not sent over the wire.
+	Type               string  `json:"type"` // "DISCOVERY_RESPONSE"
+	Service            string  `json:"service"`
+	HostIP             string  `json:"host_ip"`
+	Hostname           string  `json:"hostname"`
+	ServicePort        int     `json:"service_port"`
+	Version            string  `json:"version"`
+	RequestID          string  `json:"request_id"`
+	CPUInfo            string  `json:"cpu_info"`
+	CPUUsagePercent    float64 `json:"cpu_usage_percent"`
+	CPUUUID            string  `json:"cpu_uuid"`
+	MemoryTotalMB      uint64  `json:"memory_total_mb"`
+	MemoryUsedMB       uint64  `json:"memory_used_mb"`
+	MemoryUsagePercent float64 `json:"memory_usage_percent"`
+	// MAC is the hardware address of the responder's primary interface (the one holding its hostinfo IP); empty when unknown or a legacy agent.
+	// Used with CPUUUID to tell cloned hosts apart (see ConflictDetector).
+	MAC string `json:"mac,omitempty"`
+	// Labels are the responder's Maintenance.Labels.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Summary is the compact inventory (load, uptime, OS, cores, swap, fullest disk); left out when it would exceed
+	// MaxDiscoveryResponsePayloadBytes, and absent from legacy agents.
+	Summary *HostSummary `json:"summary,omitempty"`
+	// ProtoVersion and Capabilities: same as DiscoveryRequest (0 / empty means a legacy agent).
+	ProtoVersion int      `json:"proto_version,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Timestamp, Nonce and Signature: same as DiscoveryRequest (signed mode only).
+	Timestamp int64  `json:"ts,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	Signature string `json:"sig,omitempty"`
+	// RespondedFromIP is set by the receiver: UDP source IP of the packet (the IP that actually sent this response).
+	// Sent over the wire only by relays, which keep the address they heard the host on.
 	RespondedFromIP string `json:"responded_from_ip,omitempty"`
+	// RelayPath lists the relays (hostnames, nearest to the requester first) a relayed response came through; empty for direct answers.
+	RelayPath []string `json:"relay_path,omitempty"`
 	// IsSelf is set when the response is from this host (CPU UUID match). Stream receiver uses it to update the self card's "응답한 IP" only.
 	IsSelf bool `json:"self,omitempty"`
+	// Source is SourceMDNS for hosts found by Config.Browser (no usage figures); empty for UDP answers.
+	Source string `json:"source,omitempty"`
+}
+
+// PresenceMessage is sent by agents on startup (DISCOVERY_HELLO), every heartbeat interval (DISCOVERY_HEARTBEAT)
+// and on shutdown (DISCOVERY_BYE), to the same destinations as DISCOVERY_REQUEST. No reply is sent.
+type PresenceMessage struct {
+	Type        string `json:"type"`
+	Service     string `json:"service"`
+	Hostname    string `json:"hostname"`
+	HostIP      string `json:"host_ip"`
+	ServicePort int    `json:"service_port"`
+	Version     string `json:"version"`
+	CPUUUID     string `json:"cpu_uuid"`
+	// HeartbeatSeconds is the sender's interval; receivers expire the peer after several missed heartbeats.
+	HeartbeatSeconds int `json:"heartbeat_seconds,omitempty"`
+	// Timestamp, Nonce and Signature: same as DiscoveryRequest (signed mode only).
+	Timestamp int64  `json:"ts,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	Signature string `json:"sig,omitempty"`
 }
@@ -0,0 +1,79 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// Discovery modes (Maintenance.DiscoveryMode). Empty Config.Mode means ModeBroadcast.
+const (
+	ModeBroadcast = "broadcast"
+	ModeMulticast = "multicast"
+	ModeBoth      = "both"
+)
+
+// DefaultMulticastGroup is the default IPv4 group for multicast mode (organization-local scope, 239.255.0.0/16).
+const DefaultMulticastGroup = "239.255.77.79"
+
+// ParseMulticastGroup parses an IPv4 multicast group. Empty s means DefaultMulticastGroup.
+func ParseMulticastGroup(s string) (net.IP, error) {
+	if s == "" {
+		s = DefaultMulticastGroup
+	}
+	ip := net.ParseIP(s).To4()
+	if ip == nil || !ip.IsMulticast() {
+		return nil, fmt.Errorf("discovery: %q is not an IPv4 multicast group", s)
+	}
+	return ip, nil
+}
+
+// JoinIPv4Group joins group on conn for each named interface (IP_ADD_MEMBERSHIP by interface).
+// conn should be bound to 0.0.0.0:port; sockets bound to a unicast IP do not receive group traffic.
+// Returns the interfaces actually joined; failures are logged.
+func JoinIPv4Group(conn *net.UDPConn, group net.IP, ifaces []string) []string {
+	pc := ipv4.NewPacketConn(conn)
+	var joined []string
+	for _, name := range ifaces {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			log.Printf("discovery: multicast join %s on %s: %v", group, name, err)
+			continue
+		}
+		if err := pc.JoinGroup(ifi, &net.UDPAddr{IP: group}); err != nil {
+			log.Printf("discovery: multicast join %s on %s: %v", group, name, err)
+			continue
+		}
+		joined = append(joined, name)
+	}
+	return joined
+}
+
+// SetMulticastTTL sets IP_MULTICAST_TTL on conn (1 = stay on the local segment; raise it to cross multicast routers).
+func SetMulticastTTL(conn *net.UDPConn, ttl int) error {
+	return ipv4.NewPacketConn(conn).SetMulticastTTL(ttl)
+}
+
+func (d *Discovery) sendsBroadcast() bool {
+	return d.cfg.Mode == "" || d.cfg.Mode == ModeBroadcast || d.cfg.Mode == ModeBoth
+}
+
+func (d *Discovery) sendsMulticast() bool {
+	return d.cfg.MulticastGroup != nil && (d.cfg.Mode == ModeMulticast || d.cfg.Mode == ModeBoth)
+}
+
+// boundUnicastIPs returns the IPv4 addresses of per-IP conns (conns bound to 0.0.0.0 or IPv6 are skipped).
+// Sending to a group from a socket bound to an interface address makes the kernel use that interface.
+func (d *Discovery) boundUnicastIPs() []net.IP {
+	var out []net.IP
+	for _, c := range d.connList() {
+		la, ok := c.LocalAddr().(*net.UDPAddr)
+		if !ok || la == nil || la.IP.To4() == nil || la.IP.IsUnspecified() {
+			continue
+		}
+		out = append(out, la.IP.To4())
+	}
+	return out
+}
@@ -0,0 +1,236 @@
+package discovery
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Presence message types. Sent to the same destinations as DISCOVERY_REQUEST (broadcast / multicast / IPv6 group).
+const (
+	PresenceHello     = "DISCOVERY_HELLO"
+	PresenceHeartbeat = "DISCOVERY_HEARTBEAT"
+	PresenceBye       = "DISCOVERY_BYE"
+)
+
+// DefaultHeartbeatInterval is used when RunPresence is given a non-positive interval
+// and for expiry of peers whose messages carry no heartbeat_seconds.
+const DefaultHeartbeatInterval = 30 * time.Second
+
+// peerExpiryHeartbeats: a peer is "expired" after this many missed heartbeats (its own announced interval).
+const peerExpiryHeartbeats = 3
+
+// peerRetention: expired or departed peers stay in the table this long after last_seen so dashboards can show them as gone.
+const peerRetention = time.Hour
+
+// maxPeers caps the peer table: when a new peer arrives in a full table, the one seen longest ago is evicted
+// (unsigned HELLOs with made-up identities must not grow it without bound).
+const maxPeers = 1024
+
+// Peer status values (Peer.Status).
+const (
+	PeerAlive   = "alive"
+	PeerExpired = "expired"
+	PeerLeft    = "left"
+)
+
+// Peer is one remote agent learned from presence announcements.
+type Peer struct {
+	Hostname    string `json:"hostname"`
+	HostIP      string `json:"host_ip"`
+	ServicePort int    `json:"service_port"`
+	Version     string `json:"version"`
+	CPUUUID     string `json:"cpu_uuid"`
+	// RespondedFromIPs are the UDP source addresses announcements arrived from (one per reachable interface).
+	RespondedFromIPs []string  `json:"responded_from_ips"`
+	FirstSeen        time.Time `json:"first_seen"`
+	LastSeen         time.Time `json:"last_seen"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	Status           string    `json:"status"` // alive | expired | left (computed when listed)
+
+	left bool
+}
+
+// Peers returns the peer table (excluding this host), sorted by hostname. Entries not seen for an hour are dropped;
+// the table holds at most maxPeers entries.
+func (d *Discovery) Peers() []Peer {
+	now := time.Now()
+	d.peerMu.Lock()
+	defer d.peerMu.Unlock()
+	d.prunePeersLocked(now)
+	out := make([]Peer, 0, len(d.peers))
+	for _, p := range d.peers {
+		c := *p
+		c.RespondedFromIPs = append([]string(nil), p.RespondedFromIPs...)
+		switch {
+		case p.left:
+			c.Status = PeerLeft
+		case now.After(p.ExpiresAt):
+			c.Status = PeerExpired
+		default:
+			c.Status = PeerAlive
+		}
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Hostname != out[j].Hostname {
+			return out[i].Hostname < out[j].Hostname
+		}
+		return out[i].CPUUUID < out[j].CPUUUID
+	})
+	return out
+}
+
+func (d *Discovery) handlePresence(raw []byte, from *net.UDPAddr) {
+	var msg PresenceMessage
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return
+	}
+	if msg.Service != d.cfg.DiscoveryServiceName {
+		return
+	}
+	if self := d.selfCPUUUID(); self != "" && strings.EqualFold(self, msg.CPUUUID) {
+		return
+	}
+	key := strings.ToLower(strings.TrimSpace(msg.CPUUUID))
+	src := respondedFrom(from)
+	if d.cfg.Observer != nil {
+		d.cfg.Observer.ObservePresence(msg, src)
+	}
+	if key == "" {
+		key = "noid:" + msg.Hostname + "@" + src
+	}
+	hb := time.Duration(msg.HeartbeatSeconds) * time.Second
+	if hb <= 0 {
+		hb = DefaultHeartbeatInterval
+	}
+	now := time.Now()
+	d.peerMu.Lock()
+	defer d.peerMu.Unlock()
+	p, ok := d.peers[key]
+	if !ok {
+		if len(d.peers) >= maxPeers {
+			d.prunePeersLocked(now)
+		}
+		if len(d.peers) >= maxPeers {
+			d.evictOldestPeerLocked()
+		}
+		p = &Peer{FirstSeen: now}
+		d.peers[key] = p
+		log.Printf("discovery: new peer %s (%s) from %s via %s", msg.Hostname, msg.CPUUUID, src, msg.Type)
+	}
+	p.Hostname, p.HostIP, p.ServicePort, p.Version, p.CPUUUID = msg.Hostname, msg.HostIP, msg.ServicePort, msg.Version, msg.CPUUUID
+	p.LastSeen = now
+	p.ExpiresAt = now.Add(peerExpiryHeartbeats * hb)
+	if !containsString(p.RespondedFromIPs, src) {
+		p.RespondedFromIPs = append(p.RespondedFromIPs, src)
+	}
+	p.left = msg.Type == PresenceBye
+	if p.left {
+		p.ExpiresAt = now
+		log.Printf("discovery: peer %s (%s) left (BYE from %s)", msg.Hostname, msg.CPUUUID, src)
+	}
+}
+
+// prunePeersLocked drops peers not seen for peerRetention. Caller holds peerMu.
+func (d *Discovery) prunePeersLocked(now time.Time) {
+	for key, p := range d.peers {
+		if now.Sub(p.LastSeen) > peerRetention {
+			delete(d.peers, key)
+		}
+	}
+}
+
+// evictOldestPeerLocked drops the peer with the oldest LastSeen. Caller holds peerMu.
+func (d *Discovery) evictOldestPeerLocked() {
+	var oldest string
+	var at time.Time
+	for key, p := range d.peers {
+		if oldest == "" || p.LastSeen.Before(at) {
+			oldest, at = key, p.LastSeen
+		}
+	}
+	if oldest != "" {
+		log.Printf("discovery: peer table full (%d), evicting %s (last seen %s)", maxPeers, oldest, at.Format(time.RFC3339))
+		delete(d.peers, oldest)
+	}
+}
+
+// selfCPUUUID returns this host's CPU UUID via the getter, computed once (the getter samples CPU usage and is slow).
+func (d *Discovery) selfCPUUUID() string {
+	d.selfOnce.Do(func() {
+		_, _, _, _, _, _, _, d.selfUUID = d.getter()
+	})
+	return d.selfUUID
+}
+
+// RunPresence sends DISCOVERY_HELLO now and DISCOVERY_HEARTBEAT every interval until stop is closed.
+// Call SayBye on shutdown (after closing stop, before closing the sockets).
+func (d *Discovery) RunPresence(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		interval = DefaultHeartbeatInterval
+	}
+	d.announce(PresenceHello, interval)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			d.announce(PresenceHeartbeat, interval)
+			// Retention is also enforced here, so the table shrinks even when /peers is never polled.
+			d.peerMu.Lock()
+			d.prunePeersLocked(time.Now())
+			d.peerMu.Unlock()
+		}
+	}
+}
+
+// SayBye sends DISCOVERY_BYE so peers mark this host as left without waiting for expiry.
+func (d *Discovery) SayBye() {
+	d.announce(PresenceBye, 0)
+}
+
+func (d *Discovery) announce(typ string, interval time.Duration) {
+	hostname, hostIP, _, _, _, _, _, cpuUUID := d.getter()
+	msg := PresenceMessage{
+		Type:             typ,
+		Service:          d.cfg.DiscoveryServiceName,
+		Hostname:         hostname,
+		HostIP:           hostIP,
+		ServicePort:      d.cfg.ServicePort,
+		Version:          d.cfg.Version,
+		CPUUUID:          cpuUUID,
+		HeartbeatSeconds: int(interval / time.Second),
+	}
+	data, err := d.cfg.Auth.Marshal(&msg)
+	if err != nil {
+		log.Printf("discovery: failed to marshal %s: %v", typ, err)
+		return
+	}
+	addrs, err := d.requestTargets()
+	if err != nil {
+		log.Printf("discovery: %s not sent: %v", typ, err)
+		return
+	}
+	for _, addr := range addrs {
+		_ = d.sendDiscoveryRequest(data, addr, d.sourceIPsFor(addr.IP))
+	}
+	d.sendIPv6(data, typ)
+	if typ != PresenceHeartbeat {
+		log.Printf("discovery: sent %s to %d destination(s)", typ, len(addrs)+len(d.cfg.IPv6Interfaces))
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Reply port policies (Limits.ReplyPortMode). Empty means ReplyPortAny.
+const (
+	ReplyPortAny    = "any"    // honour reply_udp_port as sent (previous behavior)
+	ReplyPortSource = "source" // ignore reply_udp_port; always answer the packet's source port
+	ReplyPortRange  = "range"  // drop requests whose reply port is outside ReplyPortMin..ReplyPortMax
+)
+
+// maxSourceBuckets bounds the per-source table; past this size the least recently used source is evicted.
+const maxSourceBuckets = 4096
+
+// dropLogInterval rate-limits the "dropped" log line so a flood does not also flood the journal.
+const dropLogInterval = 10 * time.Second
+
+// Limits protects the responder against reflection and request floods. The zero value disables every check.
+// The allowlist and reply port are checked first, before the request is logged or matched; the per-source bucket and
+// global budget are charged only once the request's filter matched.
+type Limits struct {
+	// PerSourceRate (tokens/second) and PerSourceBurst form one token bucket per requester IP; 0 rate = unlimited.
+	PerSourceRate  float64
+	PerSourceBurst int
+	// GlobalRate / GlobalBurst: one bucket shared by all requesters (total responses per second); 0 rate = unlimited.
+	GlobalRate  float64
+	GlobalBurst int
+	// ReplyPortMode is ReplyPortAny, ReplyPortSource or ReplyPortRange (with ReplyPortMin..ReplyPortMax).
+	ReplyPortMode string
+	ReplyPortMin  int
+	ReplyPortMax  int
+	// AllowedSources, when non-empty, is the requester allowlist; requests from other source IPs are dropped.
+	AllowedSources []netip.Prefix
+}
+
+// ResponderStats counts requests answered (response sent, or relaying started) and dropped by Limits (since start).
+type ResponderStats struct {
+	Answered            uint64 `json:"answered"`
+	DroppedNotAllowed   uint64 `json:"dropped_not_allowed"`
+	DroppedReplyPort    uint64 `json:"dropped_reply_port"`
+	DroppedSourceRate   uint64 `json:"dropped_source_rate"`
+	DroppedGlobalBudget uint64 `json:"dropped_global_budget"`
+}
+
+type responderCounters struct {
+	answered, notAllowed, replyPort, sourceRate, globalBudget atomic.Uint64
+	lastDropLog                                               atomic.Int64 // unix nanos
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// sourceBucket is one requester's bucket in the LRU list (Discovery.bucketLRU, most recently used first).
+type sourceBucket struct {
+	key string
+	tokenBucket
+}
+
+// take refills the bucket at rate up to burst and consumes one token if available.
+func (b *tokenBucket) take(now time.Time, rate float64, burst int) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// ParseAllowedSources parses requester allowlist entries: CIDRs ("10.0.0.0/8") or single IPs ("192.0.2.7").
+func ParseAllowedSources(entries []string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if strings.Contains(e, "/") {
+			p, err := netip.ParsePrefix(e)
+			if err != nil {
+				return nil, fmt.Errorf("discovery: allowed source %q: %v", e, err)
+			}
+			out = append(out, p.Masked())
+			continue
+		}
+		a, err := netip.ParseAddr(e)
+		if err != nil {
+			return nil, fmt.Errorf("discovery: allowed source %q: %v", e, err)
+		}
+		a = a.WithZone("").Unmap()
+		out = append(out, netip.PrefixFrom(a, a.BitLen()))
+	}
+	return out, nil
+}
+
+// sourceAddr is from's IP as a netip.Addr (IPv4-mapped addresses unmapped), the key of the allowlist and per-source buckets.
+func sourceAddr(from *net.UDPAddr) netip.Addr {
+	src, _ := netip.AddrFromSlice(from.IP)
+	return src.Unmap()
+}
+
+// screenRequest applies the cheap, stateless Limits (allowlist, reply port) to one incoming request (DISCOVERY_REQUEST
+// or RelayRequestType), before anything is logged or looked up for it. Returns the port to answer on and whether to
+// go on; drops are counted.
+func (d *Discovery) screenRequest(from *net.UDPAddr, replyPort int) (int, bool) {
+	lim := &d.cfg.Limits
+	src := sourceAddr(from)
+	if len(lim.AllowedSources) > 0 {
+		allowed := false
+		for _, p := range lim.AllowedSources {
+			if p.Contains(src) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			d.dropped(&d.counters.notAllowed, from, "source not in allowlist")
+			return 0, false
+		}
+	}
+	switch lim.ReplyPortMode {
+	case ReplyPortSource:
+		replyPort = from.Port
+	case ReplyPortRange:
+		if replyPort < lim.ReplyPortMin || replyPort > lim.ReplyPortMax {
+			d.dropped(&d.counters.replyPort, from, fmt.Sprintf("reply port %d outside %d-%d", replyPort, lim.ReplyPortMin, lim.ReplyPortMax))
+			return 0, false
+		}
+	}
+	return replyPort, true
+}
+
+// takeToken charges one request that is going to be answered to the per-source bucket and the global budget.
+// Returns false (counted) when either is empty.
+func (d *Discovery) takeToken(from *net.UDPAddr) bool {
+	lim := &d.cfg.Limits
+	now := time.Now()
+	d.limitMu.Lock()
+	defer d.limitMu.Unlock()
+	if lim.PerSourceRate > 0 {
+		b := d.sourceBucket(sourceAddr(from).String())
+		if !b.take(now, lim.PerSourceRate, lim.PerSourceBurst) {
+			d.dropped(&d.counters.sourceRate, from, "per-source rate limit")
+			return false
+		}
+	}
+	if lim.GlobalRate > 0 && !d.global.take(now, lim.GlobalRate, lim.GlobalBurst) {
+		d.dropped(&d.counters.globalBudget, from, "global response budget")
+		return false
+	}
+	return true
+}
+
+// sourceBucket returns the bucket of key, marked most recently used. A new source in a full table evicts the least
+// recently used one, so a spoofed-source flood recycles its own entries instead of locking new sources out. Caller holds limitMu.
+func (d *Discovery) sourceBucket(key string) *sourceBucket {
+	if e := d.buckets[key]; e != nil {
+		d.bucketLRU.MoveToFront(e)
+		return e.Value.(*sourceBucket)
+	}
+	if d.bucketLRU.Len() >= maxSourceBuckets {
+		oldest := d.bucketLRU.Back()
+		d.bucketLRU.Remove(oldest)
+		delete(d.buckets, oldest.Value.(*sourceBucket).key)
+	}
+	b := &sourceBucket{key: key}
+	d.buckets[key] = d.bucketLRU.PushFront(b)
+	return b
+}
+
+// answered counts one request answered (after the response was written, or relaying started).
+func (d *Discovery) answered(count func(func(*DiscoveryCounters))) {
+	count(func(c *DiscoveryCounters) { c.RequestsAnswered++ })
+	d.counters.answered.Add(1)
+}
+
+func (d *Discovery) dropped(counter *atomic.Uint64, from *net.UDPAddr, why string) {
+	n := counter.Add(1)
+	now := time.Now().UnixNano()
+	last := d.counters.lastDropLog.Load()
+	if now-last >= int64(dropLogInterval) && d.counters.lastDropLog.CompareAndSwap(last, now) {
+		log.Printf("discovery: dropped request from %s: %s (%d dropped for this reason so far)", from, why, n)
+	}
+}
+
+// ResponderStats returns the responder's answered and dropped request counters.
+func (d *Discovery) ResponderStats() ResponderStats {
+	c := &d.counters
+	return ResponderStats{
+		Answered:            c.answered.Load(),
+		DroppedNotAllowed:   c.notAllowed.Load(),
+		DroppedReplyPort:    c.replyPort.Load(),
+		DroppedSourceRate:   c.sourceRate.Load(),
+		DroppedGlobalBudget: c.globalBudget.Load(),
+	}
+}
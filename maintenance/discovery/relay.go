@@ -0,0 +1,261 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RelayRequestType is a DISCOVERY_REQUEST forwarded by unicast to a relay agent in another subnet.
+// The relay re-broadcasts it there (same request_id) and sends every DISCOVERY_RESPONSE it collects back
+// to the sender, with its hostname prepended to relay_path. Agents without relay support ignore the type.
+const RelayRequestType = "DISCOVERY_RELAY_REQUEST"
+
+// DefaultRelayMaxHops is used when Config.RelayMaxHops is not positive: the originator's relays may forward once more.
+const DefaultRelayMaxHops = 2
+
+// relaySeenTTL: request IDs relayed (or originated) here are remembered this long; a relay request seen twice is dropped.
+const relaySeenTTL = 2 * time.Minute
+
+// maxRelayedResponses caps the responses a relay sends back for one relayed request (one admitted request must not fan out unbounded).
+const maxRelayedResponses = 256
+
+// relayHopMargin is taken off the remaining time at every hop so answers reach the originator before its run ends.
+const relayHopMargin = 500 * time.Millisecond
+
+// ParseRelayPeers parses relay peer addresses ("10.1.0.5", "10.1.0.5:9999", "[fd00::5]:9999"). defPort is used when no port is given.
+func ParseRelayPeers(peers []string, defPort int) ([]*net.UDPAddr, error) {
+	var out []*net.UDPAddr
+	for _, p := range peers {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		host, port := p, defPort
+		if h, ps, err := net.SplitHostPort(p); err == nil {
+			n, err := strconv.Atoi(ps)
+			if err != nil || n <= 0 || n > 65535 {
+				return nil, fmt.Errorf("discovery: relay peer %q: invalid port", p)
+			}
+			host, port = h, n
+		}
+		ip := net.ParseIP(strings.SplitN(host, "%", 2)[0])
+		if ip == nil {
+			return nil, fmt.Errorf("discovery: relay peer %q is not an IP address", p)
+		}
+		addr := &net.UDPAddr{IP: ip, Port: port}
+		if v4 := ip.To4(); v4 != nil {
+			addr.IP = v4
+		} else if i := strings.IndexByte(host, '%'); i >= 0 {
+			addr.Zone = host[i+1:]
+		}
+		out = append(out, addr)
+	}
+	return out, nil
+}
+
+// markRelaySeen records requestID and reports whether it was new. Old entries are pruned on the way.
+func (d *Discovery) markRelaySeen(requestID string) bool {
+	now := time.Now()
+	d.relayMu.Lock()
+	defer d.relayMu.Unlock()
+	for id, t := range d.relaySeen {
+		if now.Sub(t) > relaySeenTTL {
+			delete(d.relaySeen, id)
+		}
+	}
+	if _, ok := d.relaySeen[requestID]; ok {
+		return false
+	}
+	d.relaySeen[requestID] = now
+	return true
+}
+
+// isRelayPeer reports whether ip is one of Config.RelayPeers.
+func (d *Discovery) isRelayPeer(ip net.IP) bool {
+	for _, p := range d.cfg.RelayPeers {
+		if p.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// relayOut forwards an originated request to the configured relay peers. Returns the number of peers sent to.
+func (d *Discovery) relayOut(req DiscoveryRequest, timeout time.Duration) int {
+	if len(d.cfg.RelayPeers) == 0 {
+		return 0
+	}
+	d.markRelaySeen(req.RequestID)
+	hops := d.cfg.RelayMaxHops
+	if hops <= 0 {
+		hops = DefaultRelayMaxHops
+	}
+	return d.sendRelayRequests(req, hops, timeout-relayHopMargin, nil, nil)
+}
+
+// sendRelayRequests sends req as RelayRequestType to every relay peer except skip (the peer it came from).
+func (d *Discovery) sendRelayRequests(req DiscoveryRequest, hopsLeft int, timeout time.Duration, path []string, skip net.IP) int {
+	if timeout < time.Second {
+		return 0
+	}
+	sent := 0
+	for _, peer := range d.cfg.RelayPeers {
+		if skip != nil && peer.IP.Equal(skip) {
+			continue
+		}
+		conn := connForFamily(d.connList(), peer.IP)
+		if conn == nil {
+			log.Printf("discovery: relay to %s skipped: no UDP socket for that address family", peer)
+			continue
+		}
+		out := req
+		out.Type = RelayRequestType
+		out.HopsLeft = hopsLeft
+		out.TimeoutMS = int(timeout / time.Millisecond)
+		out.RelayPath = path
+		if la, ok := conn.LocalAddr().(*net.UDPAddr); ok && la != nil && la.Port > 0 {
+			out.ReplyUDPPort = la.Port
+		}
+		data, err := d.marshalRequest(&out)
+		if err != nil {
+			log.Printf("discovery: relay to %s: %v", peer, err)
+			continue
+		}
+		if _, err := conn.WriteToUDP(data, peer); err != nil {
+			log.Printf("discovery: relay to %s: %v", peer, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// handleRelayRequest re-broadcasts a relayed request on this host's segments (and to its own relay peers while hops remain),
+// then streams every response it receives for that request_id back to the sender until the relayed timeout.
+func (d *Discovery) handleRelayRequest(raw []byte, from *net.UDPAddr, recvOn string) {
+	count := func(f func(*DiscoveryCounters)) { d.stats.count(from, recvOn, f) }
+	var req DiscoveryRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.RequestID == "" {
+		count(func(c *DiscoveryCounters) { c.ParseFailures++ })
+		return
+	}
+	if req.Service != d.cfg.DiscoveryServiceName {
+		count(func(c *DiscoveryCounters) { c.ServiceMismatch++ })
+		return
+	}
+	if !d.cfg.RelayAccept {
+		log.Printf("discovery: dropped %s from %s (relay not enabled)", RelayRequestType, from)
+		return
+	}
+	// Unsigned, anyone could have the whole segment's answers streamed to a spoofed address: only configured relay peers may ask.
+	if d.cfg.Auth == nil && !d.isRelayPeer(from.IP) {
+		log.Printf("discovery: dropped %s from %s (not a relay peer and signing is off)", RelayRequestType, from)
+		return
+	}
+	replyPort := req.ReplyUDPPort
+	if replyPort <= 0 {
+		replyPort = from.Port
+	}
+	replyPort, ok := d.screenRequest(from, replyPort)
+	if !ok || !d.takeToken(from) {
+		return
+	}
+	if !d.markRelaySeen(req.RequestID) {
+		log.Printf("discovery: dropped %s requestID=%s from %s (already relayed)", RelayRequestType, req.RequestID, from)
+		return
+	}
+	timeout := time.Duration(req.TimeoutMS) * time.Millisecond
+	if max := d.discoveryTimeout(); timeout <= 0 || timeout > max {
+		timeout = max
+	}
+	if timeout < time.Second {
+		return
+	}
+	replyIP := from.IP
+	if v4 := replyIP.To4(); v4 != nil {
+		replyIP = v4
+	}
+	replyTo := &net.UDPAddr{IP: replyIP, Port: replyPort, Zone: from.Zone}
+	replyConn := connForFamily(d.connList(), replyTo.IP)
+	if replyConn == nil {
+		return
+	}
+	self, _ := os.Hostname()
+
+	ch := make(chan *DiscoveryResponse, 64)
+	d.mu.Lock()
+	if _, busy := d.pending[req.RequestID]; busy {
+		d.mu.Unlock()
+		return
+	}
+	d.pending[req.RequestID] = ch
+	d.mu.Unlock()
+
+	local := req
+	local.Type = "DISCOVERY_REQUEST"
+	local.ReplyUDPPort = d.cfg.DiscoveryUDPPort
+	local.HopsLeft, local.TimeoutMS, local.RelayPath = 0, 0, nil
+	data, err := d.marshalRequest(&local)
+	if err != nil {
+		log.Printf("discovery: relay requestID=%s: %v", req.RequestID, err)
+		d.mu.Lock()
+		delete(d.pending, req.RequestID)
+		d.mu.Unlock()
+		return
+	}
+	targets, err := d.requestTargets()
+	if err != nil {
+		log.Printf("discovery: relay requestID=%s: %v", req.RequestID, err)
+	}
+	for _, addr := range targets {
+		_ = d.sendDiscoveryRequest(data, addr, d.sourceIPsFor(addr.IP))
+	}
+	d.sendIPv6(data, "DISCOVERY_REQUEST")
+	onward := 0
+	if req.HopsLeft > 1 {
+		onward = d.sendRelayRequests(req, req.HopsLeft-1, timeout-relayHopMargin, append(append([]string(nil), req.RelayPath...), self), from.IP)
+	}
+	d.answered(count)
+	log.Printf("discovery: relaying requestID=%s for %s (path %v, %d local target(s), %d onward relay(s), %s)",
+		req.RequestID, from, req.RelayPath, len(targets), onward, timeout)
+
+	go func() {
+		defer func() {
+			d.mu.Lock()
+			delete(d.pending, req.RequestID)
+			d.mu.Unlock()
+		}()
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		forwarded := 0
+		for {
+			select {
+			case r := <-ch:
+				r.RelayPath = append([]string{self}, r.RelayPath...)
+				r.IsSelf = false
+				out, err := d.cfg.Auth.Marshal(r)
+				if err != nil {
+					continue
+				}
+				if _, err := replyConn.WriteToUDP(out, replyTo); err != nil {
+					log.Printf("discovery: relay response to %s: %v", replyTo, err)
+					continue
+				}
+				forwarded++
+				if forwarded >= maxRelayedResponses {
+					log.Printf("discovery: relay requestID=%s stopped at %d response(s) sent back to %s", req.RequestID, forwarded, replyTo)
+					return
+				}
+			case <-timer.C:
+				log.Printf("discovery: relay requestID=%s done, %d response(s) sent back to %s", req.RequestID, forwarded, replyTo)
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,535 @@
+// Package simnet is an in-memory LAN for running Discovery without real NICs: hosts with one or more interfaces,
+// subnets that are broadcast domains, routed unicast between subnets and seeded (deterministic) packet loss.
+//
+// A simulated agent is a discovery.NewWithConns over Host.DiscoveryConns, with Config.Network set to the Host:
+//
+//	lan := simnet.New(1)
+//	a, _ := lan.AddSegment("a", "10.0.1.0/24")
+//	h := lan.AddHost("db-1")
+//	_ = h.Attach("eth0", a, "10.0.1.5")
+//	conns, _ := h.DiscoveryConns(9999)
+//	d := discovery.NewWithConns(discovery.Config{Network: h, DiscoveryBroadcastAddresses: []string{a.Broadcast()}, ...}, conns, getter)
+//
+// Delivery follows Linux UDP rules closely enough for the discovery code paths: a socket bound to an interface address sends
+// out of that interface; broadcasts reach only sockets bound to the wildcard address (on every host of the segment, sender included);
+// unicast goes to the socket bound to the exact address, else the wildcard one; link-local IPv6 needs a zone and
+// arrives with the receiver's interface as zone. Sends never block: a full receive queue drops the packet, like a full socket buffer.
+package simnet
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sync"
+
+	"contrabass-agent/maintenance/discovery"
+)
+
+// queueLen is the receive queue of one Conn (packets).
+const queueLen = 256
+
+// firstEphemeralPort is where each host starts handing out ports for port-0 binds and unbound sends.
+const firstEphemeralPort = 32768
+
+// ErrClosed is returned by reads and writes on a closed Conn.
+var ErrClosed = errors.New("simnet: use of closed connection")
+
+// LAN is one simulated network. All methods are safe for concurrent use.
+type LAN struct {
+	mu       sync.Mutex
+	rng      *rand.Rand
+	segments map[string]*Segment
+	hosts    []*Host
+	routes   map[[2]string]bool
+
+	delivered, dropped uint64
+}
+
+// Segment is one subnet and broadcast domain.
+type Segment struct {
+	lan    *LAN
+	name   string
+	prefix netip.Prefix
+	loss   float64
+}
+
+// Host is a simulated machine. It implements discovery.Network.
+type Host struct {
+	lan    *LAN
+	id     int // position in LAN.hosts, part of the interfaces' MACs
+	name   string
+	ifaces []*hostIface
+	conns  []*Conn
+	port   int // next ephemeral port
+}
+
+type hostIface struct {
+	name  string
+	index int
+	mac   net.HardwareAddr
+	seg   *Segment
+	addrs []netip.Prefix
+}
+
+// Conn is a simulated UDP socket. It implements discovery.PacketConn.
+type Conn struct {
+	host   *Host
+	local  netip.AddrPort
+	queue  chan packet
+	done   chan struct{}
+	closed bool // guarded by host.lan.mu
+}
+
+type packet struct {
+	data []byte
+	from *net.UDPAddr
+}
+
+// New returns an empty LAN. seed drives packet loss, so the same seed and the same sends give the same drops.
+func New(seed int64) *LAN {
+	return &LAN{
+		rng:      rand.New(rand.NewSource(seed)),
+		segments: make(map[string]*Segment),
+		routes:   make(map[[2]string]bool),
+	}
+}
+
+// AddSegment adds a subnet ("10.0.1.0/24"; IPv6 link-local segments use "fe80::/64").
+func (l *LAN) AddSegment(name, cidr string) (*Segment, error) {
+	p, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("simnet: segment %q: %v", name, err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.segments[name]; ok {
+		return nil, fmt.Errorf("simnet: segment %q already exists", name)
+	}
+	s := &Segment{lan: l, name: name, prefix: p.Masked()}
+	l.segments[name] = s
+	return s, nil
+}
+
+// Route makes unicast routable between two segments (both directions). Broadcasts never cross segments.
+func (l *LAN) Route(a, b *Segment) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.routes[[2]string{a.name, b.name}] = true
+	l.routes[[2]string{b.name, a.name}] = true
+}
+
+// AddHost adds a host with no interfaces.
+func (l *LAN) AddHost(name string) *Host {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h := &Host{lan: l, id: len(l.hosts), name: name, port: firstEphemeralPort}
+	l.hosts = append(l.hosts, h)
+	return h
+}
+
+// Stats returns how many packets were queued to a socket and how many were lost (loss, no route, no listener, full queue).
+func (l *LAN) Stats() (delivered, dropped uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.delivered, l.dropped
+}
+
+// Name returns the segment name.
+func (s *Segment) Name() string { return s.name }
+
+// Prefix returns the segment's subnet.
+func (s *Segment) Prefix() netip.Prefix { return s.prefix }
+
+// Broadcast returns the directed broadcast address of an IPv4 segment ("" for IPv6).
+func (s *Segment) Broadcast() string {
+	if !s.prefix.Addr().Is4() {
+		return ""
+	}
+	return broadcastOf(s.prefix).String()
+}
+
+// SetLoss sets the probability (0..1) that a packet entering this segment is lost.
+func (s *Segment) SetLoss(p float64) {
+	s.lan.mu.Lock()
+	defer s.lan.mu.Unlock()
+	s.loss = p
+}
+
+// Name returns the host name.
+func (h *Host) Name() string { return h.name }
+
+// Attach gives the host an address on seg through interface ifname (created on first use; call again for secondary addresses).
+// addr is a bare IP and takes the segment's prefix length. Link-local IPv6 addresses (fe80::/64) fit any segment, like on a real link.
+func (h *Host) Attach(ifname string, seg *Segment, addr string) error {
+	a, err := netip.ParseAddr(addr)
+	if err != nil {
+		return fmt.Errorf("simnet: %s: %v", h.name, err)
+	}
+	bits := seg.prefix.Bits()
+	if a.Is6() && a.IsLinkLocalUnicast() {
+		bits = 64
+	} else if !seg.prefix.Contains(a) {
+		return fmt.Errorf("simnet: %s: %s is not in segment %s (%s)", h.name, a, seg.name, seg.prefix)
+	}
+	l := h.lan
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, other := range l.hosts {
+		if other.ownerIface(a) != nil {
+			return fmt.Errorf("simnet: %s: address %s already used by %s", h.name, a, other.name)
+		}
+	}
+	for _, ifc := range h.ifaces {
+		if ifc.name == ifname {
+			if ifc.seg != seg {
+				return fmt.Errorf("simnet: %s: interface %s is on segment %s", h.name, ifname, ifc.seg.name)
+			}
+			ifc.addrs = append(ifc.addrs, netip.PrefixFrom(a, bits))
+			return nil
+		}
+	}
+	index := len(h.ifaces) + 1
+	// Locally administered MAC from host and interface number: 02:00:<host>:<host>:00:<index>.
+	mac := net.HardwareAddr{0x02, 0, byte(h.id >> 8), byte(h.id), 0, byte(index)}
+	h.ifaces = append(h.ifaces, &hostIface{name: ifname, index: index, mac: mac, seg: seg, addrs: []netip.Prefix{netip.PrefixFrom(a, bits)}})
+	return nil
+}
+
+// ListenUDP binds a socket on the host. laddr.IP may be unspecified (0.0.0.0 / ::) or one of the host's addresses;
+// port 0 picks an ephemeral port. Several sockets may bind the same address and port (SO_REUSEPORT); the first one bound receives.
+func (h *Host) ListenUDP(laddr *net.UDPAddr) (*Conn, error) {
+	l := h.lan
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ip, ok := netip.AddrFromSlice(laddr.IP)
+	if !ok {
+		ip = netip.IPv4Unspecified()
+	}
+	ip = ip.Unmap()
+	if !ip.IsUnspecified() && h.ownerIface(ip) == nil {
+		return nil, fmt.Errorf("simnet: %s: cannot bind %s: address not on this host", h.name, ip)
+	}
+	port := laddr.Port
+	if port == 0 {
+		port = h.nextPort()
+	}
+	c := &Conn{host: h, local: netip.AddrPortFrom(ip, uint16(port)), queue: make(chan packet, queueLen), done: make(chan struct{})}
+	h.conns = append(h.conns, c)
+	return c, nil
+}
+
+// DiscoveryConns opens the socket layout the agent uses: 0.0.0.0:port first, then one socket per IPv4 address
+// (so requests and replies leave from each interface), then [::]:port when the host has IPv6 addresses.
+func (h *Host) DiscoveryConns(port int) ([]discovery.PacketConn, error) {
+	c0, err := h.ListenUDP(&net.UDPAddr{IP: net.IPv4zero, Port: port})
+	if err != nil {
+		return nil, err
+	}
+	conns := []discovery.PacketConn{c0}
+	has6 := false
+	for _, ifc := range h.interfaces() {
+		for _, p := range ifc.addrs {
+			if !p.Addr().Is4() {
+				has6 = true
+				continue
+			}
+			c, err := h.ListenUDP(&net.UDPAddr{IP: p.Addr().AsSlice(), Port: port})
+			if err != nil {
+				return nil, err
+			}
+			conns = append(conns, c)
+		}
+	}
+	if has6 {
+		c6, err := h.ListenUDP(&net.UDPAddr{IP: net.IPv6unspecified, Port: port})
+		if err != nil {
+			return nil, err
+		}
+		conns = append(conns, c6)
+	}
+	return conns, nil
+}
+
+// Interfaces implements discovery.Network.
+func (h *Host) Interfaces() ([]discovery.Interface, error) {
+	var out []discovery.Interface
+	for _, ifc := range h.interfaces() {
+		it := discovery.Interface{Name: ifc.name, Index: ifc.index, Flags: net.FlagUp | net.FlagBroadcast | net.FlagMulticast, HardwareAddr: ifc.mac}
+		for _, p := range ifc.addrs {
+			it.Addrs = append(it.Addrs, &net.IPNet{IP: p.Addr().AsSlice(), Mask: net.CIDRMask(p.Bits(), p.Addr().BitLen())})
+		}
+		out = append(out, it)
+	}
+	return out, nil
+}
+
+// OutboundIP implements discovery.Network: the address of the interface a wildcard socket would send to remote from.
+func (h *Host) OutboundIP(remote net.IP, port int) string {
+	dst, ok := netip.AddrFromSlice(remote)
+	if !ok {
+		return ""
+	}
+	h.lan.mu.Lock()
+	defer h.lan.mu.Unlock()
+	ifc, src := h.egress(netip.Addr{}, dst.Unmap(), "")
+	if ifc == nil {
+		return ""
+	}
+	return src.String()
+}
+
+// SendUnbound implements discovery.Network: one datagram from an ephemeral port.
+func (h *Host) SendUnbound(data []byte, to *net.UDPAddr) error {
+	h.lan.mu.Lock()
+	port := h.nextPort()
+	h.lan.mu.Unlock()
+	return h.lan.send(h, netip.AddrPortFrom(netip.IPv4Unspecified(), uint16(port)), data, to)
+}
+
+// interfaces returns a copy of the interface list.
+func (h *Host) interfaces() []hostIface {
+	h.lan.mu.Lock()
+	defer h.lan.mu.Unlock()
+	out := make([]hostIface, len(h.ifaces))
+	for i, ifc := range h.ifaces {
+		out[i] = *ifc
+		out[i].addrs = append([]netip.Prefix(nil), ifc.addrs...)
+	}
+	return out
+}
+
+// nextPort hands out an ephemeral port. Caller holds lan.mu.
+func (h *Host) nextPort() int {
+	p := h.port
+	h.port++
+	return p
+}
+
+// ownerIface returns the interface holding a, or nil. Caller holds lan.mu.
+func (h *Host) ownerIface(a netip.Addr) *hostIface {
+	for _, ifc := range h.ifaces {
+		for _, p := range ifc.addrs {
+			if p.Addr() == a {
+				return ifc
+			}
+		}
+	}
+	return nil
+}
+
+// egress picks the outgoing interface and source address for dst: the interface owning bound when the socket is bound
+// to an address; the named zone for link-local IPv6; the longest-prefix interface whose subnet (or broadcast) covers dst;
+// else the first interface of dst's family (default route). Caller holds lan.mu.
+func (h *Host) egress(bound, dst netip.Addr, zone string) (*hostIface, netip.Addr) {
+	family := func(p netip.Prefix) bool { return p.Addr().Is4() == dst.Is4() }
+	if bound.IsValid() && !bound.IsUnspecified() {
+		if ifc := h.ownerIface(bound); ifc != nil {
+			return ifc, bound
+		}
+		return nil, netip.Addr{}
+	}
+	if zone != "" {
+		for _, ifc := range h.ifaces {
+			if ifc.name != zone {
+				continue
+			}
+			for _, p := range ifc.addrs {
+				if family(p) {
+					return ifc, p.Addr()
+				}
+			}
+		}
+		return nil, netip.Addr{}
+	}
+	var best *hostIface
+	var bestSrc netip.Addr
+	bestBits := -1
+	for _, ifc := range h.ifaces {
+		for _, p := range ifc.addrs {
+			if !family(p) || p.Bits() <= bestBits {
+				continue
+			}
+			if p.Contains(dst) || (dst.Is4() && broadcastOf(p) == dst) {
+				best, bestSrc, bestBits = ifc, p.Addr(), p.Bits()
+			}
+		}
+	}
+	if best != nil {
+		return best, bestSrc
+	}
+	for _, ifc := range h.ifaces {
+		for _, p := range ifc.addrs {
+			if family(p) {
+				return ifc, p.Addr()
+			}
+		}
+	}
+	return nil, netip.Addr{}
+}
+
+// ReadFromUDP implements discovery.PacketConn. It blocks until a packet arrives or the Conn is closed.
+func (c *Conn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	select {
+	case p := <-c.queue:
+		return copy(b, p.data), p.from, nil
+	case <-c.done:
+		return 0, nil, ErrClosed
+	}
+}
+
+// WriteToUDP implements discovery.PacketConn. Lost or undeliverable packets are not errors (UDP semantics).
+func (c *Conn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	c.host.lan.mu.Lock()
+	closed := c.closed
+	c.host.lan.mu.Unlock()
+	if closed {
+		return 0, ErrClosed
+	}
+	if err := c.host.lan.send(c.host, c.local, b, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// LocalAddr implements discovery.PacketConn.
+func (c *Conn) LocalAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(c.local)
+}
+
+// Close implements discovery.PacketConn.
+func (c *Conn) Close() error {
+	l := c.host.lan
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	conns := c.host.conns[:0]
+	for _, o := range c.host.conns {
+		if o != c {
+			conns = append(conns, o)
+		}
+	}
+	c.host.conns = conns
+	return nil
+}
+
+// send routes one datagram from src (bound address and port of the sending socket) on host h.
+func (l *LAN) send(h *Host, src netip.AddrPort, data []byte, to *net.UDPAddr) error {
+	dst, ok := netip.AddrFromSlice(to.IP)
+	if !ok {
+		return fmt.Errorf("simnet: invalid destination %v", to)
+	}
+	dst = dst.Unmap()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if dst.Is4() != src.Addr().Is4() && !src.Addr().IsUnspecified() {
+		return fmt.Errorf("simnet: %s: cannot send from %s to %s (address family)", h.name, src.Addr(), dst)
+	}
+	if dst.Is6() && (dst.IsLinkLocalUnicast() || dst.IsMulticast()) && to.Zone == "" {
+		return fmt.Errorf("simnet: %s: %s needs a zone", h.name, dst)
+	}
+	ifc, srcIP := h.egress(src.Addr(), dst, to.Zone)
+	if ifc == nil {
+		l.dropped++
+		return fmt.Errorf("simnet: %s: no route to %s", h.name, dst)
+	}
+	from := netip.AddrPortFrom(srcIP, src.Port())
+	payload := append([]byte(nil), data...)
+	seg := ifc.seg
+	if dst.IsMulticast() || (dst.Is4() && (dst == netip.AddrFrom4([4]byte{255, 255, 255, 255}) || dst == broadcastOf(seg.prefix))) {
+		// Broadcast domain: every host on the segment, the sender included, on wildcard sockets of that port.
+		for _, rh := range l.hosts {
+			for _, rifc := range rh.ifaces {
+				if rifc.seg != seg {
+					continue
+				}
+				if l.lost(seg) {
+					continue
+				}
+				if c := rh.receiver(netip.Addr{}, dst.Is4(), int(to.Port)); c != nil {
+					l.enqueue(c, payload, from, rifc.name)
+				} else {
+					l.dropped++
+				}
+			}
+		}
+		return nil
+	}
+	for _, rh := range l.hosts {
+		rifc := rh.ownerIface(dst)
+		if rifc == nil {
+			continue
+		}
+		local := rh == h
+		if !local && rifc.seg != seg && !l.routes[[2]string{seg.name, rifc.seg.name}] {
+			l.dropped++
+			return nil
+		}
+		if !local && (l.lost(seg) || (rifc.seg != seg && l.lost(rifc.seg))) {
+			return nil
+		}
+		if c := rh.receiver(dst, dst.Is4(), int(to.Port)); c != nil {
+			l.enqueue(c, payload, from, rifc.name)
+		} else {
+			l.dropped++
+		}
+		return nil
+	}
+	l.dropped++
+	return nil
+}
+
+// receiver picks the socket for a packet to dst:port: exact bind first, then the wildcard of the family
+// (dst invalid = broadcast, which only wildcard sockets receive). Caller holds lan.mu.
+func (h *Host) receiver(dst netip.Addr, is4 bool, port int) *Conn {
+	if dst.IsValid() {
+		for _, c := range h.conns {
+			if c.local.Addr() == dst && int(c.local.Port()) == port {
+				return c
+			}
+		}
+	}
+	for _, c := range h.conns {
+		if c.local.Addr().IsUnspecified() && c.local.Addr().Is4() == is4 && int(c.local.Port()) == port {
+			return c
+		}
+	}
+	return nil
+}
+
+// lost rolls the segment's loss probability. Caller holds lan.mu.
+func (l *LAN) lost(s *Segment) bool {
+	if s.loss > 0 && l.rng.Float64() < s.loss {
+		l.dropped++
+		return true
+	}
+	return false
+}
+
+// enqueue queues a packet on c; link-local IPv6 senders get the receiving interface as zone. Caller holds lan.mu.
+func (l *LAN) enqueue(c *Conn, data []byte, from netip.AddrPort, rifc string) {
+	addr := net.UDPAddrFromAddrPort(from)
+	if from.Addr().Is6() && from.Addr().IsLinkLocalUnicast() {
+		addr.Zone = rifc
+	}
+	select {
+	case c.queue <- packet{data: data, from: addr}:
+		l.delivered++
+	default:
+		l.dropped++
+	}
+}
+
+// broadcastOf returns the directed broadcast address of an IPv4 prefix.
+func broadcastOf(p netip.Prefix) netip.Addr {
+	a := p.Masked().Addr().As4()
+	host := uint32(1)<<(32-p.Bits()) - 1
+	v := uint32(a[0])<<24 | uint32(a[1])<<16 | uint32(a[2])<<8 | uint32(a[3])
+	v |= host
+	return netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+}
@@ -0,0 +1,80 @@
+package discovery
+
+import (
+	"net"
+	"slices"
+)
+
+// received is one datagram (or a read error) from a conn's reader goroutine to the Run loop.
+type received struct {
+	data   []byte
+	from   *net.UDPAddr
+	recvOn string // which conn received (LocalAddr), for debugging SO_REUSEPORT delivery
+	err    error
+}
+
+// startReader reads conn into d.recv until it fails. A conn removed with RemoveConn ends quietly; any other error ends Run.
+// Caller holds connMu.
+func (d *Discovery) startReader(conn PacketConn) {
+	localAddr := conn.LocalAddr().String()
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				d.connMu.RLock()
+				removed := !slices.Contains(d.conns, conn)
+				d.connMu.RUnlock()
+				if !removed {
+					d.recv <- received{err: err}
+				}
+				return
+			}
+			d.recv <- received{data: append([]byte(nil), buf[:n]...), from: from, recvOn: localAddr}
+		}
+	}()
+}
+
+// connList returns a snapshot of the conns; conns[0] never changes.
+func (d *Discovery) connList() []PacketConn {
+	d.connMu.RLock()
+	defer d.connMu.RUnlock()
+	return append([]PacketConn(nil), d.conns...)
+}
+
+// AddConn adds a per-IP conn (e.g. for an address that appeared after start). It is read from immediately when Run is going.
+func (d *Discovery) AddConn(conn PacketConn) {
+	d.connMu.Lock()
+	defer d.connMu.Unlock()
+	d.conns = append(d.conns, conn)
+	if d.running {
+		d.startReader(conn)
+	}
+}
+
+// RemoveConn takes conn out of use and closes it (its address went away). conns[0] cannot be removed.
+func (d *Discovery) RemoveConn(conn PacketConn) {
+	d.connMu.Lock()
+	i := slices.Index(d.conns, conn)
+	if i > 0 {
+		d.conns = slices.Delete(d.conns, i, i+1)
+	}
+	d.connMu.Unlock()
+	if i > 0 {
+		_ = conn.Close()
+	}
+}
+
+// BroadcastAddresses returns the current Config.DiscoveryBroadcastAddresses.
+func (d *Discovery) BroadcastAddresses() []string {
+	d.connMu.RLock()
+	defer d.connMu.RUnlock()
+	return append([]string(nil), d.cfg.DiscoveryBroadcastAddresses...)
+}
+
+// SetBroadcastAddresses replaces Config.DiscoveryBroadcastAddresses for the next runs (interfaces came or went).
+func (d *Discovery) SetBroadcastAddresses(addrs []string) {
+	d.connMu.Lock()
+	defer d.connMu.Unlock()
+	d.cfg.DiscoveryBroadcastAddresses = append([]string(nil), addrs...)
+}
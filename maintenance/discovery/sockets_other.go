@@ -0,0 +1,8 @@
+//go:build !unix
+
+package discovery
+
+// SetReusePort is a no-op where SO_REUSEPORT does not exist; binding a second socket to the same port then fails.
+func SetReusePort(fd uintptr) error {
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build unix
+
+package discovery
+
+import "golang.org/x/sys/unix"
+
+// SetReusePort sets SO_REUSEPORT on fd so several sockets can bind the same UDP port
+// (the per-IP discovery sockets, [::]:port, and mDNS 5353 next to avahi).
+func SetReusePort(fd uintptr) error {
+	return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+}
@@ -0,0 +1,166 @@
+package discovery
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+)
+
+// MaxStaticPeerAddresses caps how many unicast probes one run may send (CIDRs expand to every host address).
+const MaxStaticPeerAddresses = 4096
+
+// DefaultStaticPeerConcurrency is used when Config.StaticPeerConcurrency is not positive.
+const DefaultStaticPeerConcurrency = 32
+
+// ExpandStaticPeers turns static peer entries into unicast destinations on port. An entry is an IP ("10.0.0.5", "fe80::1%eth0"),
+// a CIDR ("10.0.1.0/24"; network and broadcast addresses skipped for IPv4 prefixes shorter than /31) or an absolute path to a file
+// with one entry per line ("#" starts a comment). Files are read on every call so edits apply to the next run.
+// Duplicates are removed; more than MaxStaticPeerAddresses addresses is an error.
+func ExpandStaticPeers(entries []string, port int) ([]*net.UDPAddr, error) {
+	var out []*net.UDPAddr
+	seen := make(map[string]struct{})
+	add := func(ip net.IP, zone string) error {
+		key := ip.String() + "%" + zone
+		if _, ok := seen[key]; ok {
+			return nil
+		}
+		if len(out) >= MaxStaticPeerAddresses {
+			return fmt.Errorf("discovery: static peers expand to more than %d addresses", MaxStaticPeerAddresses)
+		}
+		seen[key] = struct{}{}
+		out = append(out, &net.UDPAddr{IP: ip, Port: port, Zone: zone})
+		return nil
+	}
+	var expand func(entry string, fromFile bool) error
+	expand = func(entry string, fromFile bool) error {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			return nil
+		}
+		if strings.HasPrefix(entry, "/") {
+			if fromFile {
+				return fmt.Errorf("discovery: static peer file entries cannot name other files (%q)", entry)
+			}
+			lines, err := readStaticPeerFile(entry)
+			if err != nil {
+				return err
+			}
+			for _, l := range lines {
+				if err := expand(l, true); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return fmt.Errorf("discovery: static peer %q: %v", entry, err)
+			}
+			prefix = prefix.Masked()
+			hostBits := prefix.Addr().BitLen() - prefix.Bits()
+			if hostBits > 12 {
+				return fmt.Errorf("discovery: static peer %q is too large (at most %d addresses per run)", entry, MaxStaticPeerAddresses)
+			}
+			n := 1 << hostBits
+			skipEnds := prefix.Addr().Is4() && hostBits >= 2
+			a := prefix.Addr()
+			for i := 0; i < n; i, a = i+1, a.Next() {
+				if skipEnds && (i == 0 || i == n-1) {
+					continue
+				}
+				if err := add(net.IP(a.AsSlice()), ""); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		host, zone := entry, ""
+		if i := strings.IndexByte(entry, '%'); i >= 0 {
+			host, zone = entry[:i], entry[i+1:]
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return fmt.Errorf("discovery: static peer %q is not an IP, CIDR or absolute file path", entry)
+		}
+		if v4 := ip.To4(); v4 != nil {
+			ip, zone = v4, ""
+		}
+		return add(ip, zone)
+	}
+	for _, e := range entries {
+		if err := expand(e, false); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func readStaticPeerFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: static peer file: %w", err)
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("discovery: static peer file %s: %w", path, err)
+	}
+	return lines, nil
+}
+
+// probeStaticPeers sends data by unicast to every static peer, at most StaticPeerConcurrency writes in flight.
+// Answers carry the run's request_id, so they arrive on the same pending channel as broadcast answers. Returns the number sent.
+func (d *Discovery) probeStaticPeers(data []byte) int {
+	if len(d.cfg.StaticPeers) == 0 {
+		return 0
+	}
+	peers, err := ExpandStaticPeers(d.cfg.StaticPeers, d.cfg.DiscoveryUDPPort)
+	if err != nil {
+		log.Printf("discovery: static peers not probed: %v", err)
+		return 0
+	}
+	workers := d.cfg.StaticPeerConcurrency
+	if workers <= 0 {
+		workers = DefaultStaticPeerConcurrency
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sent := 0
+	for _, peer := range peers {
+		conn := connForFamily(d.connList(), peer.IP)
+		if conn == nil {
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(conn PacketConn, peer *net.UDPAddr) {
+			defer func() { <-sem; wg.Done() }()
+			if _, err := conn.WriteToUDP(d.perSend(data), peer); err != nil {
+				log.Printf("discovery: static peer %s: %v", peer, err)
+				return
+			}
+			mu.Lock()
+			sent++
+			mu.Unlock()
+		}(conn, peer)
+	}
+	wg.Wait()
+	return sent
+}
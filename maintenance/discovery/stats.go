@@ -0,0 +1,156 @@
+package discovery
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// ifaceCacheTTL: the interface/address table used to attribute packets to an interface is re-read this often.
+const ifaceCacheTTL = 30 * time.Second
+
+// DiscoveryCounters counts discovery packets by outcome. The same set is kept in total, per local socket and per interface.
+type DiscoveryCounters struct {
+	RequestsReceived   uint64 `json:"requests_received"`    // DISCOVERY_REQUEST and DISCOVERY_RELAY_REQUEST
+	RequestsAnswered   uint64 `json:"requests_answered"`    // a DISCOVERY_RESPONSE was sent (or relaying started)
+	RequestsFiltered   uint64 `json:"requests_filtered"`    // request filter did not match this host (silent by design)
+	ResponsesReceived  uint64 `json:"responses_received"`   // DISCOVERY_RESPONSE
+	ResponsesDelivered uint64 `json:"responses_delivered"`  // handed to a running DoDiscovery / stream / unicast / relay
+	DroppedChannelFull uint64 `json:"dropped_channel_full"` // the run's pending channel was full
+	DroppedStale       uint64 `json:"dropped_stale"`        // no run waiting for that request_id (late, or unknown)
+	ServiceMismatch    uint64 `json:"service_mismatch"`     // service name differs from DiscoveryServiceName
+	ParseFailures      uint64 `json:"parse_failures"`       // not JSON, or not the expected message shape
+	AuthDropped        uint64 `json:"auth_dropped"`         // failed signed-mode checks (details in DiscoveryStats.Auth)
+	SendErrors         uint64 `json:"send_errors"`          // writing a DISCOVERY_RESPONSE failed
+}
+
+// DiscoveryStats is a snapshot of the counters since Since.
+// Sockets are keyed by the receiving socket's local address; Interfaces by the interface a packet is attributed to (see interfaceFor).
+type DiscoveryStats struct {
+	Since      time.Time                    `json:"since"`
+	Totals     DiscoveryCounters            `json:"totals"`
+	Sockets    map[string]DiscoveryCounters `json:"sockets"`
+	Interfaces map[string]DiscoveryCounters `json:"interfaces"`
+	Auth       AuthStats                    `json:"auth"`
+	Responder  ResponderStats               `json:"responder"`
+}
+
+type ifaceNet struct {
+	name  string
+	ipnet *net.IPNet
+}
+
+type statsRecorder struct {
+	network Network
+
+	mu      sync.Mutex
+	since   time.Time
+	totals  DiscoveryCounters
+	sockets map[string]*DiscoveryCounters
+	ifaces  map[string]*DiscoveryCounters
+
+	ifNets   []ifaceNet
+	ifNetsAt time.Time
+}
+
+func newStatsRecorder(n Network) *statsRecorder {
+	return &statsRecorder{
+		network: n,
+		since:   time.Now(),
+		sockets: make(map[string]*DiscoveryCounters),
+		ifaces:  make(map[string]*DiscoveryCounters),
+	}
+}
+
+// count applies f to the totals and to the counters of the receiving socket (recvOn) and of the interface from is attributed to.
+func (s *statsRecorder) count(from *net.UDPAddr, recvOn string, f func(*DiscoveryCounters)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(&s.totals)
+	if recvOn != "" {
+		c := s.sockets[recvOn]
+		if c == nil {
+			c = &DiscoveryCounters{}
+			s.sockets[recvOn] = c
+		}
+		f(c)
+	}
+	name := s.interfaceFor(from, recvOn)
+	c := s.ifaces[name]
+	if c == nil {
+		c = &DiscoveryCounters{}
+		s.ifaces[name] = c
+	}
+	f(c)
+}
+
+// interfaceFor names the interface a packet is attributed to: the IPv6 zone it arrived on; else the interface whose subnet
+// contains the sender (longest prefix); else the interface owning the receiving socket's bound address; else "unknown".
+// Sockets bound to 0.0.0.0 cannot tell which interface a packet came in on, so the sender's subnet stands in for it. Caller holds mu.
+func (s *statsRecorder) interfaceFor(from *net.UDPAddr, recvOn string) string {
+	if from != nil && from.Zone != "" {
+		return from.Zone
+	}
+	if time.Since(s.ifNetsAt) > ifaceCacheTTL {
+		s.ifNets = loadIfaceNets(s.network)
+		s.ifNetsAt = time.Now()
+	}
+	if from != nil {
+		best, bestBits := "", -1
+		for _, n := range s.ifNets {
+			if bits, _ := n.ipnet.Mask.Size(); bits > bestBits && n.ipnet.Contains(from.IP) {
+				best, bestBits = n.name, bits
+			}
+		}
+		if best != "" {
+			return best
+		}
+	}
+	if la, err := net.ResolveUDPAddr("udp", recvOn); err == nil && la.IP != nil && !la.IP.IsUnspecified() {
+		for _, n := range s.ifNets {
+			if n.ipnet.IP.Equal(la.IP) {
+				return n.name
+			}
+		}
+	}
+	return "unknown"
+}
+
+func loadIfaceNets(n Network) []ifaceNet {
+	ifaces, err := n.Interfaces()
+	if err != nil {
+		return nil
+	}
+	var out []ifaceNet
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		for _, ipnet := range iface.Addrs {
+			out = append(out, ifaceNet{name: iface.Name, ipnet: ipnet})
+		}
+	}
+	return out
+}
+
+// Stats returns a snapshot of the discovery counters, with the signed-mode and responder-limit drop counters.
+func (d *Discovery) Stats() DiscoveryStats {
+	s := d.stats
+	s.mu.Lock()
+	out := DiscoveryStats{
+		Since:      s.since,
+		Totals:     s.totals,
+		Sockets:    make(map[string]DiscoveryCounters, len(s.sockets)),
+		Interfaces: make(map[string]DiscoveryCounters, len(s.ifaces)),
+	}
+	for k, c := range s.sockets {
+		out.Sockets[k] = *c
+	}
+	for k, c := range s.ifaces {
+		out.Interfaces[k] = *c
+	}
+	s.mu.Unlock()
+	out.Auth = d.AuthStats()
+	out.Responder = d.ResponderStats()
+	return out
+}
@@ -0,0 +1,94 @@
+package discovery
+
+import (
+	"math/bits"
+	"net"
+	"sort"
+)
+
+// MatchLocalIPs returns the local IPv4 addresses whose interface subnet (the address's real net.IPNet mask)
+// contains target, best match first. target may be a host address (a requester) or a directed broadcast.
+// Every address of every up, non-loopback interface is considered, so secondary IPs and several
+// addresses per NIC each match on their own prefix. Ordering: longer prefix first (more specific
+// subnet), then the address sharing more leading bits with target (on a /23 with .236.x and .237.x,
+// a requester in .237 gets .237.x), then interface order.
+//
+// This is the single subnet-matching rule for choosing source sockets: broadcast sends
+// (LocalIPsInSubnet, OpenDiscoveryClientUDP, SendDiscoveryClientBroadcast) and the reply path (localIPInSameSubnetAs).
+func MatchLocalIPs(target net.IP) []net.IP {
+	return matchLocalIPs(SystemNetwork{}, target)
+}
+
+// matchLocalIPs is MatchLocalIPs on the interfaces of n (a simulated host, or the real one).
+func matchLocalIPs(n Network, target net.IP) []net.IP {
+	target = target.To4()
+	if target == nil {
+		return nil
+	}
+	ifaces, err := n.Interfaces()
+	if err != nil {
+		return nil
+	}
+	type match struct {
+		ip     net.IP
+		prefix int
+		common int
+	}
+	var matches []match
+	seen := make(map[string]bool)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		for _, ipnet := range iface.Addrs {
+			if ipnet.IP.To4() == nil || ipnet.IP.IsLoopback() {
+				continue
+			}
+			ip := ipnet.IP.To4()
+			if seen[ip.String()] || !ipnet.Contains(target) {
+				continue
+			}
+			seen[ip.String()] = true
+			prefix, _ := ipnet.Mask.Size()
+			matches = append(matches, match{ip: append(net.IP(nil), ip...), prefix: prefix, common: commonPrefixLen(ip, target)})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].prefix != matches[j].prefix {
+			return matches[i].prefix > matches[j].prefix
+		}
+		return matches[i].common > matches[j].common
+	})
+	out := make([]net.IP, len(matches))
+	for i, m := range matches {
+		out[i] = m.ip
+	}
+	return out
+}
+
+// commonPrefixLen returns the number of leading bits a and b (both 4-byte IPv4) share.
+func commonPrefixLen(a, b net.IP) int {
+	n := 0
+	for i := 0; i < 4; i++ {
+		x := a[i] ^ b[i]
+		if x != 0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
+
+// LocalIPsInSubnet returns local IPv4 addresses whose subnet contains the given broadcast IP (see MatchLocalIPs).
+// A limited broadcast (255.255.255.255) matches none; callers then fall back to conns[0].
+func LocalIPsInSubnet(broadcast net.IP) []net.IP {
+	return MatchLocalIPs(broadcast)
+}
+
+// localIPInSameSubnetAs returns the local IPv4 address that best matches the requester's subnet (see MatchLocalIPs), or nil.
+func (d *Discovery) localIPInSameSubnetAs(remote net.IP) net.IP {
+	if m := matchLocalIPs(d.network(), remote); len(m) > 0 {
+		return m[0]
+	}
+	return nil
+}
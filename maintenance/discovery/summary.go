@@ -0,0 +1,79 @@
+package discovery
+
+import (
+	"math"
+	"slices"
+	"unicode/utf8"
+
+	"contrabass-agent/maintenance/hostinfo"
+)
+
+// MaxDiscoveryResponsePayloadBytes is the UDP budget for DISCOVERY_RESPONSE (same reasoning as MaxDiscoveryRequestPayloadBytes).
+// Only the optional Summary is dropped to stay under it; the rest of the response is sent as before.
+const MaxDiscoveryResponsePayloadBytes = 1300
+
+// summaryTextMax bounds each string in HostSummary so the summary stays around 300 bytes.
+const summaryTextMax = 40
+
+// HostSummary is the compact inventory carried in DISCOVERY_RESPONSE. The full hostinfo.Inventory is in HTTP /self only.
+type HostSummary struct {
+	Load1            float64 `json:"load_1"`
+	UptimeSeconds    uint64  `json:"uptime_seconds"`
+	OS               string  `json:"os,omitempty"`     // os-release PRETTY_NAME
+	Kernel           string  `json:"kernel,omitempty"` // kernel release
+	CPUCores         int     `json:"cpu_cores,omitempty"`
+	CPUSockets       int     `json:"cpu_sockets,omitempty"`
+	SwapUsagePercent float64 `json:"swap_usage_percent"`
+	// DiskMaxUsagePercent and DiskMaxMount name the fullest real filesystem.
+	DiskMaxUsagePercent float64 `json:"disk_max_usage_percent"`
+	DiskMaxMount        string  `json:"disk_max_mount,omitempty"`
+}
+
+// SummaryOf reduces inv to a HostSummary (strings cut to summaryTextMax bytes, percentages to 2 decimals). nil for nil.
+func SummaryOf(inv *hostinfo.Inventory) *HostSummary {
+	if inv == nil {
+		return nil
+	}
+	osName := inv.OS.PrettyName
+	if osName == "" {
+		osName = inv.OS.Name + " " + inv.OS.VersionID
+	}
+	s := &HostSummary{
+		Load1:            round2(inv.LoadAverage1),
+		UptimeSeconds:    inv.UptimeSeconds,
+		OS:               truncateUTF8(osName, summaryTextMax),
+		Kernel:           truncateUTF8(inv.KernelVersion, summaryTextMax),
+		CPUCores:         inv.CPUCores,
+		CPUSockets:       inv.CPUSockets,
+		SwapUsagePercent: round2(inv.SwapUsagePercent),
+	}
+	if len(inv.Disks) > 0 {
+		fullest := slices.MaxFunc(inv.Disks, func(a, b hostinfo.DiskUsage) int {
+			switch {
+			case a.UsagePercent < b.UsagePercent:
+				return -1
+			case a.UsagePercent > b.UsagePercent:
+				return 1
+			}
+			return 0
+		})
+		s.DiskMaxUsagePercent = round2(fullest.UsagePercent)
+		s.DiskMaxMount = truncateUTF8(fullest.Mount, summaryTextMax)
+	}
+	return s
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a rune.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
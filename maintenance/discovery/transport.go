@@ -0,0 +1,107 @@
+package discovery
+
+import (
+	"net"
+)
+
+// PacketConn is the UDP socket Discovery reads from and writes to. *net.UDPConn implements it;
+// simnet.Conn is an in-memory one for simulated hosts.
+type PacketConn interface {
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+	LocalAddr() net.Addr
+	Close() error
+}
+
+// Interface is one network interface as the subnet logic sees it: name, flags, MAC and its addresses with their real masks.
+type Interface struct {
+	Name         string
+	Index        int
+	Flags        net.Flags
+	HardwareAddr net.HardwareAddr
+	Addrs        []*net.IPNet
+}
+
+// Network is the host Discovery runs on: its interfaces, its routing decision for a destination, and unbound sends.
+// SystemNetwork is the real host; simnet.Host is a simulated one. Config.Network nil means SystemNetwork.
+type Network interface {
+	Interfaces() ([]Interface, error)
+	// OutboundIP returns the local address the host would send from to reach remote:port ("" when there is no route).
+	OutboundIP(remote net.IP, port int) string
+	// SendUnbound sends data to to from an ephemeral local port (the reply path when no bound socket fits).
+	SendUnbound(data []byte, to *net.UDPAddr) error
+}
+
+// SystemNetwork is the Network of the machine the binary runs on.
+type SystemNetwork struct{}
+
+// Interfaces lists the host's interfaces with their IP addresses (net.Interfaces).
+func (SystemNetwork) Interfaces() ([]Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Interface, 0, len(ifaces))
+	for _, ifi := range ifaces {
+		it := Interface{Name: ifi.Name, Index: ifi.Index, Flags: ifi.Flags, HardwareAddr: ifi.HardwareAddr}
+		addrs, _ := ifi.Addrs()
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				it.Addrs = append(it.Addrs, ipnet)
+			}
+		}
+		out = append(out, it)
+	}
+	return out, nil
+}
+
+// OutboundIP asks the kernel for the route to remote:port (see OutboundIP).
+func (SystemNetwork) OutboundIP(remote net.IP, port int) string {
+	return OutboundIP(remote, port)
+}
+
+// SendUnbound dials to (kernel picks source address and port) and writes data once.
+func (SystemNetwork) SendUnbound(data []byte, to *net.UDPAddr) error {
+	network := "udp"
+	if to.IP.To4() != nil {
+		network = "udp4"
+	}
+	conn, err := net.DialUDP(network, nil, to)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(data)
+	return err
+}
+
+// UDPConns adapts real sockets for NewWithConns.
+func UDPConns(conns []*net.UDPConn) []PacketConn {
+	out := make([]PacketConn, len(conns))
+	for i, c := range conns {
+		out[i] = c
+	}
+	return out
+}
+
+// network returns Config.Network, or the real host when unset.
+func (d *Discovery) network() Network {
+	if d.cfg.Network != nil {
+		return d.cfg.Network
+	}
+	return SystemNetwork{}
+}
+
+// interfaceByName returns the named interface of n, or false.
+func interfaceByName(n Network, name string) (Interface, bool) {
+	ifaces, err := n.Interfaces()
+	if err != nil {
+		return Interface{}, false
+	}
+	for _, ifi := range ifaces {
+		if ifi.Name == name {
+			return ifi, true
+		}
+	}
+	return Interface{}, false
+}
@@ -0,0 +1,60 @@
+package maintenance
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"contrabass-agent/maintenance/config"
+	"contrabass-agent/maintenance/discovery"
+	"contrabass-agent/maintenance/discovery/mdns"
+	"contrabass-agent/maintenance/hostinfo"
+)
+
+// startMDNS announces this agent as _contrabass._tcp (Maintenance.DiscoveryMDNS.Advertise) on the discovery interfaces.
+// Returns nil when advertising is off or 5353 cannot be bound; UDP discovery does not depend on it.
+func startMDNS(cfg *config.Config, version string) *mdns.Responder {
+	if !cfg.DiscoveryMDNS.Advertise {
+		return nil
+	}
+	info, _ := hostinfo.Get()
+	svc := mdns.Service{
+		Instance:    cfg.DiscoveryMDNS.InstanceName,
+		Hostname:    info.Hostname,
+		HTTPPort:    cfg.ServerHTTPPort,
+		ServicePort: cfg.MaintenancePort,
+		APIPrefix:   cfg.APIPrefix,
+		Version:     version,
+		CPUUUID:     info.CPUUUID,
+	}
+	r, err := mdns.Listen(svc, hostinfo.GetIPv4DiscoveryInterfaces)
+	if err != nil {
+		log.Printf("mdns: listen :%d failed: %v (DNS-SD advertisement disabled)", mdns.Port, err)
+		return nil
+	}
+	go r.Run()
+	go r.Announce()
+	log.Printf("mdns: advertising %s (port %d) on %v", r.Instance(), svc.HTTPPort, r.Interfaces())
+	return r
+}
+
+// mdnsBrowser is the discovery.Config.Browser for Maintenance.DiscoveryMDNS.Browse: each run also browses mDNS for up to
+// BrowseSeconds, on the same interfaces as the broadcast.
+func mdnsBrowser(cfg *config.Config) discovery.HostBrowser {
+	if !cfg.DiscoveryMDNS.Browse {
+		return nil
+	}
+	wait := time.Duration(cfg.DiscoveryMDNS.BrowseSeconds) * time.Second
+	return func(ctx context.Context, timeout time.Duration) []discovery.DiscoveryResponse {
+		entries, err := mdns.Browse(ctx, hostinfo.GetIPv4DiscoveryInterfaces(), min(wait, timeout))
+		if err != nil {
+			log.Printf("mdns: browse: %v", err)
+			return nil
+		}
+		out := make([]discovery.DiscoveryResponse, 0, len(entries))
+		for _, e := range entries {
+			out = append(out, e.Response(cfg.DiscoveryServiceName))
+		}
+		return out
+	}
+}
@@ -0,0 +1,234 @@
+package maintenance
+
+import (
+	"context"
+	"log"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+
+	"contrabass-agent/maintenance/discovery"
+	"contrabass-agent/maintenance/hostinfo"
+)
+
+// discoverySockets keeps the per-IPv4 discovery sockets (next to the 0.0.0.0 one) and the broadcast address list in step
+// with the host's interfaces, so a DHCP renewal, a new VLAN interface or a link flap does not need a restart.
+type discoverySockets struct {
+	lc          net.ListenConfig
+	port        int
+	brdExtra    []string // Maintenance.DiscoveryBroadcastAddresses, merged with the automatic ones
+	brdFallback string   // Maintenance.DiscoveryBroadcastAddress
+	conn0       *net.UDPConn
+
+	mu         sync.Mutex
+	disc       *discovery.Discovery // nil until attach: before that, sync only binds
+	byIP       map[string]*net.UDPConn
+	order      []string // bound IPs in bind order
+	brds       []string
+	mcastGroup net.IP // multicast modes only
+	mcastTTL   int
+	joined     map[string]bool // interfaces conn0 joined mcastGroup on
+}
+
+func newDiscoverySockets(lc net.ListenConfig, port int, conn0 *net.UDPConn, brdExtra []string, brdFallback string) *discoverySockets {
+	return &discoverySockets{
+		lc:          lc,
+		port:        port,
+		brdExtra:    brdExtra,
+		brdFallback: brdFallback,
+		conn0:       conn0,
+		byIP:        make(map[string]*net.UDPConn),
+		joined:      make(map[string]bool),
+	}
+}
+
+// localDiscoveryIPv4s lists the IPv4 addresses of up, non-loopback interfaces that pass Maintenance.DiscoveryInterfaces
+// and the automatic rules (hostinfo.DiscoveryInterfaceIncluded), no duplicates, interface order. docker0 or veth*
+// addresses get no socket, so they are neither answered from nor reported in /self host_ips.
+func localDiscoveryIPv4s() []string {
+	var out []string
+	seen := make(map[string]bool)
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || !hostinfo.DiscoveryInterfaceIncluded(iface.Name) {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil || ipnet.IP.IsLoopback() {
+				continue
+			}
+			ip := ipnet.IP.String()
+			if !seen[ip] {
+				seen[ip] = true
+				out = append(out, ip)
+			}
+		}
+	}
+	return out
+}
+
+// sync binds a socket for every new local IPv4 and closes the sockets of addresses that went away.
+func (s *discoverySockets) sync() (added, removed []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current := localDiscoveryIPv4s()
+	for _, ip := range slices.Clone(s.order) {
+		if slices.Contains(current, ip) {
+			continue
+		}
+		conn := s.byIP[ip]
+		delete(s.byIP, ip)
+		s.order = slices.DeleteFunc(s.order, func(o string) bool { return o == ip })
+		if s.disc != nil {
+			s.disc.RemoveConn(conn)
+		} else {
+			_ = conn.Close()
+		}
+		removed = append(removed, ip)
+	}
+	for _, ip := range current {
+		if _, ok := s.byIP[ip]; ok {
+			continue
+		}
+		pc, err := s.lc.ListenPacket(context.Background(), "udp4", net.JoinHostPort(ip, strconv.Itoa(s.port)))
+		if err != nil {
+			log.Printf("discovery: bind %s:%d failed: %v (responses to this IP may not be received)", ip, s.port, err)
+			continue
+		}
+		conn := pc.(*net.UDPConn)
+		if s.mcastGroup != nil {
+			if err := discovery.SetMulticastTTL(conn, s.mcastTTL); err != nil {
+				log.Printf("discovery: set multicast TTL on %s: %v", conn.LocalAddr(), err)
+			}
+		}
+		s.byIP[ip] = conn
+		s.order = append(s.order, ip)
+		if s.disc != nil {
+			s.disc.AddConn(conn)
+		}
+		added = append(added, ip)
+	}
+	return added, removed
+}
+
+// conns returns conn0 followed by the per-IP sockets (for discovery.New).
+func (s *discoverySockets) conns() []*net.UDPConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []*net.UDPConn{s.conn0}
+	for _, ip := range s.order {
+		out = append(out, s.byIP[ip])
+	}
+	return out
+}
+
+// boundIPs returns "0.0.0.0" and the per-IP socket addresses (fed to /self as HostIPs).
+func (s *discoverySockets) boundIPs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{"0.0.0.0"}, s.order...)
+}
+
+// broadcastAddresses computes the brd list (PRD §3.1.1, DiscoveryInterfaces rules) plus DiscoveryBroadcastAddresses,
+// with the configured and limited-broadcast fallbacks when both are empty.
+func (s *discoverySockets) broadcastAddresses() []string {
+	brds := hostinfo.GetPhysicalNICBroadcastAddresses()
+	for _, a := range s.brdExtra {
+		if !slices.Contains(brds, a) {
+			brds = append(brds, a)
+		}
+	}
+	if len(brds) > 0 {
+		log.Printf("discovery: broadcast addresses: %v", brds)
+		return brds
+	}
+	if s.brdFallback != "" {
+		log.Printf("discovery: no brd addresses collected (3.1.1), using config fallback: %v", []string{s.brdFallback})
+		return []string{s.brdFallback}
+	}
+	log.Printf("discovery: no brd addresses collected (3.1.1), using 255.255.255.255")
+	return []string{"255.255.255.255"}
+}
+
+// joinMulticast sets the multicast TTL on every IPv4 socket and joins group on conn0 for the discovery interfaces
+// not joined yet. Sockets bound later get the TTL in sync.
+func (s *discoverySockets) joinMulticast(group net.IP, ttl int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mcastGroup, s.mcastTTL = group, ttl
+	var fresh []string
+	names := hostinfo.GetIPv4DiscoveryInterfaces()
+	for _, name := range names {
+		if !s.joined[name] {
+			fresh = append(fresh, name)
+		}
+	}
+	// An interface that went away (VLAN deleted) loses its membership; join again if it comes back.
+	for name := range s.joined {
+		if !slices.Contains(names, name) {
+			delete(s.joined, name)
+		}
+	}
+	// Only the 0.0.0.0 socket receives group traffic; per-IP sockets send one copy per interface.
+	joined := discovery.JoinIPv4Group(s.conn0, group, fresh)
+	for _, name := range joined {
+		s.joined[name] = true
+	}
+	for _, c := range append([]*net.UDPConn{s.conn0}, s.perIPLocked()...) {
+		if err := discovery.SetMulticastTTL(c, ttl); err != nil {
+			log.Printf("discovery: set multicast TTL on %s: %v", c.LocalAddr(), err)
+		}
+	}
+	return joined
+}
+
+func (s *discoverySockets) perIPLocked() []*net.UDPConn {
+	out := make([]*net.UDPConn, 0, len(s.order))
+	for _, ip := range s.order {
+		out = append(out, s.byIP[ip])
+	}
+	return out
+}
+
+// attach hands later changes to d (AddConn / RemoveConn / SetBroadcastAddresses).
+func (s *discoverySockets) attach(d *discovery.Discovery, brds []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disc = d
+	s.brds = brds
+}
+
+// refresh is run after an address or link change: rebinds per-IP sockets, recomputes the broadcast addresses and
+// joins the multicast group on new interfaces. IPv6 group membership stays as set up at start.
+func (s *discoverySockets) refresh() {
+	added, removed := s.sync()
+	brds := s.broadcastAddresses()
+	s.mu.Lock()
+	d, changed := s.disc, !slices.Equal(brds, s.brds)
+	s.brds = brds
+	group, ttl := s.mcastGroup, s.mcastTTL
+	s.mu.Unlock()
+	if d != nil && changed {
+		d.SetBroadcastAddresses(brds)
+	}
+	if group != nil {
+		if joined := s.joinMulticast(group, ttl); len(joined) > 0 {
+			log.Printf("discovery: multicast group %s joined on new interface(s) %v", group, joined)
+		}
+	}
+	if len(added) > 0 || len(removed) > 0 || changed {
+		log.Printf("discovery: interfaces changed: bound %v, closed %v, bound IPs now %v, broadcast addresses %v", added, removed, s.boundIPs(), brds)
+	}
+}
+
+// close closes the per-IP sockets (conn0 is closed by the caller, which stops Discovery.Run).
+func (s *discoverySockets) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.byIP {
+		_ = c.Close()
+	}
+}
@@ -2,7 +2,6 @@ package discoverycli
 
 import (
 	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"net"
@@ -20,7 +19,8 @@ import (
 )
 
 // Run runs standalone UDP discovery (no config file, no HTTP server).
-// Invoked as: <binary> agent --discovery [--dest-port=N] [--src-port=N] [--timeout=N] [--service=name] (binary name is appmeta.BinaryName).
+// Invoked as: <binary> agent --discovery [--dest-port=N] [--src-port=N] [--timeout=N] [--service=name] [--secret=S | --secret-file=path] [--ipv6 [--ipv6-group=G]]
+// [--hostname=GLOB] [--min-version=K] [--max-version=K] [--cpu-uuid=U] [--label=SEL ...] [--limit=N] [--quiet-ms=MS] (binary name is appmeta.BinaryName).
 // Returns 0 on success, 1 on error.
 func Run(args []string) int {
 	fs := flag.NewFlagSet("discovery", flag.ContinueOnError)
@@ -28,11 +28,24 @@ func Run(args []string) int {
 	destPort := fs.Int("dest-port", 9999, "destination UDP port (remote agents listen here)")
 	srcPort := fs.Int("src-port", 9998, "local UDP port to bind (responses arrive here)")
 	timeoutSec := fs.Int("timeout", 10, "discovery duration in seconds")
+	limit := fs.Int("limit", 0, "stop as soon as this many hosts answered (0 = wait for --timeout)")
+	quietMS := fs.Int("quiet-ms", 0, "stop when no new host answered for this many milliseconds (0 = wait for --timeout)")
 	serviceName := fs.String("service", config.DefaultDiscoveryServiceName, "service name in DISCOVERY_REQUEST")
+	secret := fs.String("secret", "", "shared secret for signed discovery (same as Maintenance.DiscoveryAuth.SharedSecret)")
+	secretFile := fs.String("secret-file", "", "file containing the shared secret (takes precedence over --secret)")
+	maxSkewSec := fs.Int("max-skew", 30, "signed mode: max clock skew in seconds for responses")
+	useIPv6 := fs.Bool("ipv6", false, "also send to the IPv6 link-local multicast group on each interface with an fe80:: address")
+	ipv6GroupStr := fs.String("ipv6-group", discovery.DefaultIPv6Group, "IPv6 multicast group (same as Maintenance.DiscoveryIPv6Group)")
+	hostnameGlob := fs.String("hostname", "", "only hosts whose hostname matches this glob answer (e.g. 'db-*')")
+	minVersion := fs.String("min-version", "", "only hosts with version key >= this answer")
+	maxVersion := fs.String("max-version", "", "only hosts with version key <= this answer")
+	cpuUUID := fs.String("cpu-uuid", "", "only the host with this CPU UUID answers")
+	var labels labelFlag
+	fs.Var(&labels, "label", "label selector key=value, key!=value, key or !key (repeatable, or comma-separated)")
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s agent --discovery [flags]\n\n", appmeta.BinaryName)
-		fmt.Fprintf(os.Stderr, "  Sends DISCOVERY_REQUEST to broadcast:<dest-port>, listens on <src-port>.\n")
-		fmt.Fprintf(os.Stderr, "  Each line: [Local|Remote] hostname - primary : [response IPs] version=<agent version>\n\n")
+		fmt.Fprintf(os.Stderr, "  Sends DISCOVERY_REQUEST to broadcast:<dest-port> (and [<ipv6-group>%%iface]:<dest-port> with --ipv6), listens on <src-port>.\n")
+		fmt.Fprintf(os.Stderr, "  Each line: [Local|Remote] hostname - primary : [response IPs] version=<agent version> [labels=k=v,...]\n\n")
 		fs.PrintDefaults()
 	}
 	for _, a := range args {
@@ -56,10 +69,42 @@ func Run(args []string) int {
 		fmt.Fprintf(os.Stderr, "%s: --timeout must be positive\n", appmeta.BinaryName)
 		return 1
 	}
+	if *limit < 0 || *quietMS < 0 {
+		fmt.Fprintf(os.Stderr, "%s: --limit and --quiet-ms must not be negative\n", appmeta.BinaryName)
+		return 1
+	}
 	svc := strings.TrimSpace(*serviceName)
 	if svc == "" {
 		svc = config.DefaultDiscoveryServiceName
 	}
+	authCfg := config.DiscoveryAuthConfig{SharedSecret: *secret, SharedSecretFile: *secretFile, MaxClockSkewSeconds: *maxSkewSec}
+	sharedSecret, err := authCfg.ResolveSecret()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", appmeta.BinaryName, err)
+		return 1
+	}
+	auth := discovery.NewAuthenticator(sharedSecret, time.Duration(*maxSkewSec)*time.Second)
+	filter := &discovery.DiscoveryFilter{
+		Hostname:   strings.TrimSpace(*hostnameGlob),
+		MinVersion: strings.TrimSpace(*minVersion),
+		MaxVersion: strings.TrimSpace(*maxVersion),
+		CPUUUID:    strings.TrimSpace(*cpuUUID),
+		Labels:     labels,
+	}
+	if filter.IsZero() {
+		filter = nil
+	} else if err := filter.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", appmeta.BinaryName, err)
+		return 1
+	}
+	var ipv6Group net.IP
+	if *useIPv6 {
+		ipv6Group, err = discovery.ParseIPv6Group(strings.TrimSpace(*ipv6GroupStr))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: --ipv6-group: %v\n", appmeta.BinaryName, err)
+			return 1
+		}
+	}
 
 	broadcastAddrs := hostinfo.GetPhysicalNICBroadcastAddresses()
 	if len(broadcastAddrs) == 0 {
@@ -75,8 +120,27 @@ func Run(args []string) int {
 		fmt.Fprintf(os.Stderr, "%s: UDP bind for discovery failed: %v\n", appmeta.BinaryName, err)
 		return 1
 	}
+	var ipv6Targets []*net.UDPAddr
+	var conn6 *net.UDPConn
+	if ipv6Group != nil {
+		ipv6Targets = discovery.IPv6GroupTargets(ipv6Group, *destPort, hostinfo.GetIPv6DiscoveryInterfaces())
+		fmt.Println("Discovery IPv6 group:")
+		for _, t := range ipv6Targets {
+			fmt.Printf("  %s%%%s\n", t.IP, t.Zone)
+		}
+		// Requester only sends to the group and reads unicast replies; no join needed.
+		conn6, err = discovery.OpenIPv6DiscoveryUDP(*srcPort, ipv6Group, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: UDP bind for IPv6 discovery failed: %v\n", appmeta.BinaryName, err)
+			return 1
+		}
+	}
+	readConns := conns
+	if conn6 != nil {
+		readConns = append(append([]*net.UDPConn(nil), conns...), conn6)
+	}
 	defer func() {
-		for _, c := range conns {
+		for _, c := range readConns {
 			_ = c.Close()
 		}
 	}()
@@ -91,8 +155,11 @@ func Run(args []string) int {
 		Service:      svc,
 		RequestID:    requestID,
 		ReplyUDPPort: replyUDPPort,
+		ProtoVersion: discovery.ProtoVersion,
+		Capabilities: discovery.Capabilities(discovery.Features{}),
+		Filter:       filter,
 	}
-	payload, err := json.Marshal(req)
+	payload, err := auth.Marshal(&req)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, appmeta.BinaryName+":", err)
 		return 1
@@ -102,10 +169,20 @@ func Run(args []string) int {
 		return 1
 	}
 
-	if err := discovery.SendDiscoveryClientBroadcast(conns, payload, *destPort, broadcastAddrs); err != nil {
+	if err := discovery.SendDiscoveryClientBroadcast(conns, auth, payload, *destPort, broadcastAddrs); err != nil {
 		fmt.Fprintf(os.Stderr, "%s: discovery broadcast send: %v\n", appmeta.BinaryName, err)
 		return 1
 	}
+	for _, t := range ipv6Targets {
+		b, err := auth.Resign(payload)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, appmeta.BinaryName+":", err)
+			return 1
+		}
+		if _, err := conn6.WriteToUDP(b, t); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: discovery ipv6 send to %s: %v\n", appmeta.BinaryName, t, err)
+		}
+	}
 
 	recvGrace := 500 * time.Millisecond
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*timeoutSec)*time.Second+recvGrace)
@@ -113,6 +190,32 @@ func Run(args []string) int {
 
 	var mu sync.Mutex
 	var responses []discovery.DiscoveryResponse
+	// hosts (discovery.HostKey of responses matching the filter, in arrival order) and lastNew drive --limit and --quiet-ms.
+	var hosts []string
+	hostSeen := make(map[string]struct{})
+	lastNew := time.Now()
+	limitReached := make(chan struct{})
+	record := func(resp discovery.DiscoveryResponse) {
+		mu.Lock()
+		defer mu.Unlock()
+		responses = append(responses, resp)
+		if !filter.MatchResponse(&resp) {
+			return
+		}
+		key := discovery.HostKey(&resp)
+		if _, ok := hostSeen[key]; ok {
+			return
+		}
+		if *limit > 0 && len(hosts) >= *limit {
+			return
+		}
+		hostSeen[key] = struct{}{}
+		hosts = append(hosts, key)
+		lastNew = time.Now()
+		if *limit > 0 && len(hosts) == *limit {
+			close(limitReached)
+		}
+	}
 
 	readLoop := func(conn *net.UDPConn) {
 		buf := make([]byte, 8192)
@@ -130,14 +233,12 @@ func Run(args []string) int {
 				}
 				return
 			}
-			if resp, ok := discovery.MatchDiscoveryResponseUDP(buf, n, from, requestID, svc); ok {
-				mu.Lock()
-				responses = append(responses, resp)
-				mu.Unlock()
+			if resp, ok := discovery.MatchDiscoveryResponseUDP(buf, n, from, requestID, svc, auth); ok {
+				record(resp)
 			}
 		}
 	}
-	for _, c := range conns {
+	for _, c := range readConns {
 		go readLoop(c)
 	}
 
@@ -146,47 +247,98 @@ func Run(args []string) int {
 		nw = 2
 	}
 	maxLineLen := len(fmt.Sprintf("Discovering ... %*d ", nw, *timeoutSec))
-	for i := *timeoutSec; i >= 1; i-- {
-		fmt.Printf("\rDiscovering ... %*d ", nw, i)
-		time.Sleep(1 * time.Second)
+	// The countdown ticks every 100ms so --limit and --quiet-ms end the run without waiting for the next second.
+	deadline := time.Now().Add(time.Duration(*timeoutSec) * time.Second)
+	quiet := time.Duration(*quietMS) * time.Millisecond
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	early := ""
+	shown := -1
+countdown:
+	for {
+		if left := int((time.Until(deadline) + time.Second - 1) / time.Second); left != shown && left >= 1 {
+			fmt.Printf("\rDiscovering ... %*d ", nw, left)
+			shown = left
+		}
+		select {
+		case <-limitReached:
+			early = fmt.Sprintf("limit of %d host(s) reached", *limit)
+			break countdown
+		case now := <-tick.C:
+			if !now.Before(deadline) {
+				break countdown
+			}
+			if quiet > 0 {
+				mu.Lock()
+				idle := now.Sub(lastNew)
+				mu.Unlock()
+				if idle >= quiet {
+					early = fmt.Sprintf("no new host for %s", quiet)
+					break countdown
+				}
+			}
+		}
 	}
 	doneLine := "Discovery Done."
+	if early != "" {
+		doneLine = "Discovery Done (" + early + ")."
+	}
 	if len(doneLine) < maxLineLen {
 		doneLine = doneLine + strings.Repeat(" ", maxLineLen-len(doneLine))
 	}
 	fmt.Printf("\r%s\n", doneLine)
-	time.Sleep(300 * time.Millisecond)
-	cancel()
-	time.Sleep(50 * time.Millisecond)
+	if early == "" {
+		// Full-length run: give late answers a moment and read what is already queued on the sockets.
+		time.Sleep(300 * time.Millisecond)
+		cancel()
+		time.Sleep(50 * time.Millisecond)
 
-	drainBuf := make([]byte, 8192)
-	for _, conn := range conns {
-		_ = conn.SetReadDeadline(time.Now().Add(150 * time.Millisecond))
-		for {
-			n, from, err := conn.ReadFromUDP(drainBuf)
-			if err != nil {
-				break
-			}
-			if resp, ok := discovery.MatchDiscoveryResponseUDP(drainBuf, n, from, requestID, svc); ok {
-				mu.Lock()
-				responses = append(responses, resp)
-				mu.Unlock()
+		drainBuf := make([]byte, 8192)
+		for _, conn := range readConns {
+			_ = conn.SetReadDeadline(time.Now().Add(150 * time.Millisecond))
+			for {
+				n, from, err := conn.ReadFromUDP(drainBuf)
+				if err != nil {
+					break
+				}
+				if resp, ok := discovery.MatchDiscoveryResponseUDP(drainBuf, n, from, requestID, svc, auth); ok {
+					record(resp)
+				}
 			}
 		}
 	}
+	cancel()
 
 	mu.Lock()
-	list := append([]discovery.DiscoveryResponse(nil), responses...)
+	// Conflicts are looked for in every response, filtered or not: a clone hides behind the same UUID either way.
+	detector := discovery.NewConflictDetector()
+	for i := range responses {
+		detector.Add(&responses[i], false)
+	}
+	var list []discovery.DiscoveryResponse
+	for i := range responses {
+		// Agents older than proto_version 2 ignore the filter and answer anyway.
+		if !filter.MatchResponse(&responses[i]) {
+			continue
+		}
+		// With --limit, hosts past the first N (answering in the same instant) are left out.
+		if _, ok := hostSeen[discovery.HostKey(&responses[i])]; ok {
+			list = append(list, responses[i])
+		}
+	}
 	mu.Unlock()
 
-	lines := formatResults(list)
+	conflicts := detector.Conflicts()
+	lines := formatResults(list, conflicts)
+	lines = append(lines, formatConflicts(conflicts)...)
 	for _, line := range lines {
 		fmt.Println(line)
 	}
 	return 0
 }
 
-func formatResults(list []discovery.DiscoveryResponse) []string {
+// formatResults prints one line per host (grouped by CPU UUID); hosts involved in a conflict get a " CONFLICT=<kind>" suffix.
+func formatResults(list []discovery.DiscoveryResponse, conflicts []discovery.Conflict) []string {
 	if len(list) == 0 {
 		return []string{"(no hosts found)"}
 	}
@@ -206,6 +358,7 @@ func formatResults(list []discovery.DiscoveryResponse) []string {
 		hostIP   string
 		cpuUUID  string
 		version  string // from DISCOVERY_RESPONSE (same as agent version key)
+		labels   map[string]string
 		ips      map[string]struct{}
 	}
 	groups := make(map[string]*group)
@@ -228,6 +381,7 @@ func formatResults(list []discovery.DiscoveryResponse) []string {
 				hostIP:   "",
 				cpuUUID:  cpu,
 				version:  strings.TrimSpace(r.Version),
+				labels:   r.Labels,
 				ips:      make(map[string]struct{}),
 			}
 			if g.hostname == "" {
@@ -244,6 +398,9 @@ func formatResults(list []discovery.DiscoveryResponse) []string {
 			if g.version == "" {
 				g.version = strings.TrimSpace(r.Version)
 			}
+			if len(g.labels) == 0 {
+				g.labels = r.Labels
+			}
 		}
 		if r.RespondedFromIP != "" {
 			g.ips[r.RespondedFromIP] = struct{}{}
@@ -253,6 +410,17 @@ func formatResults(list []discovery.DiscoveryResponse) []string {
 		}
 	}
 
+	conflictUUIDs := make(map[string]struct{})
+	conflictIPs := make(map[string]struct{})
+	for _, c := range conflicts {
+		switch c.Kind {
+		case discovery.ConflictCPUUUID:
+			conflictUUIDs[strings.ToLower(c.Value)] = struct{}{}
+		case discovery.ConflictHostIP:
+			conflictIPs[c.Value] = struct{}{}
+		}
+	}
+
 	out := make([]string, 0, len(order))
 	for _, key := range order {
 		g := groups[key]
@@ -270,7 +438,53 @@ func formatResults(list []discovery.DiscoveryResponse) []string {
 		if ver == "" {
 			ver = "?"
 		}
-		out = append(out, fmt.Sprintf("%s %s - %s : [%s] version=%s", tag, g.hostname, primary, strings.Join(ipList, ", "), ver))
+		line := fmt.Sprintf("%s %s - %s : [%s] version=%s", tag, g.hostname, primary, strings.Join(ipList, ", "), ver)
+		if len(g.labels) > 0 {
+			line += " labels=" + discovery.FormatLabels(g.labels)
+		}
+		var kinds []string
+		if _, ok := conflictUUIDs[strings.ToLower(g.cpuUUID)]; ok && g.cpuUUID != "" {
+			kinds = append(kinds, discovery.ConflictCPUUUID)
+		}
+		for ip := range g.ips {
+			if _, ok := conflictIPs[ip]; ok {
+				kinds = append(kinds, discovery.ConflictHostIP)
+				break
+			}
+		}
+		if len(kinds) > 0 {
+			line += " CONFLICT=" + strings.Join(kinds, ",")
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+// formatConflicts lists each conflicting identity and the hosts claiming it (nothing when there are none).
+func formatConflicts(conflicts []discovery.Conflict) []string {
+	if len(conflicts) == 0 {
+		return nil
+	}
+	out := []string{"", fmt.Sprintf("WARNING: %d host identity conflict(s) — likely cloned images sharing /etc/machine-id or product_uuid:", len(conflicts))}
+	for _, c := range conflicts {
+		out = append(out, fmt.Sprintf("  %s %s claimed by %d hosts:", c.Kind, c.Value, len(c.Hosts)))
+		for _, h := range c.Hosts {
+			name := h.Hostname
+			if name == "" {
+				name = "(no name)"
+			}
+			line := fmt.Sprintf("    %s host_ip=%s", name, h.HostIP)
+			if c.Kind == discovery.ConflictHostIP && h.CPUUUID != "" {
+				line += " cpu_uuid=" + h.CPUUUID
+			}
+			if h.MAC != "" {
+				line += " mac=" + h.MAC
+			}
+			if h.RespondedFromIP != "" && h.RespondedFromIP != h.HostIP {
+				line += " from=" + h.RespondedFromIP
+			}
+			out = append(out, line)
+		}
 	}
 	return out
 }
@@ -286,3 +500,17 @@ func localTag(selfUUID, groupUUID string, responded map[string]struct{}, localIP
 	}
 	return "[Remote]"
 }
+
+// labelFlag collects --label values; each may hold several comma-separated selectors.
+type labelFlag []string
+
+func (l *labelFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *labelFlag) Set(v string) error {
+	sels, err := discovery.ParseLabelSelectors(v)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, sels...)
+	return nil
+}
@@ -0,0 +1,145 @@
+package discoverycli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"contrabass-agent/maintenance/appmeta"
+	"contrabass-agent/maintenance/cliutil"
+	"contrabass-agent/maintenance/config"
+	"contrabass-agent/maintenance/discovery"
+	"contrabass-agent/maintenance/server"
+)
+
+// RunStats runs: <bin> agent --discovery-stats -cfg <config> [--json] [self|remote-ip]
+// It queries GET {API}/discovery/stats of a running agent: self through the local maintenance HTTP server, a remote IP through its Gin port.
+func RunStats(args []string) int {
+	fs := flag.NewFlagSet("discovery-stats", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	cfgPath := fs.String("cfg", "", "path to config file (required)")
+	asJSON := fs.Bool("json", false, "print the raw JSON data instead of tables")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s agent --discovery-stats -cfg <config.yaml> [--json] [self|remote-ip]\n\n", appmeta.BinaryName)
+		fmt.Fprintf(os.Stderr, "  self (default): GET http://MaintenanceListenAddress:MaintenancePort{APIPrefix}/discovery/stats (the agent must be running).\n")
+		fmt.Fprintf(os.Stderr, "  remote IP: GET http://<ip>:Server.HTTPPort{APIPrefix}/discovery/stats on that host (Gin).\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 1
+	}
+	if strings.TrimSpace(*cfgPath) == "" {
+		fmt.Fprintf(os.Stderr, "%s: -cfg <config.yaml> is required\n", appmeta.BinaryName)
+		fs.Usage()
+		return 1
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "%s: expected at most one argument: [self|remote-ip]\n", appmeta.BinaryName)
+		return 1
+	}
+	cfg, err := config.Load(*cfgPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: load config: %v\n", appmeta.BinaryName, err)
+		return 1
+	}
+	target := "self"
+	if fs.NArg() == 1 {
+		target = strings.TrimSpace(fs.Arg(0))
+	}
+	var base string
+	if strings.EqualFold(target, "self") {
+		base = cliutil.LocalMaintenanceBaseURL(cfg)
+	} else {
+		if !cliutil.IsRemoteIP(target) {
+			fmt.Fprintf(os.Stderr, "%s: remote target must be a valid IP address: %q\n", appmeta.BinaryName, target)
+			return 1
+		}
+		base = cliutil.RemoteBaseURL(cfg, target)
+	}
+	statsURL := base + cliutil.NormalizeAPIPrefix(cfg.APIPrefix) + "/discovery/stats"
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(statsURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: request failed (is the agent running?): %v\n", appmeta.BinaryName, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: read body: %v\n", appmeta.BinaryName, err)
+		return 1
+	}
+	var envelope struct {
+		Status string          `json:"status"`
+		Data   json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: parse response from %s: %v\n", appmeta.BinaryName, statsURL, err)
+		return 1
+	}
+	if envelope.Status != "success" {
+		var fail server.APIResponse
+		if json.Unmarshal(body, &fail) == nil {
+			if s, ok := fail.Data.(string); ok && s != "" {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", appmeta.BinaryName, s)
+				return 1
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%s: stats failed: HTTP %d status=%s\n", appmeta.BinaryName, resp.StatusCode, envelope.Status)
+		return 1
+	}
+	if *asJSON {
+		fmt.Println(string(envelope.Data))
+		return 0
+	}
+	var st discovery.DiscoveryStats
+	if err := json.Unmarshal(envelope.Data, &st); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: parse stats: %v\n", appmeta.BinaryName, err)
+		return 1
+	}
+	printStats(os.Stdout, target, st)
+	return 0
+}
+
+func printStats(w io.Writer, target string, st discovery.DiscoveryStats) {
+	fmt.Fprintf(w, "host %s, counting since %s\n\n", target, st.Since.Local().Format(time.RFC3339))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCOPE\tREQ\tANSWERED\tFILTERED\tRESP\tDELIVERED\tCHAN-FULL\tSTALE\tSVC-MISMATCH\tPARSE-FAIL\tAUTH-DROP\tSEND-ERR\t")
+	row := func(scope string, c discovery.DiscoveryCounters) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t\n", scope,
+			c.RequestsReceived, c.RequestsAnswered, c.RequestsFiltered, c.ResponsesReceived, c.ResponsesDelivered,
+			c.DroppedChannelFull, c.DroppedStale, c.ServiceMismatch, c.ParseFailures, c.AuthDropped, c.SendErrors)
+	}
+	row("total", st.Totals)
+	for _, k := range sortedKeys(st.Sockets) {
+		row("socket "+k, st.Sockets[k])
+	}
+	for _, k := range sortedKeys(st.Interfaces) {
+		row("iface "+k, st.Interfaces[k])
+	}
+	_ = tw.Flush()
+	a, r := st.Auth, st.Responder
+	fmt.Fprintf(w, "\nsigned mode drops: unsigned=%d bad_signature=%d stale=%d replayed=%d\n", a.Unsigned, a.BadSignature, a.Stale, a.Replayed)
+	fmt.Fprintf(w, "responder limits: answered=%d not_allowed=%d reply_port=%d source_rate=%d global_budget=%d\n",
+		r.Answered, r.DroppedNotAllowed, r.DroppedReplyPort, r.DroppedSourceRate, r.DroppedGlobalBudget)
+}
+
+func sortedKeys(m map[string]discovery.DiscoveryCounters) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,57 +1,149 @@
 package hostinfo
 
 import (
+	"fmt"
+	"net"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 )
 
-// ipPath returns the path to the "ip" command (prefer full path so it works when PATH is minimal).
-func ipPath() string {
-	if path, err := exec.LookPath("ip"); err == nil {
-		return path
-	}
-	for _, p := range []string{"/usr/sbin/ip", "/sbin/ip"} {
-		if _, err := os.Stat(p); err == nil {
-			return p
-		}
-	}
-	return "ip"
+const netDir = "/sys/class/net"
+
+// InterfaceRules are the Maintenance.DiscoveryInterfaces globs (path.Match syntax) applied on top of the automatic rules.
+type InterfaceRules struct {
+	Include []string // when non-empty, only matching interfaces are used; a match skips the Ethernet/bridge checks
+	Exclude []string // checked first; a match always rejects
 }
 
-const netDir = "/sys/class/net"
+var (
+	rulesMu sync.RWMutex
+	rules   InterfaceRules
+)
 
-// includeInterfaceForDiscovery reports whether iface should be used for BM-style broadcast discovery.
-// Rules (aligned with brd_for_bm.sh / PRD §3.1.1): skip lo; sysfs type must be 1 (ARPHRD_ETHER);
-// if brif/ exists (bridge master), require at least one slave in brif/ (exclude empty internal bridges).
-// No name-based filtering (docker*, veth*, etc.).
-func includeInterfaceForDiscovery(name string) bool {
+// SetDiscoveryInterfaceRules sets the rules used by brd collection and the IPv4/IPv6 discovery interface lists (process-wide).
+func SetDiscoveryInterfaceRules(r InterfaceRules) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	rules = InterfaceRules{Include: append([]string(nil), r.Include...), Exclude: append([]string(nil), r.Exclude...)}
+}
+
+func currentRules() InterfaceRules {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	return rules
+}
+
+// InterfaceDecision is why an interface is used for discovery or not, and its IPv4 broadcast addresses when used.
+type InterfaceDecision struct {
+	Iface    string
+	Included bool
+	Rule     string   // e.g. auto: Ethernet, Include "bond*", Exclude "docker*", not Ethernet (type 772)
+	Brds     []string // Included only; empty when the interface has no IPv4 address with a broadcast
+}
+
+// decideInterface applies, in order: loopback, Exclude, Include, then the automatic rules (aligned with brd_for_bm.sh / PRD §3.1.1):
+// sysfs type must be 1 (ARPHRD_ETHER); if brif/ exists (bridge master), require at least one slave in brif/ (exclude empty internal bridges).
+// Without DiscoveryInterfaces there is no name-based filtering (docker*, veth*, etc.).
+func decideInterface(name string, r InterfaceRules) (bool, string) {
 	if name == "lo" {
-		return false
+		return false, "loopback"
 	}
-	typePath := filepath.Join(netDir, name, "type")
-	data, err := os.ReadFile(typePath)
+	for _, pat := range r.Exclude {
+		if ok, _ := path.Match(pat, name); ok {
+			return false, fmt.Sprintf("Exclude %q", pat)
+		}
+	}
+	if len(r.Include) > 0 {
+		for _, pat := range r.Include {
+			if ok, _ := path.Match(pat, name); ok {
+				return true, fmt.Sprintf("Include %q", pat)
+			}
+		}
+		return false, "not matched by Include"
+	}
+	data, err := os.ReadFile(filepath.Join(netDir, name, "type"))
 	if err != nil {
-		return false
+		return false, "no sysfs type"
 	}
-	if strings.TrimSpace(string(data)) != "1" {
-		return false
+	if t := strings.TrimSpace(string(data)); t != "1" {
+		return false, fmt.Sprintf("not Ethernet (type %s)", t)
 	}
 	brifPath := filepath.Join(netDir, name, "brif")
 	if fi, err := os.Stat(brifPath); err == nil && fi.IsDir() {
 		ents, err := os.ReadDir(brifPath)
 		if err != nil || len(ents) == 0 {
-			return false
+			return false, "bridge without ports"
 		}
 	}
-	return true
+	return true, "auto: Ethernet"
 }
 
-// getInterfaceBrdPairs runs "ip -o -4 addr show" per included interface and returns (iface, brd) pairs.
-// Duplicate brd on the same interface is collapsed; the same brd on different interfaces appears as multiple pairs.
-func getInterfaceBrdPairs() []NicBrdPair {
+// includeInterfaceForDiscovery reports whether iface should be used for BM-style broadcast discovery (decideInterface with the current rules).
+func includeInterfaceForDiscovery(name string) bool {
+	ok, _ := decideInterface(name, currentRules())
+	return ok
+}
+
+// DiscoveryInterfaceIncluded reports whether the interface name passes the discovery rules (decideInterface with the
+// current rules); the per-IP discovery sockets use it. Outside Linux there is no sysfs type, so only loopback,
+// Exclude and Include decide.
+func DiscoveryInterfaceIncluded(name string) bool {
+	ok, rule := decideInterface(name, currentRules())
+	if !ok && rule == "no sysfs type" && runtime.GOOS != "linux" {
+		return true
+	}
+	return ok
+}
+
+// ipv4Broadcasts computes the directed broadcast of each IPv4 address on iface (address | ^mask), without shelling out to ip(8).
+// /31 and /32 have no broadcast. Duplicates on the same interface are collapsed.
+func ipv4Broadcasts(name string) []string {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+	var out []string
+	seen := make(map[string]struct{})
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip4 := ipnet.IP.To4()
+		mask := ipnet.Mask
+		if len(mask) == net.IPv6len {
+			mask = mask[12:]
+		}
+		if ip4 == nil || len(mask) != net.IPv4len {
+			continue
+		}
+		if ones, _ := mask.Size(); ones >= 31 {
+			continue
+		}
+		brd := make(net.IP, net.IPv4len)
+		for i := range brd {
+			brd[i] = ip4[i] | ^mask[i]
+		}
+		key := brd.String()
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = struct{}{}
+		out = append(out, key)
+	}
+	return out
+}
+
+// DiscoveryInterfaceDecisions returns the decision for every interface in /sys/class/net (name order). For CLI --nic-brd.
+func DiscoveryInterfaceDecisions() []InterfaceDecision {
 	if runtime.GOOS != "linux" {
 		return nil
 	}
@@ -59,43 +151,30 @@ func getInterfaceBrdPairs() []NicBrdPair {
 	if err != nil {
 		return nil
 	}
-	var pairs []NicBrdPair
-	ipCmd := ipPath()
+	r := currentRules()
+	var out []InterfaceDecision
 	for _, e := range entries {
 		name := e.Name()
 		if name == "." || name == ".." {
 			continue
 		}
-		if !includeInterfaceForDiscovery(name) {
-			continue
+		ok, rule := decideInterface(name, r)
+		d := InterfaceDecision{Iface: name, Included: ok, Rule: rule}
+		if ok {
+			d.Brds = ipv4Broadcasts(name)
 		}
-		cmd := exec.Command(ipCmd, "-o", "-4", "addr", "show", "dev", name)
-		out, err := cmd.Output()
-		if err != nil {
-			continue
-		}
-		lines := strings.Split(strings.TrimSpace(string(out)), "\n")
-		seenBrdOnIface := make(map[string]struct{})
-		for _, line := range lines {
-			if line == "" || !strings.Contains(line, "brd") {
-				continue
-			}
-			fields := strings.Fields(line)
-			for i := 0; i < len(fields)-1; i++ {
-				if fields[i] != "brd" {
-					continue
-				}
-				brd := strings.TrimSpace(fields[i+1])
-				if brd == "" || brd == "0.0.0.0" {
-					break
-				}
-				if _, dup := seenBrdOnIface[brd]; dup {
-					break
-				}
-				seenBrdOnIface[brd] = struct{}{}
-				pairs = append(pairs, NicBrdPair{Iface: name, Brd: brd})
-				break
-			}
+		out = append(out, d)
+	}
+	return out
+}
+
+// getInterfaceBrdPairs returns (iface, brd) pairs for the included interfaces.
+// Duplicate brd on the same interface is collapsed; the same brd on different interfaces appears as multiple pairs.
+func getInterfaceBrdPairs() []NicBrdPair {
+	var pairs []NicBrdPair
+	for _, d := range DiscoveryInterfaceDecisions() {
+		for _, brd := range d.Brds {
+			pairs = append(pairs, NicBrdPair{Iface: d.Iface, Brd: brd})
 		}
 	}
 	return pairs
@@ -127,3 +206,50 @@ type NicBrdPair struct {
 func GetPhysicalNICBrdPairs() []NicBrdPair {
 	return getInterfaceBrdPairs()
 }
+
+// GetIPv6DiscoveryInterfaces returns interfaces that pass the same inclusion rules as brd collection
+// (includeInterfaceForDiscovery) and are up, multicast-capable and have an IPv6 link-local address.
+// These are the interfaces that join / send to the IPv6 discovery group.
+func GetIPv6DiscoveryInterfaces() []string {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	entries, err := os.ReadDir(netDir)
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if !includeInterfaceForDiscovery(name) {
+			continue
+		}
+		ifi, err := net.InterfaceByName(name)
+		if err != nil || ifi.Flags&net.FlagUp == 0 || ifi.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		addrs, _ := ifi.Addrs()
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() == nil && ipnet.IP.IsLinkLocalUnicast() {
+				out = append(out, name)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// GetIPv4DiscoveryInterfaces returns the distinct interfaces that have at least one brd address
+// (same rules as GetPhysicalNICBrdPairs). Used to join the IPv4 multicast group in multicast discovery mode.
+func GetIPv4DiscoveryInterfaces() []string {
+	seen := make(map[string]struct{})
+	var out []string
+	for _, p := range getInterfaceBrdPairs() {
+		if _, ok := seen[p.Iface]; ok {
+			continue
+		}
+		seen[p.Iface] = struct{}{}
+		out = append(out, p.Iface)
+	}
+	return out
+}
@@ -0,0 +1,234 @@
+package hostinfo
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CPUSample is CPU usage over one sampler interval, from /proc/stat deltas. Percentages are of all CPU time.
+type CPUSample struct {
+	Time            time.Time `json:"time"`
+	IntervalSeconds float64   `json:"interval_seconds"`
+	UsagePercent    float64   `json:"usage_percent"` // everything but idle; iowait counts as busy
+	UserPercent     float64   `json:"user_percent"`  // user + nice
+	SystemPercent   float64   `json:"system_percent"`
+	IOWaitPercent   float64   `json:"iowait_percent"`
+	IRQPercent      float64   `json:"irq_percent"`
+	SoftIRQPercent  float64   `json:"softirq_percent"`
+	StealPercent    float64   `json:"steal_percent"`
+	PerCore         []float64 `json:"per_core_usage_percent"` // by CPU number; offline CPUs are left out
+}
+
+const (
+	// cpuSampleInterval is the sampler period; the first sample covers only cpuFirstSampleDelay so the value is ready soon after start.
+	cpuSampleInterval   = time.Second
+	cpuFirstSampleDelay = 200 * time.Millisecond
+	// cpuRingSize samples are kept (one minute at cpuSampleInterval).
+	cpuRingSize = 60
+)
+
+var cpuSampler struct {
+	once  sync.Once
+	ready chan struct{} // closed after the first sample
+
+	mu    sync.RWMutex
+	ring  [cpuRingSize]CPUSample
+	next  int // ring index the next sample goes to
+	count int
+}
+
+// StartCPUSampler starts the process-wide background CPU sampler (idempotent; no-op when not on Linux).
+// The service starts it at boot; CurrentCPU starts it on first use otherwise (CLI).
+func StartCPUSampler() {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	cpuSampler.once.Do(func() {
+		cpuSampler.ready = make(chan struct{})
+		go runCPUSampler()
+	})
+}
+
+// CurrentCPU returns the latest sample without blocking, except right after the sampler starts, when it waits for the
+// first sample (cpuFirstSampleDelay). ok is false when not on Linux or /proc/stat cannot be read.
+func CurrentCPU() (s CPUSample, ok bool) {
+	if runtime.GOOS != "linux" {
+		return CPUSample{}, false
+	}
+	StartCPUSampler()
+	select {
+	case <-cpuSampler.ready:
+	case <-time.After(2 * cpuFirstSampleDelay):
+	}
+	cpuSampler.mu.RLock()
+	defer cpuSampler.mu.RUnlock()
+	if cpuSampler.count == 0 {
+		return CPUSample{}, false
+	}
+	return cpuSampler.ring[(cpuSampler.next+cpuRingSize-1)%cpuRingSize], true
+}
+
+// CPUHistory returns the samples in the ring buffer, oldest first.
+func CPUHistory() []CPUSample {
+	cpuSampler.mu.RLock()
+	defer cpuSampler.mu.RUnlock()
+	out := make([]CPUSample, 0, cpuSampler.count)
+	for i := cpuSampler.count; i > 0; i-- {
+		out = append(out, cpuSampler.ring[(cpuSampler.next+cpuRingSize-i)%cpuRingSize])
+	}
+	return out
+}
+
+func runCPUSampler() {
+	prev, prevAt, err := readCPUTimes()
+	time.Sleep(cpuFirstSampleDelay)
+	for first := true; ; first = false {
+		cur, now, curErr := readCPUTimes()
+		if err == nil && curErr == nil {
+			s := cpuSampleBetween(prev, cur)
+			s.Time, s.IntervalSeconds = now, now.Sub(prevAt).Seconds()
+			cpuSampler.mu.Lock()
+			cpuSampler.ring[cpuSampler.next] = s
+			cpuSampler.next = (cpuSampler.next + 1) % cpuRingSize
+			cpuSampler.count = min(cpuSampler.count+1, cpuRingSize)
+			cpuSampler.mu.Unlock()
+		}
+		if first {
+			close(cpuSampler.ready) // even on error: CurrentCPU must not wait again
+		}
+		prev, prevAt, err = cur, now, curErr
+		time.Sleep(cpuSampleInterval)
+	}
+}
+
+// CPUCounters are the cumulative /proc/stat "cpu" times since boot, in clock ticks. The difference of two readings is
+// the exact usage between them however far apart they are; the sampler ring only covers the last minute.
+type CPUCounters struct {
+	Total  uint64
+	Busy   uint64 // everything but idle, as UsagePercent
+	IOWait uint64
+	Steal  uint64
+}
+
+// ReadCPUCounters reads the aggregate counters now; ok is false when not on Linux or /proc/stat cannot be read.
+func ReadCPUCounters() (c CPUCounters, ok bool) {
+	if runtime.GOOS != "linux" {
+		return CPUCounters{}, false
+	}
+	t, _, err := readCPUTimes()
+	if err != nil {
+		return CPUCounters{}, false
+	}
+	total := sumTimes(t.all)
+	return CPUCounters{Total: total, Busy: total - t.all[ctIdle], IOWait: t.all[ctIOWait], Steal: t.all[ctSteal]}, true
+}
+
+// cpuTimes is one /proc/stat cpu line: user nice system idle iowait irq softirq steal (guest time is already in user).
+type cpuTimes [8]uint64
+
+const (
+	ctUser = iota
+	ctNice
+	ctSystem
+	ctIdle
+	ctIOWait
+	ctIRQ
+	ctSoftIRQ
+	ctSteal
+)
+
+// procCPUTimes holds the aggregate "cpu" line and the per-CPU "cpuN" lines keyed by N.
+type procCPUTimes struct {
+	all   cpuTimes
+	cores map[int]cpuTimes
+}
+
+func readCPUTimes() (procCPUTimes, time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return procCPUTimes{}, time.Time{}, err
+	}
+	defer f.Close()
+	now := time.Now()
+	out := procCPUTimes{cores: make(map[int]cpuTimes)}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		var t cpuTimes
+		for i := 1; i < len(fields) && i <= len(t); i++ {
+			t[i-1], _ = strconv.ParseUint(fields[i], 10, 64)
+		}
+		if fields[0] == "cpu" {
+			out.all = t
+		} else if n, err := strconv.Atoi(fields[0][3:]); err == nil {
+			out.cores[n] = t
+		}
+	}
+	return out, now, s.Err()
+}
+
+func cpuSampleBetween(prev, cur procCPUTimes) CPUSample {
+	d := delta(prev.all, cur.all)
+	total := sumTimes(d)
+	pct := func(v uint64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return 100 * float64(v) / float64(total)
+	}
+	s := CPUSample{
+		UsagePercent:   pct(total - d[ctIdle]),
+		UserPercent:    pct(d[ctUser] + d[ctNice]),
+		SystemPercent:  pct(d[ctSystem]),
+		IOWaitPercent:  pct(d[ctIOWait]),
+		IRQPercent:     pct(d[ctIRQ]),
+		SoftIRQPercent: pct(d[ctSoftIRQ]),
+		StealPercent:   pct(d[ctSteal]),
+		PerCore:        []float64{},
+	}
+	ids := make([]int, 0, len(cur.cores))
+	for n := range cur.cores {
+		ids = append(ids, n)
+	}
+	slices.Sort(ids)
+	for _, n := range ids {
+		c := cur.cores[n]
+		var usage float64
+		if p, ok := prev.cores[n]; ok {
+			dc := delta(p, c)
+			if t := sumTimes(dc); t > 0 {
+				usage = 100 * float64(t-dc[ctIdle]) / float64(t)
+			}
+		}
+		s.PerCore = append(s.PerCore, usage)
+	}
+	return s
+}
+
+// delta is cur - prev per field; a field that went backwards (CPU hot-unplugged and back) counts as 0.
+func delta(prev, cur cpuTimes) cpuTimes {
+	var d cpuTimes
+	for i := range d {
+		if cur[i] > prev[i] {
+			d[i] = cur[i] - prev[i]
+		}
+	}
+	return d
+}
+
+func sumTimes(t cpuTimes) uint64 {
+	var sum uint64
+	for _, v := range t {
+		sum += v
+	}
+	return sum
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package hostinfo
+
+import "syscall"
+
+// diskUsage statfs's every real filesystem (realMounts). Network filesystems are never touched, so a hung NFS server cannot block it.
+func diskUsage() []DiskUsage {
+	out := []DiskUsage{}
+	for _, m := range realMounts() {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(m.mount, &st); err != nil || st.Blocks == 0 {
+			continue
+		}
+		bsize := uint64(st.Bsize)
+		d := DiskUsage{
+			Mount:       m.mount,
+			Device:      m.source,
+			FSType:      m.fsType,
+			TotalMB:     st.Blocks * bsize >> 20,
+			UsedMB:      (st.Blocks - st.Bfree) * bsize >> 20,
+			AvailableMB: st.Bavail * bsize >> 20,
+			Inodes:      st.Files,
+			InodesUsed:  st.Files - st.Ffree,
+		}
+		if used, avail := st.Blocks-st.Bfree, st.Bavail; used+avail > 0 {
+			d.UsagePercent = 100 * float64(used) / float64(used+avail)
+		}
+		if st.Files > 0 {
+			d.InodesUsagePercent = 100 * float64(d.InodesUsed) / float64(st.Files)
+		}
+		out = append(out, d)
+	}
+	return out
+}
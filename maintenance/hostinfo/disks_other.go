@@ -0,0 +1,7 @@
+//go:build !linux
+
+package hostinfo
+
+func diskUsage() []DiskUsage {
+	return []DiskUsage{}
+}
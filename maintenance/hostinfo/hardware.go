@@ -0,0 +1,332 @@
+package hostinfo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hardware is the asset inventory returned as "hardware" by GET /self and /host-info: DMI (SMBIOS) identity from
+// /sys/class/dmi/id, CPU topology from /sys/devices/system/cpu and memory size. Linux only. DMI fields are empty
+// where the firmware leaves placeholders ("To be filled by O.E.M.") or the host has no DMI (most ARM boards, some VMs).
+// The serial files are readable by root only, so serials are empty when the agent runs unprivileged.
+type Hardware struct {
+	System  HardwareSystem  `json:"system"`
+	Chassis HardwareChassis `json:"chassis"`
+	BIOS    HardwareBIOS    `json:"bios"`
+	Board   HardwareBoard   `json:"board"`
+	CPU     HardwareCPU     `json:"cpu"`
+	Memory  HardwareMemory  `json:"memory"`
+	// SerialRedaction is how the serial fields were treated (Maintenance.Hardware.SerialRedaction): none, omit or hash.
+	SerialRedaction string `json:"serial_redaction"`
+}
+
+// HardwareSystem is the SMBIOS system (type 1) record.
+type HardwareSystem struct {
+	Vendor  string `json:"vendor"`
+	Product string `json:"product"`
+	Version string `json:"version,omitempty"`
+	Family  string `json:"family,omitempty"`
+	SKU     string `json:"sku,omitempty"`
+	Serial  string `json:"serial,omitempty"`
+	UUID    string `json:"uuid,omitempty"` // not redacted: it is already the discovery cpu_uuid when present
+}
+
+// HardwareChassis is the SMBIOS chassis (type 3) record. Type is the SMBIOS name for TypeCode ("Rack Mount Chassis").
+type HardwareChassis struct {
+	Type     string `json:"type"`
+	TypeCode int    `json:"type_code"`
+	Vendor   string `json:"vendor,omitempty"`
+	Version  string `json:"version,omitempty"`
+	Serial   string `json:"serial,omitempty"`
+	AssetTag string `json:"asset_tag,omitempty"`
+}
+
+// HardwareBIOS is the SMBIOS BIOS (type 0) record. Date is as the firmware reports it (usually MM/DD/YYYY).
+type HardwareBIOS struct {
+	Vendor  string `json:"vendor"`
+	Version string `json:"version"`
+	Date    string `json:"date"`
+	Release string `json:"release,omitempty"`
+}
+
+// HardwareBoard is the SMBIOS baseboard (type 2) record.
+type HardwareBoard struct {
+	Vendor   string `json:"vendor"`
+	Name     string `json:"name"`
+	Version  string `json:"version,omitempty"`
+	Serial   string `json:"serial,omitempty"`
+	AssetTag string `json:"asset_tag,omitempty"`
+}
+
+// HardwareCPU is the processor model and topology.
+type HardwareCPU struct {
+	Model          string     `json:"model"`
+	Vendor         string     `json:"vendor,omitempty"` // /proc/cpuinfo vendor_id (x86) or CPU implementer (ARM)
+	Sockets        int        `json:"sockets"`
+	Cores          int        `json:"cores"`
+	Logical        int        `json:"logical"` // online
+	ThreadsPerCore int        `json:"threads_per_core"`
+	Online         string     `json:"online"`   // kernel CPU list, e.g. "0-15"
+	Possible       string     `json:"possible"` // hot-pluggable CPUs included
+	NUMANodes      int        `json:"numa_nodes"`
+	MaxMHz         int        `json:"max_mhz,omitempty"` // cpufreq; absent in most VMs
+	Caches         []CPUCache `json:"caches"`
+}
+
+// CPUCache is one cache level of CPU 0 (index order: L1d, L1i, L2, L3).
+type CPUCache struct {
+	Level  int    `json:"level"`
+	Type   string `json:"type"` // Data, Instruction or Unified
+	SizeKB int    `json:"size_kb"`
+	// SharedBy is how many logical CPUs share one instance of this cache.
+	SharedBy int `json:"shared_by"`
+}
+
+// HardwareMemory is the memory size. PhysicalMB counts the online memory blocks in /sys/devices/system/memory,
+// which is close to the installed size; TotalMB is what the kernel can use (MemTotal, after firmware and kernel reservations).
+type HardwareMemory struct {
+	TotalMB    uint64 `json:"total_mb"`
+	PhysicalMB uint64 `json:"physical_mb,omitempty"`
+}
+
+// Serial redaction modes (Maintenance.Hardware.SerialRedaction).
+const (
+	SerialRedactionNone = "none"
+	SerialRedactionOmit = "omit" // serial fields are left empty
+	SerialRedactionHash = "hash" // "hmac-sha256:" and the first 16 hex digits, keyed: stable for matching within a deployment
+)
+
+// hardwareTTL: Get runs for every DISCOVERY_REQUEST answered; the hardware barely changes (CPU or memory hotplug).
+const hardwareTTL = time.Minute
+
+var hardwareCache struct {
+	mu        sync.Mutex
+	redaction string
+	key       []byte // HMAC key of SerialRedactionHash
+	at        time.Time
+	hw        *Hardware
+}
+
+// SetSerialRedaction sets how GetHardware reports serial numbers (process-wide): none, omit or hash with key
+// (config.ResolveSerialHashKey). Unknown modes, and hash without a key, mean omit.
+func SetSerialRedaction(mode, key string) {
+	hardwareCache.mu.Lock()
+	defer hardwareCache.mu.Unlock()
+	hardwareCache.redaction, hardwareCache.key = mode, []byte(key)
+	hardwareCache.hw = nil
+}
+
+// GetHardware returns the hardware inventory, collected at most every hardwareTTL. nil when not on Linux.
+// The result is shared; callers must not modify it.
+func GetHardware() *Hardware {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	hardwareCache.mu.Lock()
+	defer hardwareCache.mu.Unlock()
+	if hardwareCache.hw != nil && time.Since(hardwareCache.at) < hardwareTTL {
+		return hardwareCache.hw
+	}
+	hw := collectHardware()
+	redactSerials(hw, hardwareCache.redaction, hardwareCache.key)
+	hardwareCache.hw, hardwareCache.at = hw, time.Now()
+	return hw
+}
+
+const dmiDir = "/sys/class/dmi/id"
+
+func collectHardware() *Hardware {
+	dmi := func(name string) string { return dmiValue(readTrimmedFile(filepath.Join(dmiDir, name))) }
+	hw := &Hardware{
+		System: HardwareSystem{
+			Vendor:  dmi("sys_vendor"),
+			Product: dmi("product_name"),
+			Version: dmi("product_version"),
+			Family:  dmi("product_family"),
+			SKU:     dmi("product_sku"),
+			Serial:  dmi("product_serial"),
+		},
+		Chassis: HardwareChassis{
+			Vendor:   dmi("chassis_vendor"),
+			Version:  dmi("chassis_version"),
+			Serial:   dmi("chassis_serial"),
+			AssetTag: dmi("chassis_asset_tag"),
+		},
+		BIOS: HardwareBIOS{
+			Vendor:  dmi("bios_vendor"),
+			Version: dmi("bios_version"),
+			Date:    dmi("bios_date"),
+			Release: dmi("bios_release"),
+		},
+		Board: HardwareBoard{
+			Vendor:   dmi("board_vendor"),
+			Name:     dmi("board_name"),
+			Version:  dmi("board_version"),
+			Serial:   dmi("board_serial"),
+			AssetTag: dmi("board_asset_tag"),
+		},
+	}
+	if v := readTrimmedFile(filepath.Join(dmiDir, "product_uuid")); !uselessHostID(v) {
+		hw.System.UUID = strings.ToLower(v)
+	}
+	if code, err := strconv.Atoi(readTrimmedFile(filepath.Join(dmiDir, "chassis_type"))); err == nil {
+		hw.Chassis.TypeCode, hw.Chassis.Type = code, chassisTypeName(code)
+	}
+	// Without DMI, device-tree boards still name themselves (and sometimes carry a serial).
+	if hw.System.Product == "" {
+		hw.System.Product = strings.TrimRight(readTrimmedFile("/proc/device-tree/model"), "\x00")
+		if hw.System.Serial == "" {
+			hw.System.Serial = dmiValue(strings.TrimRight(readTrimmedFile("/proc/device-tree/serial-number"), "\x00"))
+		}
+	}
+	hw.CPU = hardwareCPU()
+	hw.Memory.TotalMB, _, _, _ = memoryLinux()
+	hw.Memory.PhysicalMB = physicalMemoryMB()
+	return hw
+}
+
+// dmiValue drops the placeholders vendors leave in unset SMBIOS strings.
+func dmiValue(v string) string {
+	v = strings.TrimSpace(strings.Trim(v, "\x00"))
+	switch strings.ToLower(v) {
+	case "", "none", "n/a", "na", "unknown", "default string", "not specified", "not applicable", "not present",
+		"to be filled by o.e.m.", "to be filled by oem", "system product name", "system manufacturer",
+		"system serial number", "system version", "chassis serial number", "base board serial number",
+		"0123456789", "123456789", "0":
+		return ""
+	}
+	return v
+}
+
+func redactSerials(hw *Hardware, mode string, key []byte) {
+	switch {
+	case mode == "" || mode == SerialRedactionNone:
+		hw.SerialRedaction = SerialRedactionNone
+		return
+	case mode == SerialRedactionHash && len(key) > 0:
+		hw.SerialRedaction = SerialRedactionHash
+	default:
+		hw.SerialRedaction = SerialRedactionOmit
+	}
+	for _, s := range []*string{&hw.System.Serial, &hw.Chassis.Serial, &hw.Board.Serial} {
+		if *s == "" {
+			continue
+		}
+		if hw.SerialRedaction == SerialRedactionHash {
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(*s))
+			*s = "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil)[:8])
+		} else {
+			*s = ""
+		}
+	}
+}
+
+func hardwareCPU() HardwareCPU {
+	const cpuDir = "/sys/devices/system/cpu"
+	c := HardwareCPU{
+		Online:   readTrimmedFile(filepath.Join(cpuDir, "online")),
+		Possible: readTrimmedFile(filepath.Join(cpuDir, "possible")),
+		Caches:   []CPUCache{},
+	}
+	c.Model, _ = cpuInfoLinux()
+	c.Vendor = cpuVendorLinux()
+	c.Logical, c.Cores, c.Sockets = cpuTopologyLinux()
+	if c.Cores > 0 {
+		c.ThreadsPerCore = c.Logical / c.Cores
+	}
+	if nodes, _ := filepath.Glob("/sys/devices/system/node/node[0-9]*"); len(nodes) > 0 {
+		c.NUMANodes = len(nodes)
+	} else {
+		c.NUMANodes = 1
+	}
+	if khz, err := strconv.Atoi(readTrimmedFile(filepath.Join(cpuDir, "cpu0", "cpufreq", "cpuinfo_max_freq"))); err == nil {
+		c.MaxMHz = khz / 1000
+	}
+	indexes, _ := filepath.Glob(filepath.Join(cpuDir, "cpu0", "cache", "index[0-9]*"))
+	for _, dir := range indexes {
+		level, err := strconv.Atoi(readTrimmedFile(filepath.Join(dir, "level")))
+		if err != nil {
+			continue
+		}
+		c.Caches = append(c.Caches, CPUCache{
+			Level:    level,
+			Type:     readTrimmedFile(filepath.Join(dir, "type")),
+			SizeKB:   parseCacheSizeKB(readTrimmedFile(filepath.Join(dir, "size"))),
+			SharedBy: len(parseCPUList(readTrimmedFile(filepath.Join(dir, "shared_cpu_list")))),
+		})
+	}
+	return c
+}
+
+// cpuVendorLinux is the first vendor_id (x86) or CPU implementer (ARM, hex code) in /proc/cpuinfo.
+func cpuVendorLinux() string {
+	data, _ := os.ReadFile("/proc/cpuinfo")
+	for _, line := range strings.Split(string(data), "\n") {
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(k) {
+		case "vendor_id", "CPU implementer":
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// parseCacheSizeKB parses sysfs cache sizes ("48K", "2048K", "32M").
+func parseCacheSizeKB(s string) int {
+	mult := 1
+	switch {
+	case strings.HasSuffix(s, "K"):
+		s = strings.TrimSuffix(s, "K")
+	case strings.HasSuffix(s, "M"):
+		s, mult = strings.TrimSuffix(s, "M"), 1024
+	}
+	n, _ := strconv.Atoi(s)
+	return n * mult
+}
+
+// physicalMemoryMB sums the online memory blocks; 0 without /sys/devices/system/memory.
+func physicalMemoryMB() uint64 {
+	const memDir = "/sys/devices/system/memory"
+	blockSize, err := strconv.ParseUint(readTrimmedFile(filepath.Join(memDir, "block_size_bytes")), 16, 64)
+	if err != nil || blockSize == 0 {
+		return 0
+	}
+	blocks, _ := filepath.Glob(filepath.Join(memDir, "memory[0-9]*"))
+	var online uint64
+	for _, b := range blocks {
+		if readTrimmedFile(filepath.Join(b, "state")) == "online" {
+			online++
+		}
+	}
+	return online * blockSize >> 20
+}
+
+// chassisTypeName names SMBIOS chassis type codes (DSP0134 7.4.1); the lock bit (0x80) is ignored.
+func chassisTypeName(code int) string {
+	names := [...]string{
+		1: "Other", 2: "Unknown", 3: "Desktop", 4: "Low Profile Desktop", 5: "Pizza Box", 6: "Mini Tower", 7: "Tower",
+		8: "Portable", 9: "Laptop", 10: "Notebook", 11: "Hand Held", 12: "Docking Station", 13: "All in One",
+		14: "Sub Notebook", 15: "Space-saving", 16: "Lunch Box", 17: "Main Server Chassis", 18: "Expansion Chassis",
+		19: "SubChassis", 20: "Bus Expansion Chassis", 21: "Peripheral Chassis", 22: "RAID Chassis",
+		23: "Rack Mount Chassis", 24: "Sealed-case PC", 25: "Multi-system chassis", 26: "Compact PCI", 27: "Advanced TCA",
+		28: "Blade", 29: "Blade Enclosure", 30: "Tablet", 31: "Convertible", 32: "Detachable", 33: "IoT Gateway",
+		34: "Embedded PC", 35: "Mini PC", 36: "Stick PC",
+	}
+	code &^= 0x80
+	if code > 0 && code < len(names) {
+		return names[code]
+	}
+	return "Unknown"
+}
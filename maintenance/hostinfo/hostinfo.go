@@ -8,10 +8,9 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"time"
 )
 
-// Info holds host information (CPU, memory, hostname, IP).
+// Info holds host information (CPU, memory, hostname, IP, extended inventory).
 type Info struct {
 	Hostname             string   `json:"hostname"`
 	HostIP               string   `json:"host_ip"`
@@ -22,6 +21,16 @@ type Info struct {
 	MemoryTotalMB        uint64  `json:"memory_total_mb"`
 	MemoryUsedMB         uint64  `json:"memory_used_mb"`
 	MemoryUsagePercent   float64 `json:"memory_usage_percent"`
+	// CPU is the latest background sample (breakdown and per-core usage); nil when not on Linux.
+	CPU *CPUSample `json:"cpu,omitempty"`
+	// Inventory, Network and Hardware are left nil by Get, which also serves every UDP answer; /self and host-info
+	// attach GetInventory, GetNetwork and GetHardware. All three are nil when not on Linux.
+	// Inventory is the extended inventory (load, uptime, OS, CPU topology, swap, disks).
+	Inventory *Inventory `json:"inventory,omitempty"`
+	// Network lists every interface with link state, addresses and traffic rates.
+	Network *Network `json:"network,omitempty"`
+	// Hardware is the DMI/sysfs asset inventory (vendor, product, serials, BIOS, board, CPU topology, memory).
+	Hardware *Hardware `json:"hardware,omitempty"`
 }
 
 // Get returns host info. Linux uses /proc; other OSes return best-effort.
@@ -32,7 +41,9 @@ func Get() (Info, error) {
 	h.HostIP = primaryIPv4()
 	if runtime.GOOS == "linux" {
 		h.CPUInfo, _ = cpuInfoLinux()
-		h.CPUUsagePercent, _ = cpuUsagePercentLinux()
+		if cpu, ok := CurrentCPU(); ok {
+			h.CPUUsagePercent, h.CPU = cpu.UsagePercent, &cpu
+		}
 		h.CPUUUID, _ = cpuUUIDLinux()
 		h.MemoryTotalMB, h.MemoryUsedMB, h.MemoryUsagePercent, _ = memoryLinux()
 	}
@@ -159,43 +170,6 @@ func readTrimmedFile(path string) string {
 	return strings.TrimSpace(string(b))
 }
 
-func cpuUsagePercentLinux() (float64, error) {
-	parse := func() (total, idle uint64, err error) {
-		data, err := os.ReadFile("/proc/stat")
-		if err != nil {
-			return 0, 0, err
-		}
-		line := strings.Split(string(data), "\n")[0]
-		fields := strings.Fields(line)
-		if len(fields) < 5 {
-			return 0, 0, nil
-		}
-		for i := 1; i < len(fields); i++ {
-			v, _ := strconv.ParseUint(fields[i], 10, 64)
-			total += v
-			if i == 4 {
-				idle = v
-			}
-		}
-		return total, idle, nil
-	}
-	t0, i0, err := parse()
-	if err != nil {
-		return 0, err
-	}
-	time.Sleep(200 * time.Millisecond)
-	t1, i1, err := parse()
-	if err != nil {
-		return 0, err
-	}
-	dt := t1 - t0
-	di := i1 - i0
-	if dt == 0 {
-		return 0, nil
-	}
-	return 100 * (1 - float64(di)/float64(dt)), nil
-}
-
 func memoryLinux() (totalMB, usedMB uint64, usagePercent float64, err error) {
 	data, err := os.ReadFile("/proc/meminfo")
 	if err != nil {
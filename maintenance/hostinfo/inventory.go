@@ -0,0 +1,312 @@
+package hostinfo
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Inventory is the extended host inventory returned by GET /self and /host-info?ip=self.
+// Linux only; fields stay zero when their source cannot be read.
+type Inventory struct {
+	LoadAverage1     float64     `json:"load_average_1"`
+	LoadAverage5     float64     `json:"load_average_5"`
+	LoadAverage15    float64     `json:"load_average_15"`
+	UptimeSeconds    uint64      `json:"uptime_seconds"`
+	BootTime         int64       `json:"boot_time"` // unix seconds
+	KernelVersion    string      `json:"kernel_version"`
+	OS               OSRelease   `json:"os"`
+	CPULogical       int         `json:"cpu_logical"` // online logical CPUs
+	CPUCores         int         `json:"cpu_cores"`   // physical cores (distinct package/core pairs)
+	CPUSockets       int         `json:"cpu_sockets"`
+	SwapTotalMB      uint64      `json:"swap_total_mb"`
+	SwapUsedMB       uint64      `json:"swap_used_mb"`
+	SwapUsagePercent float64     `json:"swap_usage_percent"`
+	Disks            []DiskUsage `json:"disks"`
+}
+
+// OSRelease holds the /etc/os-release fields (falls back to /usr/lib/os-release).
+type OSRelease struct {
+	ID              string `json:"id"`
+	IDLike          string `json:"id_like,omitempty"`
+	Name            string `json:"name"`
+	PrettyName      string `json:"pretty_name"`
+	Version         string `json:"version,omitempty"`
+	VersionID       string `json:"version_id"`
+	VersionCodename string `json:"version_codename,omitempty"`
+}
+
+// DiskUsage is one mounted real filesystem (block-device backed, plus zfs; one row per device).
+type DiskUsage struct {
+	Mount              string  `json:"mount"`
+	Device             string  `json:"device"`
+	FSType             string  `json:"fs_type"`
+	TotalMB            uint64  `json:"total_mb"`
+	UsedMB             uint64  `json:"used_mb"`
+	AvailableMB        uint64  `json:"available_mb"`
+	UsagePercent       float64 `json:"usage_percent"` // as df: used / (used + available)
+	Inodes             uint64  `json:"inodes"`
+	InodesUsed         uint64  `json:"inodes_used"`
+	InodesUsagePercent float64 `json:"inodes_usage_percent"` // 0 on filesystems without a fixed inode count (btrfs, zfs)
+}
+
+// inventoryTTL: Get runs for every DISCOVERY_REQUEST answered; a burst reuses one collection.
+const inventoryTTL = 5 * time.Second
+
+var inventoryCache struct {
+	mu  sync.Mutex
+	at  time.Time
+	inv *Inventory
+}
+
+// GetInventory returns the extended inventory, collected at most every inventoryTTL. nil when not on Linux.
+// The result is shared; callers must not modify it.
+func GetInventory() *Inventory {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	inventoryCache.mu.Lock()
+	defer inventoryCache.mu.Unlock()
+	if inventoryCache.inv != nil && time.Since(inventoryCache.at) < inventoryTTL {
+		return inventoryCache.inv
+	}
+	inv := collectInventory()
+	inventoryCache.inv, inventoryCache.at = inv, time.Now()
+	return inv
+}
+
+func collectInventory() *Inventory {
+	inv := &Inventory{}
+	if f := strings.Fields(readTrimmedFile("/proc/loadavg")); len(f) >= 3 {
+		inv.LoadAverage1, _ = strconv.ParseFloat(f[0], 64)
+		inv.LoadAverage5, _ = strconv.ParseFloat(f[1], 64)
+		inv.LoadAverage15, _ = strconv.ParseFloat(f[2], 64)
+	}
+	if f := strings.Fields(readTrimmedFile("/proc/uptime")); len(f) >= 1 {
+		up, _ := strconv.ParseFloat(f[0], 64)
+		inv.UptimeSeconds = uint64(up)
+	}
+	inv.BootTime = bootTimeLinux()
+	inv.KernelVersion = readTrimmedFile("/proc/sys/kernel/osrelease")
+	inv.OS = osReleaseLinux()
+	inv.CPULogical, inv.CPUCores, inv.CPUSockets = cpuTopologyLinux()
+	inv.SwapTotalMB, inv.SwapUsedMB, inv.SwapUsagePercent = swapLinux()
+	inv.Disks = diskUsage()
+	return inv
+}
+
+func bootTimeLinux() int64 {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if v, ok := strings.CutPrefix(s.Text(), "btime "); ok {
+			t, _ := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			return t
+		}
+	}
+	return 0
+}
+
+func osReleaseLinux() OSRelease {
+	data, err := os.ReadFile("/etc/os-release")
+	if err != nil {
+		data, _ = os.ReadFile("/usr/lib/os-release")
+	}
+	var r OSRelease
+	for _, line := range strings.Split(string(data), "\n") {
+		k, v, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		if uq, err := strconv.Unquote(v); err == nil {
+			v = uq
+		} else {
+			v = strings.Trim(v, `"'`)
+		}
+		switch k {
+		case "ID":
+			r.ID = v
+		case "ID_LIKE":
+			r.IDLike = v
+		case "NAME":
+			r.Name = v
+		case "PRETTY_NAME":
+			r.PrettyName = v
+		case "VERSION":
+			r.Version = v
+		case "VERSION_ID":
+			r.VersionID = v
+		case "VERSION_CODENAME":
+			r.VersionCodename = v
+		}
+	}
+	return r
+}
+
+// cpuTopologyLinux counts online CPUs and their distinct cores and packages from sysfs topology.
+// Without topology (some VMs and containers) every logical CPU counts as a core on one socket.
+func cpuTopologyLinux() (logical, cores, sockets int) {
+	online := parseCPUList(readTrimmedFile("/sys/devices/system/cpu/online"))
+	if len(online) == 0 {
+		online = make([]int, runtime.NumCPU())
+		for i := range online {
+			online[i] = i
+		}
+	}
+	logical = len(online)
+	coreSet := make(map[string]struct{})
+	pkgSet := make(map[string]struct{})
+	for _, cpu := range online {
+		dir := filepath.Join("/sys/devices/system/cpu", "cpu"+strconv.Itoa(cpu), "topology")
+		pkg := readTrimmedFile(filepath.Join(dir, "physical_package_id"))
+		core := readTrimmedFile(filepath.Join(dir, "core_id"))
+		if pkg == "" || core == "" {
+			return logical, logical, 1
+		}
+		pkgSet[pkg] = struct{}{}
+		coreSet[pkg+"/"+core] = struct{}{}
+	}
+	return logical, len(coreSet), len(pkgSet)
+}
+
+// parseCPUList parses a kernel CPU list ("0-3,6,8-9").
+func parseCPUList(s string) []int {
+	var out []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		a, err := strconv.Atoi(lo)
+		if err != nil {
+			return nil
+		}
+		b := a
+		if isRange {
+			if b, err = strconv.Atoi(hi); err != nil || b < a {
+				return nil
+			}
+		}
+		for i := a; i <= b; i++ {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+func swapLinux() (totalMB, usedMB uint64, usagePercent float64) {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, 0, 0
+	}
+	var total, free uint64
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		val, _ := strconv.ParseUint(fields[1], 10, 64)
+		switch fields[0] {
+		case "SwapTotal:":
+			total = val / 1024
+		case "SwapFree:":
+			free = val / 1024
+		}
+	}
+	if total == 0 {
+		return 0, 0, 0
+	}
+	usedMB = total - free
+	return total, usedMB, 100 * float64(usedMB) / float64(total)
+}
+
+// realFilesystem reports whether fsType is worth listing: filesystems /proc/filesystems does not mark "nodev"
+// (block-device backed), and zfs. squashfs (snap and live images, always full) is left out.
+func realFilesystem(fsType string, nodev map[string]bool) bool {
+	switch fsType {
+	case "zfs":
+		return true
+	case "squashfs", "iso9660":
+		return false
+	}
+	return fsType != "" && !nodev[fsType]
+}
+
+func nodevFilesystems() map[string]bool {
+	out := make(map[string]bool)
+	data, _ := os.ReadFile("/proc/filesystems")
+	for _, line := range strings.Split(string(data), "\n") {
+		if f := strings.Fields(line); len(f) == 2 && f[0] == "nodev" {
+			out[f[1]] = true
+		}
+	}
+	return out
+}
+
+// mountEntry is one /proc/self/mountinfo line.
+type mountEntry struct {
+	devID  string // major:minor
+	mount  string
+	fsType string
+	source string
+}
+
+// realMounts lists the real filesystems in mount order, one per device (bind mounts and btrfs subvolumes repeat the device).
+func realMounts() []mountEntry {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	nodev := nodevFilesystems()
+	seen := make(map[string]bool)
+	var out []mountEntry
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		pre, post, ok := strings.Cut(s.Text(), " - ")
+		if !ok {
+			continue
+		}
+		pf, qf := strings.Fields(pre), strings.Fields(post)
+		if len(pf) < 5 || len(qf) < 2 {
+			continue
+		}
+		e := mountEntry{devID: pf[2], mount: unescapeMountPath(pf[4]), fsType: qf[0], source: qf[1]}
+		if !realFilesystem(e.fsType, nodev) || seen[e.devID] {
+			continue
+		}
+		seen[e.devID] = true
+		out = append(out, e)
+	}
+	return out
+}
+
+// unescapeMountPath undoes the octal escapes mountinfo uses for space, tab, newline and backslash.
+func unescapeMountPath(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
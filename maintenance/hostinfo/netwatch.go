@@ -0,0 +1,106 @@
+package hostinfo
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// addressChangeSettle: events closer together than this are one change (a DHCP renew or link flap sends several).
+const addressChangeSettle = 2 * time.Second
+
+// addressPollInterval is the fallback when netlink is not available.
+const addressPollInterval = 30 * time.Second
+
+// WatchAddressChanges calls onChange after the host's addresses or links changed, until stop is closed. It blocks.
+// Bursts are coalesced: onChange runs once no event arrived for addressChangeSettle. Uses netlink (RTM_NEWADDR / RTM_DELADDR,
+// RTM_NEWLINK / RTM_DELLINK) on Linux; elsewhere, when the netlink socket cannot be opened, or once it fails, it polls the address list.
+func WatchAddressChanges(stop <-chan struct{}, onChange func()) {
+	events, err := subscribeAddressEvents(stop)
+	polling := err != nil
+	if polling {
+		log.Printf("hostinfo: netlink address watch unavailable (%v), polling every %s", err, addressPollInterval)
+		events = pollAddressEvents(stop)
+	}
+	settle := time.NewTimer(addressChangeSettle)
+	settle.Stop()
+	defer settle.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case _, ok := <-events:
+			if !ok {
+				if polling || isClosed(stop) {
+					return
+				}
+				// The netlink reader failed: poll from now on, and rescan once for what it may have missed.
+				log.Printf("hostinfo: netlink address watch stopped, polling every %s", addressPollInterval)
+				polling = true
+				events = pollAddressEvents(stop)
+			}
+			settle.Reset(addressChangeSettle)
+		case <-settle.C:
+			onChange()
+		}
+	}
+}
+
+// isClosed reports whether stop is closed.
+func isClosed(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// pollAddressEvents sends an event whenever the interface/address fingerprint changes.
+func pollAddressEvents(stop <-chan struct{}) <-chan struct{} {
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		last := addressFingerprint()
+		tick := time.NewTicker(addressPollInterval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+				if fp := addressFingerprint(); fp != last {
+					last = fp
+					select {
+					case events <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+	return events
+}
+
+// addressFingerprint is "name flags addr,addr" per interface, sorted.
+func addressFingerprint() string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+	var lines []string
+	for _, ifi := range ifaces {
+		addrs, _ := ifi.Addrs()
+		var as []string
+		for _, a := range addrs {
+			as = append(as, a.String())
+		}
+		sort.Strings(as)
+		lines = append(lines, fmt.Sprintf("%s %v %s", ifi.Name, ifi.Flags, strings.Join(as, ",")))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,84 @@
+//go:build linux
+
+package hostinfo
+
+import (
+	"log"
+	"syscall"
+	"time"
+)
+
+// rtnetlink multicast groups (linux/rtnetlink.h; not in the syscall package).
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+// subscribeAddressEvents joins the rtnetlink link and address groups and sends an event per RTM_NEWADDR, RTM_DELADDR,
+// RTM_NEWLINK or RTM_DELLINK. The channel is closed when stop is closed or the socket fails.
+func subscribeAddressEvents(stop <-chan struct{}) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+	sa := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr,
+	}
+	if err := syscall.Bind(fd, sa); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	// A blocked Recvfrom is not woken by Close; the timeout lets the reader notice stop.
+	tv := syscall.NsecToTimeval(int64(time.Second))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	events := make(chan struct{}, 1)
+	go func() {
+		defer close(events)
+		defer syscall.Close(fd)
+		buf := make([]byte, 1<<16)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			n, _, err := syscall.Recvfrom(fd, buf, 0)
+			if err != nil {
+				if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK || err == syscall.EINTR {
+					continue
+				}
+				if err == syscall.ENOBUFS {
+					// Kernel dropped messages (burst): something changed, rescan.
+					notify(events)
+					continue
+				}
+				log.Printf("hostinfo: netlink read: %v", err)
+				return
+			}
+			msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+			if err != nil {
+				continue
+			}
+			for _, m := range msgs {
+				switch m.Header.Type {
+				case syscall.RTM_NEWADDR, syscall.RTM_DELADDR, syscall.RTM_NEWLINK, syscall.RTM_DELLINK:
+					notify(events)
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+// notify sends on events without blocking (one pending event is enough).
+func notify(events chan<- struct{}) {
+	select {
+	case events <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package hostinfo
+
+import "errors"
+
+func subscribeAddressEvents(stop <-chan struct{}) (<-chan struct{}, error) {
+	return nil, errors.New("netlink is Linux only")
+}
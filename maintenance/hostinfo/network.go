@@ -0,0 +1,210 @@
+package hostinfo
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Network is the per-NIC section of GET /self and /host-info: every interface in /sys/class/net (name order).
+type Network struct {
+	// RateIntervalSeconds is the window the *_per_sec rates cover: the last interval of the background NIC sampler.
+	RateIntervalSeconds float64        `json:"rate_interval_seconds"`
+	Interfaces          []NetInterface `json:"interfaces"`
+}
+
+// NetInterface is one network interface with its counters from /sys/class/net/<name>/statistics.
+type NetInterface struct {
+	Name          string   `json:"name"`
+	MAC           string   `json:"mac,omitempty"`
+	MTU           int      `json:"mtu"`
+	OperState     string   `json:"operstate"`
+	SpeedMbps     int      `json:"speed_mbps,omitempty"` // omitted when the driver reports none (link down, most virtual NICs)
+	Duplex        string   `json:"duplex,omitempty"`
+	Driver        string   `json:"driver,omitempty"` // empty for virtual interfaces (no device/ link in sysfs)
+	IPv4          []string `json:"ipv4"`             // CIDR
+	IPv6          []string `json:"ipv6"`             // CIDR
+	Discovery     bool     `json:"discovery"`        // used for broadcast discovery (includeInterfaceForDiscovery)
+	DiscoveryRule string   `json:"discovery_rule"`   // the rule that decided Discovery, as in --nic-brd
+
+	RxBytes         uint64  `json:"rx_bytes"`
+	TxBytes         uint64  `json:"tx_bytes"`
+	RxPackets       uint64  `json:"rx_packets"`
+	TxPackets       uint64  `json:"tx_packets"`
+	RxErrors        uint64  `json:"rx_errors"`
+	TxErrors        uint64  `json:"tx_errors"`
+	RxBytesPerSec   float64 `json:"rx_bytes_per_sec"`
+	TxBytesPerSec   float64 `json:"tx_bytes_per_sec"`
+	RxPacketsPerSec float64 `json:"rx_packets_per_sec"`
+	TxPacketsPerSec float64 `json:"tx_packets_per_sec"`
+	RxErrorsPerSec  float64 `json:"rx_errors_per_sec"`
+	TxErrorsPerSec  float64 `json:"tx_errors_per_sec"`
+}
+
+// netStatFiles are the statistics/ files read per interface, in netCounters order.
+var netStatFiles = [...]string{"rx_bytes", "tx_bytes", "rx_packets", "tx_packets", "rx_errors", "tx_errors"}
+
+type netCounters [len(netStatFiles)]uint64
+
+// netSample is one reading of every interface's counters.
+type netSample struct {
+	at       time.Time
+	names    []string
+	counters map[string]netCounters
+}
+
+const (
+	// netSampleInterval is the NIC sampler period, the window of every rate; the first rates cover only
+	// netFirstSampleDelay so they are ready soon after start (same scheme as the CPU sampler).
+	netSampleInterval   = time.Second
+	netFirstSampleDelay = 200 * time.Millisecond
+)
+
+// netSampler keeps the last two counter readings. Rates come from the sampler's clock, never from the time between
+// callers, so neither a burst of requests nor a long gap between them changes the window.
+var netSampler struct {
+	once  sync.Once
+	ready chan struct{} // closed after the second reading (the first rates)
+
+	mu   sync.RWMutex
+	prev netSample
+	cur  netSample
+}
+
+// StartNetworkSampler starts the process-wide background NIC counter sampler (idempotent; no-op when not on Linux).
+// The service starts it at boot; GetNetwork starts it on first use otherwise (CLI).
+func StartNetworkSampler() {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	netSampler.once.Do(func() {
+		netSampler.ready = make(chan struct{})
+		go runNetworkSampler()
+	})
+}
+
+func runNetworkSampler() {
+	take := func() netSample {
+		names := interfaceNames()
+		return netSample{at: time.Now(), names: names, counters: readNetCounters(names)}
+	}
+	netSampler.mu.Lock()
+	netSampler.cur = take()
+	netSampler.mu.Unlock()
+	time.Sleep(netFirstSampleDelay)
+	for first := true; ; first = false {
+		s := take()
+		netSampler.mu.Lock()
+		netSampler.prev, netSampler.cur = netSampler.cur, s
+		netSampler.mu.Unlock()
+		if first {
+			close(netSampler.ready)
+		}
+		time.Sleep(netSampleInterval)
+	}
+}
+
+// GetNetwork returns every interface with the sampler's latest counters and the rates over its last interval.
+// Right after the sampler starts it waits for the first rates (netFirstSampleDelay). nil when not on Linux.
+// Link attributes, addresses and the discovery rule are read at call time.
+func GetNetwork() *Network {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	StartNetworkSampler()
+	select {
+	case <-netSampler.ready:
+	case <-time.After(2 * netFirstSampleDelay):
+	}
+	netSampler.mu.RLock()
+	prev, cur := netSampler.prev, netSampler.cur
+	netSampler.mu.RUnlock()
+	var secs float64
+	if !prev.at.IsZero() {
+		secs = cur.at.Sub(prev.at).Seconds()
+	}
+	r := currentRules()
+	n := &Network{RateIntervalSeconds: secs, Interfaces: make([]NetInterface, 0, len(cur.names))}
+	for _, name := range cur.names {
+		ni := netInterface(name)
+		ni.Discovery, ni.DiscoveryRule = decideInterface(name, r)
+		c := cur.counters[name]
+		ni.RxBytes, ni.TxBytes, ni.RxPackets, ni.TxPackets, ni.RxErrors, ni.TxErrors = c[0], c[1], c[2], c[3], c[4], c[5]
+		if p, ok := prev.counters[name]; ok && secs > 0 {
+			rate := func(i int) float64 {
+				if c[i] < p[i] { // counters reset (interface re-created or driver reload)
+					return 0
+				}
+				return float64(c[i]-p[i]) / secs
+			}
+			ni.RxBytesPerSec, ni.TxBytesPerSec = rate(0), rate(1)
+			ni.RxPacketsPerSec, ni.TxPacketsPerSec = rate(2), rate(3)
+			ni.RxErrorsPerSec, ni.TxErrorsPerSec = rate(4), rate(5)
+		}
+		n.Interfaces = append(n.Interfaces, ni)
+	}
+	return n
+}
+
+func interfaceNames() []string {
+	entries, err := os.ReadDir(netDir)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func readNetCounters(names []string) map[string]netCounters {
+	out := make(map[string]netCounters, len(names))
+	for _, name := range names {
+		var c netCounters
+		for i, f := range netStatFiles {
+			c[i], _ = strconv.ParseUint(readTrimmedFile(filepath.Join(netDir, name, "statistics", f)), 10, 64)
+		}
+		out[name] = c
+	}
+	return out
+}
+
+// netInterface fills the sysfs link attributes and addresses of name (counters are set by the caller).
+func netInterface(name string) NetInterface {
+	dir := filepath.Join(netDir, name)
+	ni := NetInterface{
+		Name:      name,
+		MAC:       readTrimmedFile(filepath.Join(dir, "address")),
+		OperState: readTrimmedFile(filepath.Join(dir, "operstate")),
+		Duplex:    readTrimmedFile(filepath.Join(dir, "duplex")), // read fails (EINVAL) while the link is down
+		IPv4:      []string{},
+		IPv6:      []string{},
+	}
+	ni.MTU, _ = strconv.Atoi(readTrimmedFile(filepath.Join(dir, "mtu")))
+	if speed, err := strconv.Atoi(readTrimmedFile(filepath.Join(dir, "speed"))); err == nil && speed > 0 {
+		ni.SpeedMbps = speed
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "device", "driver")); err == nil {
+		ni.Driver = filepath.Base(target)
+	}
+	if ifi, err := net.InterfaceByName(name); err == nil {
+		addrs, _ := ifi.Addrs()
+		for _, a := range addrs {
+			ipnet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if ipnet.IP.To4() != nil {
+				ni.IPv4 = append(ni.IPv4, ipnet.String())
+			} else {
+				ni.IPv6 = append(ni.IPv6, ipnet.String())
+			}
+		}
+	}
+	return ni
+}
@@ -5,20 +5,21 @@ import (
 	"net"
 	"strings"
 	"syscall"
+	"time"
 
 	"contrabass-agent/maintenance/config"
 	"contrabass-agent/maintenance/discovery"
 	"contrabass-agent/maintenance/hostinfo"
 )
 
-// StartEphemeralDiscovery opens a single UDP socket on srcPort (0.0.0.0:srcPort), starts discovery.Run,
+// StartEphemeralDiscovery opens a UDP socket on srcPort (0.0.0.0:srcPort, plus [::]:srcPort when available), starts discovery.Run,
 // and returns a Discovery usable for DoDiscoveryUnicast to remote ip:cfg.DiscoveryUDPPort.
 //
 // We intentionally do not use discovery.OpenDiscoveryClientUDP here: that helper opens one socket per
-// local subnet for broadcast sourcing; map iteration makes conns[0] non-deterministic, while
-// DoDiscoveryUnicast only sends via conns[0], which can cause intermittent timeouts. Unicast-only
+// local subnet for broadcast sourcing, bound to a specific local IP, while DoDiscoveryUnicast sends
+// IPv4 via conns[0], which then may not be the right interface for the target. Unicast-only
 // clients need one listener matching reply_udp_port (same as discovery CLI fallback when no subnet match).
-// Default srcPort should be 9998 when the agent uses 9999. Call cleanup to close the socket.
+// Default srcPort should be 9998 when the agent uses 9999. Call cleanup to close the sockets.
 func StartEphemeralDiscovery(cfg *config.Config, displayVersion string, srcPort int) (*discovery.Discovery, func(), error) {
 	if cfg.DiscoveryUDPPort <= 0 || cfg.DiscoveryUDPPort > 65535 {
 		return nil, nil, fmt.Errorf("DiscoveryUDPPort must be 1..65535")
@@ -28,11 +29,19 @@ func StartEphemeralDiscovery(cfg *config.Config, displayVersion string, srcPort
 	}
 
 	broadcastAddrs := discoveryBroadcastAddrs(cfg)
+	auth, err := DiscoveryAuthenticator(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
 	conn, err := openUnicastClientUDP(srcPort)
 	if err != nil {
 		return nil, nil, err
 	}
 	conns := []*net.UDPConn{conn}
+	// Best-effort udp6 socket so DoDiscoveryUnicast also reaches IPv6 targets (e.g. fe80::1%eth0); hosts without IPv6 just skip it.
+	if conn6, err := discovery.OpenIPv6DiscoveryUDP(srcPort, nil, nil); err == nil {
+		conns = append(conns, conn6)
+	}
 
 	dsn := strings.TrimSpace(cfg.DiscoveryServiceName)
 	if dsn == "" {
@@ -46,6 +55,8 @@ func StartEphemeralDiscovery(cfg *config.Config, displayVersion string, srcPort
 		DiscoveryDeduplicate:        cfg.DiscoveryDeduplicate,
 		Version:                     displayVersion,
 		ServicePort:                 effectiveMaintenancePort(cfg),
+		Auth:                        auth,
+		Labels:                      cfg.Labels,
 	}
 
 	getter := func() (hostname, hostIP, cpuInfo string, cpuUsage float64, memTotalMB, memUsedMB uint64, memUsagePct float64, cpuUUID string) {
@@ -60,10 +71,23 @@ func StartEphemeralDiscovery(cfg *config.Config, displayVersion string, srcPort
 	d := discovery.New(discCfg, conns, getter)
 	go d.Run()
 
-	cleanup := func() { _ = conn.Close() }
+	cleanup := func() {
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}
 	return d, cleanup, nil
 }
 
+// DiscoveryAuthenticator builds the discovery signer/verifier from Maintenance.DiscoveryAuth (nil when no secret is configured).
+func DiscoveryAuthenticator(cfg *config.Config) (*discovery.Authenticator, error) {
+	secret, err := cfg.DiscoveryAuth.ResolveSecret()
+	if err != nil {
+		return nil, err
+	}
+	return discovery.NewAuthenticator(secret, time.Duration(cfg.DiscoveryAuth.MaxClockSkewSeconds)*time.Second), nil
+}
+
 func openUnicastClientUDP(srcPort int) (*net.UDPConn, error) {
 	c, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: srcPort})
 	if err != nil {
@@ -6,12 +6,14 @@ import (
 
 // LocalSelfInfo returns host information for this machine for CLI / offline use.
 // It fills HostIPs from all non-loopback IPv4 addresses (sorted) and sets HostIP to the first,
-// approximating the server's getHostInfo enrichment when discovery UDP binds are not available.
+// approximating the server's getHostInfo enrichment when discovery UDP binds are not available. Inventory, network
+// and hardware are attached as in /self.
 func LocalSelfInfo() (hostinfo.Info, error) {
 	info, err := hostinfo.Get()
 	if err != nil {
 		return info, err
 	}
+	info.Inventory, info.Network, info.Hardware = hostinfo.GetInventory(), hostinfo.GetNetwork(), hostinfo.GetHardware()
 	ips := hostinfo.AllIPv4Addresses()
 	if len(ips) > 0 {
 		info.HostIPs = ips
@@ -4,6 +4,7 @@ import (
 	"strings"
 
 	"contrabass-agent/maintenance/config"
+	"contrabass-agent/maintenance/discovery"
 )
 
 // SelfMetaFromConfig builds SelfDiscoveryMeta from a loaded config and the running binary's version key (same role as server.Config.Version / Maintenance fields).
@@ -16,5 +17,16 @@ func SelfMetaFromConfig(cfg *config.Config, displayVersion string) SelfDiscovery
 		Version:              displayVersion,
 		ServicePort:          effectiveMaintenancePort(cfg),
 		DiscoveryServiceName: dsn,
+		Labels:               cfg.Labels,
+		Capabilities:         discovery.Capabilities(FeaturesFromConfig(cfg)),
+	}
+}
+
+// FeaturesFromConfig returns the optional features the service runs with cfg (the IPv6 socket is assumed to open).
+func FeaturesFromConfig(cfg *config.Config) discovery.Features {
+	return discovery.Features{
+		Presence:      cfg.Presence.Enabled,
+		HostRegistry:  true,
+		IPv6Discovery: cfg.DiscoveryIPv6,
 	}
 }
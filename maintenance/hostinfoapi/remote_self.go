@@ -0,0 +1,61 @@
+package hostinfoapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"contrabass-agent/maintenance/discovery"
+	"contrabass-agent/maintenance/hostinfo"
+)
+
+// remoteSelfClient bounds the HTTP fetch that follows a successful unicast discovery in /host-info.
+var remoteSelfClient = &http.Client{Timeout: 5 * time.Second}
+
+// RemoteSections are the parts of a remote agent's GET /self that the UDP DISCOVERY_RESPONSE cannot carry.
+// Each is nil for agents that do not report it.
+type RemoteSections struct {
+	Network   *hostinfo.Network
+	Hardware  *hostinfo.Hardware
+	Inventory *hostinfo.Inventory
+}
+
+// RemoteSelfSections fetches a remote agent's GET {apiPrefix}/self (baseURL is "http://host:port") and returns its
+// network, hardware and inventory sections.
+func RemoteSelfSections(baseURL, apiPrefix string) (RemoteSections, error) {
+	resp, err := remoteSelfClient.Get(baseURL + apiPrefix + "/self")
+	if err != nil {
+		return RemoteSections{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return RemoteSections{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return RemoteSections{}, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	var out struct {
+		Status string `json:"status"`
+		Data   struct {
+			Network   *hostinfo.Network   `json:"network"`
+			Hardware  *hostinfo.Hardware  `json:"hardware"`
+			Inventory *hostinfo.Inventory `json:"inventory"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return RemoteSections{}, err
+	}
+	if out.Status != "success" {
+		return RemoteSections{}, fmt.Errorf("remote self: status %q", out.Status)
+	}
+	return RemoteSections{Network: out.Data.Network, Hardware: out.Data.Hardware, Inventory: out.Data.Inventory}, nil
+}
+
+// Attach returns resp (a remote host's UDP answer) as a /host-info payload, with these sections added.
+func (sec RemoteSections) Attach(resp discovery.DiscoveryResponse) SelfResponse {
+	return SelfResponse{DiscoveryResponse: resp, Network: sec.Network, Hardware: sec.Hardware, Inventory: sec.Inventory}
+}
@@ -11,15 +11,32 @@ type SelfDiscoveryMeta struct {
 	Version              string
 	ServicePort          int
 	DiscoveryServiceName string
+	Labels               map[string]string
+	// Capabilities advertised in /self: the running Discovery's, or those FeaturesFromConfig implies for the CLI.
+	Capabilities []string
+}
+
+// SelfResponse is the GET /self and /host-info payload: the DISCOVERY_RESPONSE fields plus the sections that only travel
+// over HTTP. UDP answers decode into discovery.DiscoveryResponse, which has no room for them.
+type SelfResponse struct {
+	discovery.DiscoveryResponse
+	// HostIPs lists every address the agent listens on (UDP answers carry the reply path's address only, in responded_from_ip).
+	HostIPs []string `json:"host_ips,omitempty"`
+	// CPU is the latest CPU sample (iowait/steal/irq breakdown, per-core); self only.
+	CPU *hostinfo.CPUSample `json:"cpu,omitempty"`
+	// Inventory, Network and Hardware: for a remote host, taken from its own /self (RemoteSelfSections).
+	// Hardware serials are redacted per the collecting host's config.
+	Inventory *hostinfo.Inventory `json:"inventory,omitempty"`
+	Network   *hostinfo.Network   `json:"network,omitempty"`
+	Hardware  *hostinfo.Hardware  `json:"hardware,omitempty"`
 }
 
 // SelfDiscoveryResponse returns the same payload shape as GET /self and GET /host-info?ip=self (or empty ip).
-func SelfDiscoveryResponse(info hostinfo.Info, meta SelfDiscoveryMeta) discovery.DiscoveryResponse {
-	return discovery.DiscoveryResponse{
+func SelfDiscoveryResponse(info hostinfo.Info, meta SelfDiscoveryMeta) SelfResponse {
+	return SelfResponse{DiscoveryResponse: discovery.DiscoveryResponse{
 		Type:                "DISCOVERY_RESPONSE",
 		Service:             meta.DiscoveryServiceName,
 		HostIP:              info.HostIP,
-		HostIPs:             info.HostIPs,
 		Hostname:            info.Hostname,
 		ServicePort:         meta.ServicePort,
 		Version:             meta.Version,
@@ -30,5 +47,15 @@ func SelfDiscoveryResponse(info hostinfo.Info, meta SelfDiscoveryMeta) discovery
 		MemoryTotalMB:       info.MemoryTotalMB,
 		MemoryUsedMB:        info.MemoryUsedMB,
 		MemoryUsagePercent:  info.MemoryUsagePercent,
+		Labels:              meta.Labels,
+		Summary:             discovery.SummaryOf(info.Inventory),
+		ProtoVersion:        discovery.ProtoVersion,
+		Capabilities:        meta.Capabilities,
+	},
+		HostIPs:   info.HostIPs,
+		CPU:       info.CPU,
+		Inventory: info.Inventory,
+		Network:   info.Network,
+		Hardware:  info.Hardware,
 	}
 }
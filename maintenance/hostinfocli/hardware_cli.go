@@ -0,0 +1,156 @@
+package hostinfocli
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"contrabass-agent/maintenance/appmeta"
+	"contrabass-agent/maintenance/cliutil"
+	"contrabass-agent/maintenance/config"
+	"contrabass-agent/maintenance/hostinfo"
+	"contrabass-agent/maintenance/hostinfoapi"
+)
+
+// RunHardware runs: <bin> agent --hardware -cfg <config> [--json] [self|remote-ip]
+// self (default) reads DMI and sysfs here (run as root for serials); a remote IP takes the "hardware" section of that
+// agent's GET /self on Server.HTTPPort. Serials follow Maintenance.Hardware.SerialRedaction of the host that collected them.
+func RunHardware(args []string) int {
+	fs := flag.NewFlagSet("hardware", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	cfgPath := fs.String("cfg", "", "path to config file (required)")
+	asJSON := fs.Bool("json", false, "print the hardware object as JSON instead of a table")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s agent --hardware -cfg <config.yaml> [--json] [self|remote-ip]\n\n", appmeta.BinaryName)
+		fmt.Fprintf(os.Stderr, "  self (default): DMI (/sys/class/dmi/id) and sysfs on this host; serials need root. No running agent needed.\n")
+		fmt.Fprintf(os.Stderr, "  remote IP: the \"hardware\" section of GET http://<ip>:Server.HTTPPort{APIPrefix}/self.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 1
+	}
+	if strings.TrimSpace(*cfgPath) == "" {
+		fmt.Fprintf(os.Stderr, "%s: -cfg <config.yaml> is required\n", appmeta.BinaryName)
+		fs.Usage()
+		return 1
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintf(os.Stderr, "%s: expected at most one argument: [self|remote-ip]\n", appmeta.BinaryName)
+		return 1
+	}
+	cfg, err := config.Load(*cfgPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: load config: %v\n", appmeta.BinaryName, err)
+		return 1
+	}
+	target := "self"
+	if fs.NArg() == 1 {
+		target = strings.TrimSpace(fs.Arg(0))
+	}
+
+	var hw *hostinfo.Hardware
+	if strings.EqualFold(target, "self") {
+		serialKey, err := cfg.ResolveSerialHashKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", appmeta.BinaryName, err)
+			return 1
+		}
+		hostinfo.SetSerialRedaction(cfg.Hardware.SerialRedaction, serialKey)
+		hw = hostinfo.GetHardware()
+		if hw == nil {
+			fmt.Fprintf(os.Stderr, "%s: hardware inventory is only collected on Linux\n", appmeta.BinaryName)
+			return 1
+		}
+	} else {
+		if !cliutil.IsRemoteIP(target) {
+			fmt.Fprintf(os.Stderr, "%s: remote target must be a valid IP address: %q\n", appmeta.BinaryName, target)
+			return 1
+		}
+		sec, err := hostinfoapi.RemoteSelfSections(cliutil.RemoteBaseURL(cfg, target), cliutil.NormalizeAPIPrefix(cfg.APIPrefix))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", appmeta.BinaryName, err)
+			return 1
+		}
+		if sec.Hardware == nil {
+			fmt.Fprintf(os.Stderr, "%s: %s does not report hardware (older agent or not Linux)\n", appmeta.BinaryName, target)
+			return 1
+		}
+		hw = sec.Hardware
+	}
+
+	if *asJSON {
+		b, _ := json.MarshalIndent(hw, "", "  ")
+		fmt.Println(string(b))
+		return 0
+	}
+	printHardware(os.Stdout, "hardware "+target, hw)
+	return 0
+}
+
+func printHardware(w io.Writer, label string, hw *hostinfo.Hardware) {
+	fmt.Fprintln(w, label)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	row := func(k, v string) { fmt.Fprintf(tw, "%s\t%s\n", k, orDash(v)) }
+	row("SYSTEM_VENDOR", hw.System.Vendor)
+	row("SYSTEM_PRODUCT", hw.System.Product)
+	row("SYSTEM_VERSION", hw.System.Version)
+	row("SYSTEM_FAMILY", hw.System.Family)
+	row("SYSTEM_SKU", hw.System.SKU)
+	row("SYSTEM_SERIAL", hw.System.Serial)
+	row("SYSTEM_UUID", hw.System.UUID)
+	chassis := hw.Chassis.Type
+	if hw.Chassis.TypeCode > 0 {
+		chassis += " (" + strconv.Itoa(hw.Chassis.TypeCode) + ")"
+	}
+	row("CHASSIS_TYPE", chassis)
+	row("CHASSIS_VENDOR", hw.Chassis.Vendor)
+	row("CHASSIS_SERIAL", hw.Chassis.Serial)
+	row("CHASSIS_ASSET_TAG", hw.Chassis.AssetTag)
+	row("BIOS_VENDOR", hw.BIOS.Vendor)
+	row("BIOS_VERSION", hw.BIOS.Version)
+	row("BIOS_DATE", hw.BIOS.Date)
+	row("BOARD_VENDOR", hw.Board.Vendor)
+	row("BOARD_NAME", hw.Board.Name)
+	row("BOARD_VERSION", hw.Board.Version)
+	row("BOARD_SERIAL", hw.Board.Serial)
+	row("CPU_MODEL", hw.CPU.Model)
+	row("CPU_VENDOR", hw.CPU.Vendor)
+	row("CPU_TOPOLOGY", fmt.Sprintf("%d sockets, %d cores, %d logical (%d threads/core), %d NUMA nodes",
+		hw.CPU.Sockets, hw.CPU.Cores, hw.CPU.Logical, hw.CPU.ThreadsPerCore, hw.CPU.NUMANodes))
+	row("CPU_ONLINE", fmt.Sprintf("%s (possible %s)", hw.CPU.Online, hw.CPU.Possible))
+	if hw.CPU.MaxMHz > 0 {
+		row("CPU_MAX_MHZ", strconv.Itoa(hw.CPU.MaxMHz))
+	}
+	caches := make([]string, 0, len(hw.CPU.Caches))
+	for _, c := range hw.CPU.Caches {
+		caches = append(caches, fmt.Sprintf("L%d %s %dK/%d", c.Level, strings.ToLower(c.Type), c.SizeKB, c.SharedBy))
+	}
+	row("CPU_CACHES", strings.Join(caches, ", "))
+	mem := fmt.Sprintf("%d MB usable", hw.Memory.TotalMB)
+	if hw.Memory.PhysicalMB > 0 {
+		mem += fmt.Sprintf(", %d MB online", hw.Memory.PhysicalMB)
+	}
+	row("MEMORY", mem)
+	row("SERIAL_REDACTION", hw.SerialRedaction)
+	_ = tw.Flush()
+}
+
+// hardwareSummary is the one-line HARDWARE row of --host-info: vendor, product and serial, then the BIOS.
+func hardwareSummary(hw *hostinfo.Hardware) string {
+	parts := []string{orDash(strings.TrimSpace(hw.System.Vendor + " " + hw.System.Product))}
+	if hw.System.Serial != "" {
+		parts = append(parts, "serial "+hw.System.Serial)
+	}
+	if hw.BIOS.Version != "" {
+		parts = append(parts, "BIOS "+strings.TrimSpace(hw.BIOS.Version+" "+hw.BIOS.Date))
+	}
+	return strings.Join(parts, ", ")
+}
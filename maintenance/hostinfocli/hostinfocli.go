@@ -4,15 +4,17 @@ package hostinfocli
 import (
 	"fmt"
 	"io"
-	"net"
 	"os"
 	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
 	"contrabass-agent/maintenance/config"
 	"contrabass-agent/maintenance/appmeta"
+	"contrabass-agent/maintenance/cliutil"
 	"contrabass-agent/maintenance/discovery"
+	"contrabass-agent/maintenance/hostinfo"
 	"contrabass-agent/maintenance/hostinfoapi"
 )
 
@@ -61,6 +63,12 @@ func Run(buildVersionKey string, args []string) int {
 	}
 
 	if strings.EqualFold(target, "self") {
+		serialKey, err := cfg.ResolveSerialHashKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", appmeta.BinaryName, err)
+			return 1
+		}
+		hostinfo.SetSerialRedaction(cfg.Hardware.SerialRedaction, serialKey)
 		info, err := hostinfoapi.LocalSelfInfo()
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%s: host info: %v\n", appmeta.BinaryName, err)
@@ -72,7 +80,7 @@ func Run(buildVersionKey string, args []string) int {
 		return 0
 	}
 
-	if net.ParseIP(target) == nil {
+	if !cliutil.IsRemoteIP(target) {
 		fmt.Fprintf(os.Stderr, "%s: remote target must be a valid IP address: %q\n", appmeta.BinaryName, target)
 		return 1
 	}
@@ -89,7 +97,11 @@ func Run(buildVersionKey string, args []string) int {
 		fmt.Fprintf(os.Stderr, "%s: %v\n", appmeta.BinaryName, err)
 		return 1
 	}
-	printHostInfo(os.Stdout, "host "+target+" (unicast discovery)", *resp)
+	sec, err := hostinfoapi.RemoteSelfSections(cliutil.RemoteBaseURL(cfg, target), cliutil.NormalizeAPIPrefix(cfg.APIPrefix))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: network, hardware and inventory sections: %v\n", appmeta.BinaryName, err)
+	}
+	printHostInfo(os.Stdout, "host "+target+" (unicast discovery)", sec.Attach(*resp))
 	return 0
 }
 
@@ -161,7 +173,7 @@ func parseHostInfoArgs(args []string) (cfgPath string, srcPort int, pos []string
 	return cfgPath, srcPort, pos, showHelp, nil
 }
 
-func printHostInfo(w io.Writer, label string, d discovery.DiscoveryResponse) {
+func printHostInfo(w io.Writer, label string, d hostinfoapi.SelfResponse) {
 	fmt.Fprintln(w, label)
 	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
 	row := func(k, v string) { fmt.Fprintf(tw, "%s\t%s\n", k, v) }
@@ -179,15 +191,99 @@ func printHostInfo(w io.Writer, label string, d discovery.DiscoveryResponse) {
 	}
 	row("CPU_INFO", d.CPUInfo)
 	row("CPU_USAGE_PERCENT", fmt.Sprintf("%.2f", d.CPUUsagePercent))
+	if c := d.CPU; c != nil {
+		row("CPU_BREAKDOWN", fmt.Sprintf("user %.1f%%, system %.1f%%, iowait %.1f%%, irq %.1f%%, softirq %.1f%%, steal %.1f%%",
+			c.UserPercent, c.SystemPercent, c.IOWaitPercent, c.IRQPercent, c.SoftIRQPercent, c.StealPercent))
+		cores := make([]string, len(c.PerCore))
+		for i, u := range c.PerCore {
+			cores[i] = fmt.Sprintf("%.1f", u)
+		}
+		row("CPU_PER_CORE", strings.Join(cores, " "))
+	}
 	row("CPU_UUID", d.CPUUUID)
 	row("MEMORY_TOTAL_MB", strconv.FormatUint(d.MemoryTotalMB, 10))
 	row("MEMORY_USED_MB", strconv.FormatUint(d.MemoryUsedMB, 10))
 	row("MEMORY_USAGE_PERCENT", fmt.Sprintf("%.2f", d.MemoryUsagePercent))
+	if len(d.Labels) > 0 {
+		row("LABELS", discovery.FormatLabels(d.Labels))
+	}
 	if d.RespondedFromIP != "" {
 		row("RESPONDED_FROM_IP", d.RespondedFromIP)
 	}
 	if d.IsSelf {
 		row("SELF", "true")
 	}
+	// Full inventory (self) supersedes the compact summary (remote, from DISCOVERY_RESPONSE).
+	if inv := d.Inventory; inv != nil {
+		row("LOAD_AVERAGE", fmt.Sprintf("%.2f %.2f %.2f", inv.LoadAverage1, inv.LoadAverage5, inv.LoadAverage15))
+		row("UPTIME", formatUptime(inv.UptimeSeconds))
+		if inv.BootTime > 0 {
+			row("BOOT_TIME", time.Unix(inv.BootTime, 0).Format(time.RFC3339))
+		}
+		row("KERNEL", inv.KernelVersion)
+		row("OS", fmt.Sprintf("%s (id=%s, version_id=%s)", inv.OS.PrettyName, inv.OS.ID, inv.OS.VersionID))
+		row("CPU_TOPOLOGY", fmt.Sprintf("%d logical, %d cores, %d sockets", inv.CPULogical, inv.CPUCores, inv.CPUSockets))
+		row("SWAP", fmt.Sprintf("%d / %d MB (%.2f%%)", inv.SwapUsedMB, inv.SwapTotalMB, inv.SwapUsagePercent))
+	} else if sm := d.Summary; sm != nil {
+		row("LOAD_1", fmt.Sprintf("%.2f", sm.Load1))
+		row("UPTIME", formatUptime(sm.UptimeSeconds))
+		row("KERNEL", sm.Kernel)
+		row("OS", sm.OS)
+		row("CPU_TOPOLOGY", fmt.Sprintf("%d cores, %d sockets", sm.CPUCores, sm.CPUSockets))
+		row("SWAP_USAGE_PERCENT", fmt.Sprintf("%.2f", sm.SwapUsagePercent))
+		if sm.DiskMaxMount != "" {
+			row("DISK_MAX_USAGE", fmt.Sprintf("%.2f%% (%s)", sm.DiskMaxUsagePercent, sm.DiskMaxMount))
+		}
+	}
+	if hw := d.Hardware; hw != nil {
+		row("HARDWARE", hardwareSummary(hw))
+	}
 	_ = tw.Flush()
+	if d.Inventory != nil && len(d.Inventory.Disks) > 0 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "MOUNT\tDEVICE\tFS\tSIZE_MB\tUSED_MB\tAVAIL_MB\tUSE%\tINODES\tIUSE%")
+		for _, disk := range d.Inventory.Disks {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%.1f\t%d\t%.1f\n", disk.Mount, disk.Device, disk.FSType,
+				disk.TotalMB, disk.UsedMB, disk.AvailableMB, disk.UsagePercent, disk.Inodes, disk.InodesUsagePercent)
+		}
+		_ = tw.Flush()
+	}
+	if d.Network != nil && len(d.Network.Interfaces) > 0 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "IFACE\tSTATE\tMAC\tMTU\tSPEED\tDRIVER\tIPV4\tRX_KB/S\tTX_KB/S\tRX_ERR\tTX_ERR\tDISCOVERY")
+		for _, n := range d.Network.Interfaces {
+			speed := "-"
+			if n.SpeedMbps > 0 {
+				speed = fmt.Sprintf("%dMb/s", n.SpeedMbps)
+				if n.Duplex == "full" || n.Duplex == "half" {
+					speed += " " + n.Duplex
+				}
+			}
+			disc := "no"
+			if n.Discovery {
+				disc = "yes"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%.1f\t%.1f\t%d\t%d\t%s (%s)\n", n.Name, n.OperState, n.MAC, n.MTU, speed,
+				orDash(n.Driver), orDash(strings.Join(n.IPv4, ",")), n.RxBytesPerSec/1024, n.TxBytesPerSec/1024, n.RxErrors, n.TxErrors, disc, n.DiscoveryRule)
+		}
+		_ = tw.Flush()
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// formatUptime renders seconds as "3d 4h 5m".
+func formatUptime(sec uint64) string {
+	d, h, m := sec/86400, sec%86400/3600, sec%3600/60
+	if d > 0 {
+		return fmt.Sprintf("%dd %dh %dm", d, h, m)
+	}
+	return fmt.Sprintf("%dh %dm", h, m)
 }
@@ -0,0 +1,91 @@
+package hostinfocli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"contrabass-agent/maintenance/appmeta"
+	"contrabass-agent/maintenance/config"
+	"contrabass-agent/maintenance/hostinfo"
+)
+
+// RunNicBrd runs: <bin> agent --nic-brd [-cfg <config>]
+// Lists every interface with the rule that chose or rejected it for discovery and its broadcast addresses, then the
+// brd list the service would send to. With -cfg, Maintenance.DiscoveryInterfaces and DiscoveryBroadcastAddresses apply.
+func RunNicBrd(args []string) int {
+	fs := flag.NewFlagSet("nic-brd", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	cfgPath := fs.String("cfg", "", "config file whose DiscoveryInterfaces / DiscoveryBroadcastAddresses to apply (default: automatic rules only)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s agent --nic-brd [-cfg <config.yaml>]\n\n", appmeta.BinaryName)
+		fmt.Fprintf(os.Stderr, "  One row per interface: IFACE, USE (yes/no), RULE that decided it, BRD (IPv4 broadcast addresses).\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 1
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "%s: unexpected argument %q\n", appmeta.BinaryName, fs.Arg(0))
+		return 1
+	}
+	var extra []string
+	fallback := ""
+	if strings.TrimSpace(*cfgPath) != "" {
+		cfg, err := config.Load(*cfgPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: load config: %v\n", appmeta.BinaryName, err)
+			return 1
+		}
+		hostinfo.SetDiscoveryInterfaceRules(hostinfo.InterfaceRules{Include: cfg.DiscoveryInterfaces.Include, Exclude: cfg.DiscoveryInterfaces.Exclude})
+		extra = cfg.DiscoveryBroadcastAddresses
+		fallback = cfg.DiscoveryBroadcastAddress
+	}
+	printNicBrd(os.Stdout, hostinfo.DiscoveryInterfaceDecisions(), extra, fallback)
+	return 0
+}
+
+func printNicBrd(w io.Writer, decisions []hostinfo.InterfaceDecision, extra []string, fallback string) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IFACE\tUSE\tRULE\tBRD")
+	var brds []string
+	for _, d := range decisions {
+		use, brd := "no", "-"
+		if d.Included {
+			use = "yes"
+			if len(d.Brds) > 0 {
+				brd = strings.Join(d.Brds, ", ")
+			} else {
+				brd = "(no IPv4 broadcast)"
+			}
+			for _, b := range d.Brds {
+				if !slices.Contains(brds, b) {
+					brds = append(brds, b)
+				}
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", d.Iface, use, d.Rule, brd)
+	}
+	for _, b := range extra {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", "(config)", "yes", "DiscoveryBroadcastAddresses", b)
+		if !slices.Contains(brds, b) {
+			brds = append(brds, b)
+		}
+	}
+	tw.Flush()
+	switch {
+	case len(brds) > 0:
+		fmt.Fprintf(w, "\nDiscovery brd: %s\n", strings.Join(brds, " "))
+	case fallback != "":
+		fmt.Fprintf(w, "\nDiscovery brd: %s (DiscoveryBroadcastAddress fallback, nothing collected)\n", fallback)
+	default:
+		fmt.Fprintf(w, "\nDiscovery brd: 255.255.255.255 (nothing collected)\n")
+	}
+}
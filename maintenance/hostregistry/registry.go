@@ -0,0 +1,300 @@
+package hostregistry
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"contrabass-agent/maintenance/atomicfile"
+	"contrabass-agent/maintenance/discovery"
+)
+
+// FileName is the registry file under {DeployBase}/registry/.
+const FileName = "hosts.json"
+
+// flushInterval bounds how often observed changes are written (a discovery run can touch many hosts at once).
+const flushInterval = 5 * time.Second
+
+// maxVersions caps per-host version history (oldest first_seen dropped).
+const maxVersions = 50
+
+// maxHosts caps the registry: a new host in a full registry evicts the one seen longest ago
+// (in unsigned mode anyone on the segment can announce made-up identities).
+const maxHosts = 4096
+
+// VersionSeen is one version key a host reported, with when it was first and last reported.
+type VersionSeen struct {
+	Version   string    `json:"version"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Host is one registry record. Key is the lowercase CPU UUID, or "noid:<hostname>" when the host reports none.
+type Host struct {
+	Key       string    `json:"key"`
+	Hostname  string    `json:"hostname"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// LastResponse is the last full DISCOVERY_RESPONSE (presence announcements only refresh last_seen and versions).
+	LastResponse *discovery.DiscoveryResponse `json:"last_response,omitempty"`
+	// RespondedFromIPs are all UDP source addresses the host has been heard from.
+	RespondedFromIPs []string      `json:"responded_from_ips"`
+	Versions         []VersionSeen `json:"versions"`
+}
+
+type fileFormat struct {
+	Hosts []*Host `json:"hosts"`
+}
+
+// Registry records every host this agent hears from and persists it as JSON (write to temp file + rename).
+// It implements discovery.HostObserver.
+type Registry struct {
+	path string
+
+	// writeMu serializes Flush from snapshot through rename, so an older snapshot (one that still holds a forgotten
+	// host) never lands after a newer one.
+	writeMu sync.Mutex
+
+	mu    sync.Mutex
+	hosts map[string]*Host
+	dirty bool
+}
+
+// Open loads {deployBase}/registry/hosts.json. A missing file starts an empty registry;
+// an unreadable or corrupt file is moved aside (hosts.json.corrupt-<unix>) and also starts empty.
+func Open(deployBase string) *Registry {
+	r := &Registry{
+		path:  filepath.Join(deployBase, "registry", FileName),
+		hosts: make(map[string]*Host),
+	}
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("registry: read %s: %v", r.path, err)
+		}
+		return r
+	}
+	var f fileFormat
+	if err := json.Unmarshal(data, &f); err != nil {
+		aside := fmt.Sprintf("%s.corrupt-%d", r.path, time.Now().Unix())
+		log.Printf("registry: %s is not valid JSON (%v); moved to %s", r.path, err, aside)
+		_ = os.Rename(r.path, aside)
+		return r
+	}
+	for _, h := range f.Hosts {
+		if h != nil && h.Key != "" {
+			r.hosts[h.Key] = h
+		}
+	}
+	for len(r.hosts) > maxHosts {
+		r.evictOldestLocked()
+	}
+	log.Printf("registry: loaded %d host(s) from %s", len(r.hosts), r.path)
+	return r
+}
+
+// Path returns the registry file path.
+func (r *Registry) Path() string {
+	return r.path
+}
+
+func hostKey(cpuUUID, hostname string) string {
+	if k := strings.ToLower(strings.TrimSpace(cpuUUID)); k != "" {
+		return k
+	}
+	hn := strings.TrimSpace(hostname)
+	if hn == "" {
+		return ""
+	}
+	return "noid:" + hn
+}
+
+// ObserveResponse records a DISCOVERY_RESPONSE to one of this agent's runs (discovery run, stream, unicast or relay).
+func (r *Registry) ObserveResponse(resp discovery.DiscoveryResponse) {
+	resp.IsSelf = false
+	r.observe(hostKey(resp.CPUUUID, resp.Hostname), resp.Hostname, resp.Version, resp.RespondedFromIP, &resp)
+}
+
+// ObservePresence records a HELLO / heartbeat / BYE from src.
+func (r *Registry) ObservePresence(msg discovery.PresenceMessage, src string) {
+	r.observe(hostKey(msg.CPUUUID, msg.Hostname), msg.Hostname, msg.Version, src, nil)
+}
+
+func (r *Registry) observe(key, hostname, version, src string, resp *discovery.DiscoveryResponse) {
+	if key == "" {
+		return
+	}
+	now := time.Now().UTC()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.hosts[key]
+	if !ok {
+		if len(r.hosts) >= maxHosts {
+			r.evictOldestLocked()
+		}
+		h = &Host{Key: key, FirstSeen: now}
+		r.hosts[key] = h
+	}
+	h.LastSeen = now
+	if hostname != "" {
+		h.Hostname = hostname
+	}
+	if resp != nil {
+		h.LastResponse = resp
+	}
+	if src != "" && !slices.Contains(h.RespondedFromIPs, src) {
+		h.RespondedFromIPs = append(h.RespondedFromIPs, src)
+	}
+	if version = strings.TrimSpace(version); version != "" {
+		found := false
+		for i := range h.Versions {
+			if h.Versions[i].Version == version {
+				h.Versions[i].LastSeen = now
+				found = true
+				break
+			}
+		}
+		if !found {
+			h.Versions = append(h.Versions, VersionSeen{Version: version, FirstSeen: now, LastSeen: now})
+			if len(h.Versions) > maxVersions {
+				h.Versions = h.Versions[len(h.Versions)-maxVersions:]
+			}
+		}
+	}
+	r.dirty = true
+}
+
+// evictOldestLocked drops the record with the oldest LastSeen. Caller holds mu.
+func (r *Registry) evictOldestLocked() {
+	var oldest *Host
+	for _, h := range r.hosts {
+		if oldest == nil || h.LastSeen.Before(oldest.LastSeen) {
+			oldest = h
+		}
+	}
+	if oldest != nil {
+		log.Printf("registry: full (%d hosts), evicting %s (last seen %s)", maxHosts, oldest.Key, oldest.LastSeen.Format(time.RFC3339))
+		delete(r.hosts, oldest.Key)
+		r.dirty = true
+	}
+}
+
+// List returns copies of all records, most recently seen first.
+func (r *Registry) List() []Host {
+	r.mu.Lock()
+	out := make([]Host, 0, len(r.hosts))
+	for _, h := range r.hosts {
+		out = append(out, copyHost(h))
+	}
+	r.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].LastSeen.Equal(out[j].LastSeen) {
+			return out[i].LastSeen.After(out[j].LastSeen)
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// Get returns one record by key (CPU UUID case-insensitive, or "noid:<hostname>").
+func (r *Registry) Get(key string) (Host, bool) {
+	key = normalizeKey(key)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.hosts[key]
+	if !ok {
+		return Host{}, false
+	}
+	return copyHost(h), true
+}
+
+// Forget removes a record and persists immediately. Returns false when the key is unknown.
+func (r *Registry) Forget(key string) (bool, error) {
+	key = normalizeKey(key)
+	r.mu.Lock()
+	if _, ok := r.hosts[key]; !ok {
+		r.mu.Unlock()
+		return false, nil
+	}
+	delete(r.hosts, key)
+	r.dirty = true
+	r.mu.Unlock()
+	return true, r.Flush()
+}
+
+func normalizeKey(key string) string {
+	key = strings.TrimSpace(key)
+	if strings.HasPrefix(key, "noid:") {
+		return key
+	}
+	return strings.ToLower(key)
+}
+
+// Flush writes the registry if anything changed since the last write (temp file in the same directory, then rename).
+func (r *Registry) Flush() error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	f := fileFormat{Hosts: make([]*Host, 0, len(r.hosts))}
+	for _, h := range r.hosts {
+		c := copyHost(h)
+		f.Hosts = append(f.Hosts, &c)
+	}
+	r.dirty = false
+	r.mu.Unlock()
+	sort.Slice(f.Hosts, func(i, j int) bool { return f.Hosts[i].Key < f.Hosts[j].Key })
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err == nil {
+		err = atomicfile.Write(r.path, data)
+	}
+	if err != nil {
+		r.mu.Lock()
+		r.dirty = true
+		r.mu.Unlock()
+		return fmt.Errorf("registry: write %s: %w", r.path, err)
+	}
+	return nil
+}
+
+// Run flushes periodically until stop is closed, then flushes once more and returns: wait for Run to return before
+// exiting so the last state is on disk.
+func (r *Registry) Run(stop <-chan struct{}) {
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			if err := r.Flush(); err != nil {
+				log.Print(err)
+			}
+			return
+		case <-t.C:
+			if err := r.Flush(); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+}
+
+func copyHost(h *Host) Host {
+	c := *h
+	c.RespondedFromIPs = append([]string(nil), h.RespondedFromIPs...)
+	c.Versions = append([]VersionSeen(nil), h.Versions...)
+	if h.LastResponse != nil {
+		lr := *h.LastResponse
+		c.LastResponse = &lr
+	}
+	return c
+}
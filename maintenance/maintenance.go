@@ -18,9 +18,12 @@ import (
 	"contrabass-agent/maintenance/appmeta"
 	"contrabass-agent/maintenance/config"
 	"contrabass-agent/maintenance/discovery"
+	"contrabass-agent/maintenance/discovery/mdns"
 	"contrabass-agent/maintenance/applycli"
 	"contrabass-agent/maintenance/discoverycli"
 	"contrabass-agent/maintenance/hostinfocli"
+	"contrabass-agent/maintenance/hostinfoapi"
+	"contrabass-agent/maintenance/hostregistry"
 	"contrabass-agent/maintenance/hostinfo"
 	"contrabass-agent/maintenance/server"
 	"contrabass-agent/maintenance/versionscli"
@@ -38,8 +41,10 @@ Options (after "agent"):
   -h, --help               Show this help
   -version, --version      Print version and exit
   --host-info [flags]      Host info (local /self or unicast discovery) (<bin> agent --host-info -h)
-  --nic-brd                Print per-interface IPv4 broadcast addresses (same rules as Discovery), then exit
+  --nic-brd [-cfg <file>]  Print per-interface IPv4 broadcast addresses and the rule that chose or rejected each, then exit
   --discovery [flags]      Run UDP Discovery only, no config (<bin> agent --discovery -h)
+  --discovery-stats [flags] Discovery packet counters of a running agent (<bin> agent --discovery-stats -h)
+  --hardware [flags]       Hardware inventory: DMI vendor/product/serials, BIOS, board, CPU, memory (<bin> agent --hardware -h)
   --apply-update [flags]   Validate bundle and apply locally or to remote Gin (<bin> agent --apply-update -h)
   --versions-list [flags]  List installed versions (local or remote) (<bin> agent --versions-list -h)
   --versions-switch [flags] Switch current version (<bin> agent --versions-switch -h)
@@ -67,12 +72,6 @@ func printMustSpecifyConfig(binVersion string) {
 	fmt.Printf("  %s agent --help\n", appmeta.BinaryName)
 }
 
-// setSOReuseport sets SO_REUSEPORT on a socket (Linux only).
-func setSOReuseport(fd int) error {
-	const soReuseport = 15 // SO_REUSEPORT on Linux (amd64/arm64; not in syscall package as named const)
-	return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, soReuseport, 1)
-}
-
 // ConfigPathForServiceMode returns the config file path for long-running HTTP+Discovery service, or "".
 // Accepted forms: `program -cfg <path>` (preferred) or legacy `program agent -cfg <path>`.
 func ConfigPathForServiceMode(args []string) string {
@@ -116,6 +115,14 @@ func runServiceWithConfigPath(buildVersionKey, cfgPath string) int {
 	if displayVersion == "" {
 		displayVersion = "0.0.0-0"
 	}
+	discAuth, err := hostinfoapi.DiscoveryAuthenticator(cfg)
+	if err != nil {
+		log.Printf("config: %v", err)
+		return 1
+	}
+	if discAuth != nil {
+		log.Printf("discovery: signed mode enabled (DiscoveryAuth, max clock skew %ds)", cfg.DiscoveryAuth.MaxClockSkewSeconds)
+	}
 
 	// UDP listener for discovery: one conn on :port (all interfaces) and one per local IPv4 so we can send broadcast from each interface (source port stays 9999 so responses are received).
 	portStr := ":" + strconv.Itoa(cfg.DiscoveryUDPPort)
@@ -123,7 +130,7 @@ func runServiceWithConfigPath(buildVersionKey, cfgPath string) int {
 		Control: func(network, address string, c syscall.RawConn) error {
 			return c.Control(func(fd uintptr) {
 				_ = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
-				_ = setSOReuseport(int(fd))
+				_ = discovery.SetReusePort(fd)
 			})
 		},
 	}
@@ -136,38 +143,82 @@ func runServiceWithConfigPath(buildVersionKey, cfgPath string) int {
 	}
 	conn0 := pc0.(*net.UDPConn)
 	defer conn0.Close()
-	conns := []*net.UDPConn{conn0}
-	seenIP := make(map[string]bool)
-	boundIPs := []string{"0.0.0.0"}
-	ifaces, _ := net.Interfaces()
-	for _, iface := range ifaces {
-		if iface.Flags&net.FlagUp == 0 {
-			continue
+	// CPU usage comes from a background sampler so answering DISCOVERY_REQUEST and /self never sleeps.
+	hostinfo.StartCPUSampler()
+	hostinfo.StartNetworkSampler()
+	serialKey, err := cfg.ResolveSerialHashKey()
+	if err != nil {
+		log.Printf("config: %v", err)
+		return 1
+	}
+	hostinfo.SetSerialRedaction(cfg.Hardware.SerialRedaction, serialKey)
+	// Per-IP sockets follow address changes after start (sockets.refresh, driven by hostinfo.WatchAddressChanges).
+	hostinfo.SetDiscoveryInterfaceRules(hostinfo.InterfaceRules{Include: cfg.DiscoveryInterfaces.Include, Exclude: cfg.DiscoveryInterfaces.Exclude})
+	if len(cfg.DiscoveryInterfaces.Include) > 0 || len(cfg.DiscoveryInterfaces.Exclude) > 0 {
+		log.Printf("discovery: interfaces include %v, exclude %v", cfg.DiscoveryInterfaces.Include, cfg.DiscoveryInterfaces.Exclude)
+	}
+	sockets := newDiscoverySockets(lc, cfg.DiscoveryUDPPort, conn0, cfg.DiscoveryBroadcastAddresses, cfg.DiscoveryBroadcastAddress)
+	defer sockets.close()
+	sockets.sync()
+	conns := sockets.conns()
+	var ipv6Group net.IP
+	var ipv6Ifaces []string
+	if cfg.DiscoveryIPv6 {
+		ipv6Group, err = discovery.ParseIPv6Group(strings.TrimSpace(cfg.DiscoveryIPv6Group))
+		if err != nil {
+			log.Printf("config: DiscoveryIPv6Group: %v", err)
+			return 1
 		}
-		addrs, _ := iface.Addrs()
-		for _, a := range addrs {
-			ipnet, ok := a.(*net.IPNet)
-			if !ok || ipnet.IP.To4() == nil || ipnet.IP.IsLoopback() {
-				continue
-			}
-			ip := ipnet.IP.String()
-			if seenIP[ip] {
-				continue
-			}
-			seenIP[ip] = true
-			pc, err := lc.ListenPacket(ctx, "udp4", net.JoinHostPort(ip, strconv.Itoa(cfg.DiscoveryUDPPort)))
-			if err != nil {
-				log.Printf("discovery: bind %s:%d failed: %v (responses to this IP may not be received)", ip, cfg.DiscoveryUDPPort, err)
-				continue
-			}
-			conns = append(conns, pc.(*net.UDPConn))
-			boundIPs = append(boundIPs, ip)
+		ipv6Ifaces = hostinfo.GetIPv6DiscoveryInterfaces()
+		conn6, err := discovery.OpenIPv6DiscoveryUDP(cfg.DiscoveryUDPPort, ipv6Group, ipv6Ifaces)
+		if err != nil {
+			// IPv4 discovery still works; IPv6-only segments will not see this host.
+			log.Printf("discovery: ipv6 listen [::]:%d failed: %v (IPv6 discovery disabled)", cfg.DiscoveryUDPPort, err)
+			ipv6Group, ipv6Ifaces = nil, nil
+		} else {
+			conns = append(conns, conn6)
+			log.Printf("discovery: ipv6 group %s on interfaces %v", ipv6Group, ipv6Ifaces)
+		}
+	}
+	var mcastGroup net.IP
+	switch cfg.DiscoveryMode {
+	case discovery.ModeBroadcast:
+	case discovery.ModeMulticast, discovery.ModeBoth:
+		mcastGroup, err = discovery.ParseMulticastGroup(strings.TrimSpace(cfg.DiscoveryMulticastGroup))
+		if err != nil {
+			log.Printf("config: DiscoveryMulticastGroup: %v", err)
+			return 1
 		}
+		joined := sockets.joinMulticast(mcastGroup, cfg.DiscoveryMulticastTTL)
+		log.Printf("discovery: mode %s, multicast group %s ttl=%d joined on %v", cfg.DiscoveryMode, mcastGroup, cfg.DiscoveryMulticastTTL, joined)
+	default:
+		log.Printf("config: DiscoveryMode must be broadcast, multicast or both (got %q)", cfg.DiscoveryMode)
+		return 1
+	}
+	// Checked once here so a typo fails startup; files are re-read on every run.
+	if peers, err := discovery.ExpandStaticPeers(cfg.DiscoveryStaticPeers, cfg.DiscoveryUDPPort); err != nil {
+		log.Printf("config: DiscoveryStaticPeers: %v", err)
+		return 1
+	} else if len(peers) > 0 {
+		log.Printf("discovery: %d static peer address(es) probed by unicast on every run", len(peers))
+	}
+	relayPeers, err := discovery.ParseRelayPeers(cfg.DiscoveryRelay.Peers, cfg.DiscoveryUDPPort)
+	if err != nil {
+		log.Printf("config: DiscoveryRelay.Peers: %v", err)
+		return 1
 	}
-	log.Printf("%s version %s: discovery listening on %s (bound IPs: %v)", appmeta.BinaryName, displayVersion, portStr, boundIPs)
-	for i := 1; i < len(conns); i++ {
-		defer conns[i].Close()
+	if len(relayPeers) > 0 || cfg.DiscoveryRelay.Accept {
+		log.Printf("discovery: relay peers %v (max hops %d), accept relayed requests: %v", relayPeers, cfg.DiscoveryRelay.MaxHops, cfg.DiscoveryRelay.Accept)
 	}
+	allowedSources, err := discovery.ParseAllowedSources(cfg.DiscoveryLimits.AllowedSources)
+	if err != nil {
+		log.Printf("config: DiscoveryLimits.AllowedSources: %v", err)
+		return 1
+	}
+	lim := cfg.DiscoveryLimits
+	log.Printf("discovery: responder limits per-source %g/s (burst %d), global %g/s (burst %d), reply port %s, %d allowed source prefix(es)",
+		lim.PerSourcePerSecond, lim.PerSourceBurst, lim.GlobalPerSecond, lim.GlobalBurst, lim.ReplyPort, len(allowedSources))
+	log.Printf("%s version %s: discovery listening on %s (bound IPs: %v)", appmeta.BinaryName, displayVersion, portStr, sockets.boundIPs())
 
 	getter := func() (hostname, hostIP, cpuInfo string, cpuUsage float64, memTotalMB, memUsedMB uint64, memUsagePct float64, cpuUUID string) {
 		info, err := hostinfo.Get()
@@ -178,17 +229,22 @@ func runServiceWithConfigPath(buildVersionKey, cfgPath string) int {
 			info.MemoryTotalMB, info.MemoryUsedMB, info.MemoryUsagePercent, info.CPUUUID
 	}
 
-	broadcastAddrs := hostinfo.GetPhysicalNICBroadcastAddresses()
-	if len(broadcastAddrs) == 0 {
-		if cfg.DiscoveryBroadcastAddress != "" {
-			broadcastAddrs = []string{cfg.DiscoveryBroadcastAddress}
-			log.Printf("discovery: no brd addresses collected (3.1.1), using config fallback: %v", broadcastAddrs)
-		} else {
-			broadcastAddrs = []string{"255.255.255.255"}
-			log.Printf("discovery: no brd addresses collected (3.1.1), using 255.255.255.255")
-		}
+	broadcastAddrs := sockets.broadcastAddresses()
+	registry := hostregistry.Open(cfg.DeployBase)
+	registryStop, registryDone := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(registryDone)
+		registry.Run(registryStop)
+	}()
+	metrics := startMetricsHistory(cfg)
+	metricsStop, metricsDone := make(chan struct{}), make(chan struct{})
+	if metrics != nil {
+		go func() {
+			defer close(metricsDone)
+			metrics.Run(metricsStop)
+		}()
 	} else {
-		log.Printf("discovery: broadcast addresses: %v", broadcastAddrs)
+		close(metricsDone)
 	}
 	discCfg := discovery.Config{
 		DiscoveryServiceName:        cfg.DiscoveryServiceName,
@@ -198,9 +254,54 @@ func runServiceWithConfigPath(buildVersionKey, cfgPath string) int {
 		DiscoveryDeduplicate:        cfg.DiscoveryDeduplicate,
 		Version:                     displayVersion,
 		ServicePort:                 cfg.MaintenancePort,
+		Auth:                        discAuth,
+		IPv6Group:                   ipv6Group,
+		IPv6Interfaces:              ipv6Ifaces,
+		Mode:                        cfg.DiscoveryMode,
+		MulticastGroup:              mcastGroup,
+		Observer:                    registry,
+		Labels:                      cfg.Labels,
+		RelayPeers:                  relayPeers,
+		RelayMaxHops:                cfg.DiscoveryRelay.MaxHops,
+		RelayAccept:                 cfg.DiscoveryRelay.Accept,
+		StaticPeers:                 cfg.DiscoveryStaticPeers,
+		StaticPeerConcurrency:       cfg.DiscoveryStaticPeersConcurrency,
+		Browser:                     mdnsBrowser(cfg),
+		Features: discovery.Features{
+			Presence:      cfg.Presence.Enabled,
+			HostRegistry:  true,
+			IPv6Discovery: ipv6Group != nil,
+		},
+		Summary:                     func() *discovery.HostSummary { return discovery.SummaryOf(hostinfo.GetInventory()) },
+		Limits: discovery.Limits{
+			PerSourceRate:  lim.PerSourcePerSecond,
+			PerSourceBurst: lim.PerSourceBurst,
+			GlobalRate:     lim.GlobalPerSecond,
+			GlobalBurst:    lim.GlobalBurst,
+			ReplyPortMode:  lim.ReplyPort,
+			ReplyPortMin:   lim.ReplyPortMin,
+			ReplyPortMax:   lim.ReplyPortMax,
+			AllowedSources: allowedSources,
+		},
 	}
 	disc := discovery.New(discCfg, conns, getter)
+	sockets.attach(disc, broadcastAddrs)
 	go disc.Run()
+	responder := startMDNS(cfg, displayVersion)
+	if cfg.DiscoveryMDNS.Browse {
+		log.Printf("mdns: discovery runs also browse %s for %ds", mdns.ServiceType, cfg.DiscoveryMDNS.BrowseSeconds)
+	}
+	watchStop := make(chan struct{})
+	go hostinfo.WatchAddressChanges(watchStop, func() {
+		sockets.refresh()
+		if responder != nil {
+			responder.Refresh()
+		}
+	})
+	presenceStop := make(chan struct{})
+	if cfg.Presence.Enabled {
+		go disc.RunPresence(time.Duration(cfg.Presence.HeartbeatSeconds)*time.Second, presenceStop)
+	}
 
 	// Web FS: embed embeds "web/*" under this package at build time; no separate web/ at runtime.
 	fsys, err := fs.Sub(webFS, "web")
@@ -217,8 +318,9 @@ func runServiceWithConfigPath(buildVersionKey, cfgPath string) int {
 		if err != nil {
 			return info, err
 		}
-		// Use all IPs bound for discovery (so self card shows e.g. 172.29.236.41 and 172.29.237.141)
-		for _, b := range boundIPs {
+		info.Inventory, info.Network, info.Hardware = hostinfo.GetInventory(), hostinfo.GetNetwork(), hostinfo.GetHardware()
+		// Use all IPs bound for discovery (so self card shows e.g. 172.29.236.41 and 172.29.237.141); follows address changes.
+		for _, b := range sockets.boundIPs() {
 			if b != "0.0.0.0" {
 				info.HostIPs = append(info.HostIPs, b)
 			}
@@ -233,11 +335,14 @@ func runServiceWithConfigPath(buildVersionKey, cfgPath string) int {
 		APIPrefix:            cfg.APIPrefix,
 		WebFS:                fsys,
 		Discovery:            disc,
+		Registry:             registry,
+		MetricsHistory:       metrics,
 		GetHostInfo:          getHostInfo,
 		Version:              displayVersion,
 		ServicePort:          cfg.MaintenancePort,
 		RemoteProxyPort:      cfg.ServerHTTPPort,
 		DiscoveryServiceName: cfg.DiscoveryServiceName,
+		Labels:               cfg.Labels,
 		SystemctlServiceName: cfg.SystemctlServiceName,
 		DeployBase:           cfg.DeployBase,
 		InstallPrefix:        cfg.InstallPrefix,
@@ -269,12 +374,25 @@ func runServiceWithConfigPath(buildVersionKey, cfgPath string) int {
 	sig := <-sigChan
 	log.Printf("received %v, shutting down...", sig)
 
+	close(presenceStop)
+	close(watchStop)
+	if cfg.Presence.Enabled {
+		disc.SayBye() // before closing sockets so peers see this host leave immediately
+	}
+	if responder != nil {
+		_ = responder.Close() // goodbye: mDNS browsers drop the service now instead of after the TTL
+	}
 	conn0.Close() // stop discovery Run() and any pending DoDiscovery
+	// HTTP first: /registry/forget must not change the registry after its final flush.
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
 		log.Printf("http shutdown: %v", err)
 	}
+	close(registryStop)
+	<-registryDone // Run's final flush is on disk
+	close(metricsStop)
+	<-metricsDone // likewise for the metrics history
 	log.Printf("%s stopped", appmeta.BinaryName)
 	return 0
 }
@@ -331,13 +449,11 @@ func Run(buildVersionKey string, args []string) int {
 			fmt.Println(versionLine(buildVersionKey))
 			return 0
 		case "--nic-brd":
-			pairs := hostinfo.GetPhysicalNICBrdPairs()
-			for _, p := range pairs {
-				fmt.Printf("%s : %s\n", p.Iface, p.Brd)
-			}
-			return 0
+			return hostinfocli.RunNicBrd(args[2:])
 		case "--discovery":
 			return discoverycli.Run(args[2:])
+		case "--discovery-stats":
+			return discoverycli.RunStats(args[2:])
 		case "--apply-update":
 			return applycli.Run(buildVersionKey, args[2:])
 		case "--versions-list":
@@ -346,6 +462,8 @@ func Run(buildVersionKey string, args []string) int {
 			return versionscli.RunSwitch(args[2:])
 		case "--host-info":
 			return hostinfocli.Run(buildVersionKey, args[2:])
+		case "--hardware":
+			return hostinfocli.RunHardware(args[2:])
 		}
 	}
 	fmt.Fprintf(os.Stderr, "unknown argument: %q\n\n", args[1])
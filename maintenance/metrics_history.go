@@ -0,0 +1,24 @@
+package maintenance
+
+import (
+	"log"
+	"time"
+
+	"contrabass-agent/maintenance/config"
+	"contrabass-agent/maintenance/metricshistory"
+)
+
+// startMetricsHistory opens the host metrics history (Maintenance.MetricsHistory); nil when disabled. The caller runs it.
+func startMetricsHistory(cfg *config.Config) *metricshistory.History {
+	mh := cfg.MetricsHistory
+	if !mh.Enabled {
+		return nil
+	}
+	deployBase := ""
+	if mh.Persist {
+		deployBase = cfg.DeployBase
+	}
+	h := metricshistory.Open(time.Duration(mh.ResolutionSeconds)*time.Second, time.Duration(mh.RetentionHours)*time.Hour, deployBase)
+	log.Printf("metrics: history every %ds, kept %dh (persist=%v)", mh.ResolutionSeconds, mh.RetentionHours, mh.Persist)
+	return h
+}
@@ -0,0 +1,393 @@
+// Package metricshistory keeps a ring of host metric points (CPU, memory, load, disk, NIC rates) for GET …/metrics/history,
+// optionally persisted under {DeployBase}/metrics/.
+package metricshistory
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"contrabass-agent/maintenance/atomicfile"
+	"contrabass-agent/maintenance/hostinfo"
+)
+
+// FileName is the history file under {DeployBase}/metrics/.
+const FileName = "history.json"
+
+// flushInterval is how often a persisted history is written (and once more on shutdown).
+const flushInterval = 5 * time.Minute
+
+// maxNICs bounds the interfaces kept per point. Only discovery interfaces (NetInterface.Discovery) are recorded, so
+// veth*, docker0 and other churn stay out; the bound covers an Include pattern that matches hundreds of them.
+const maxNICs = 16
+
+// Point is the host state over one resolution interval. CPU and NIC figures are averages over the interval;
+// memory, load, swap and disk are read at its end.
+type Point struct {
+	Time               time.Time           `json:"time"`
+	CPUUsagePercent    float64             `json:"cpu_usage_percent"`
+	CPUIOWaitPercent   float64             `json:"cpu_iowait_percent"`
+	CPUStealPercent    float64             `json:"cpu_steal_percent"`
+	MemoryUsedMB       uint64              `json:"memory_used_mb"`
+	MemoryUsagePercent float64             `json:"memory_usage_percent"`
+	SwapUsagePercent   float64             `json:"swap_usage_percent"`
+	Load1              float64             `json:"load_1"`
+	Load5              float64             `json:"load_5"`
+	Load15             float64             `json:"load_15"`
+	DiskUsagePercent   map[string]float64  `json:"disk_usage_percent,omitempty"` // mount → used %
+	NICs               map[string]NICRates `json:"nics,omitempty"`               // discovery interface → rates (see maxNICs)
+}
+
+// NICRates are per-second averages over a point's interval.
+type NICRates struct {
+	RxBytesPerSec   float64 `json:"rx_bytes_per_sec"`
+	TxBytesPerSec   float64 `json:"tx_bytes_per_sec"`
+	RxPacketsPerSec float64 `json:"rx_packets_per_sec"`
+	TxPacketsPerSec float64 `json:"tx_packets_per_sec"`
+	RxErrorsPerSec  float64 `json:"rx_errors_per_sec"`
+	TxErrorsPerSec  float64 `json:"tx_errors_per_sec"`
+}
+
+// Result is the GET …/metrics/history payload.
+type Result struct {
+	ResolutionSeconds int       `json:"resolution_seconds"`
+	StepSeconds       int       `json:"step_seconds"`
+	From              time.Time `json:"from"`
+	To                time.Time `json:"to"`
+	Points            []Point   `json:"points"`
+}
+
+type fileFormat struct {
+	ResolutionSeconds int     `json:"resolution_seconds"`
+	Points            []Point `json:"points"`
+}
+
+// History records one Point per resolution interval and keeps retention worth of them (oldest first).
+type History struct {
+	resolution time.Duration
+	retention  time.Duration
+	path       string // "" = memory only
+
+	mu        sync.RWMutex
+	points    []Point
+	dirty     bool
+	lastAt    time.Time
+	prevNet   map[string]hostinfo.NetInterface // counters at lastAt
+	prevCPU   hostinfo.CPUCounters             // /proc/stat counters at lastAt
+	prevCPUOK bool
+}
+
+// Open creates a history. With a non-empty deployBase the points are kept in {deployBase}/metrics/history.json:
+// loaded now (dropping points past retention, or all of them when they were recorded at another resolution) and written
+// by Run. A corrupt file is moved aside, as the host registry does.
+func Open(resolution, retention time.Duration, deployBase string) *History {
+	h := &History{resolution: resolution, retention: retention}
+	if deployBase == "" {
+		return h
+	}
+	h.path = filepath.Join(deployBase, "metrics", FileName)
+	data, err := os.ReadFile(h.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("metrics: read %s: %v", h.path, err)
+		}
+		return h
+	}
+	var f fileFormat
+	if err := json.Unmarshal(data, &f); err != nil {
+		aside := fmt.Sprintf("%s.corrupt-%d", h.path, time.Now().Unix())
+		log.Printf("metrics: %s is not valid JSON (%v); moved to %s", h.path, err, aside)
+		_ = os.Rename(h.path, aside)
+		return h
+	}
+	// Points of another resolution would be misread by Query's step and bucket math: start over rather than mix them.
+	if want := int(resolution / time.Second); f.ResolutionSeconds != want {
+		log.Printf("metrics: %s has %d point(s) at %ds resolution, configured %ds; discarding them", h.path, len(f.Points), f.ResolutionSeconds, want)
+		h.dirty = true
+		return h
+	}
+	h.points = f.Points
+	h.prune(time.Now())
+	log.Printf("metrics: loaded %d point(s) from %s", len(h.points), h.path)
+	return h
+}
+
+// Resolution returns the interval between points.
+func (h *History) Resolution() time.Duration {
+	return h.resolution
+}
+
+// Run records a point every resolution and, when persisted, writes the file every flushInterval, until stop is closed.
+// The file is written once more before Run returns: wait for it instead of calling Flush.
+func (h *History) Run(stop <-chan struct{}) {
+	h.baseline()
+	tick := time.NewTicker(h.resolution)
+	defer tick.Stop()
+	var flush <-chan time.Time
+	if h.path != "" {
+		t := time.NewTicker(flushInterval)
+		defer t.Stop()
+		flush = t.C
+	}
+	for {
+		select {
+		case <-stop:
+			if err := h.Flush(); err != nil {
+				log.Print(err)
+			}
+			return
+		case <-tick.C:
+			h.record()
+		case <-flush:
+			if err := h.Flush(); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+}
+
+// baseline takes the NIC counters the first point's rates start from.
+func (h *History) baseline() {
+	network := hostinfo.GetNetwork()
+	cpu, cpuOK := hostinfo.ReadCPUCounters()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastAt, h.prevNet = time.Now(), netByName(network)
+	h.prevCPU, h.prevCPUOK = cpu, cpuOK
+}
+
+func (h *History) record() {
+	info, _ := hostinfo.Get()
+	network := hostinfo.GetNetwork()
+	cpu, cpuOK := hostinfo.ReadCPUCounters()
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	p := Point{
+		Time:               now.UTC().Truncate(time.Second),
+		MemoryUsedMB:       info.MemoryUsedMB,
+		MemoryUsagePercent: info.MemoryUsagePercent,
+	}
+	// CPU: /proc/stat counter deltas since the previous point, so the whole interval counts at any resolution.
+	if cpuOK && h.prevCPUOK && cpu.Total > h.prevCPU.Total {
+		total := float64(cpu.Total - h.prevCPU.Total)
+		pct := func(prev, cur uint64) float64 {
+			if cur < prev {
+				return 0
+			}
+			return 100 * float64(cur-prev) / total
+		}
+		p.CPUUsagePercent = pct(h.prevCPU.Busy, cpu.Busy)
+		p.CPUIOWaitPercent = pct(h.prevCPU.IOWait, cpu.IOWait)
+		p.CPUStealPercent = pct(h.prevCPU.Steal, cpu.Steal)
+	} else {
+		p.CPUUsagePercent = info.CPUUsagePercent
+	}
+	if inv := hostinfo.GetInventory(); inv != nil {
+		p.Load1, p.Load5, p.Load15 = inv.LoadAverage1, inv.LoadAverage5, inv.LoadAverage15
+		p.SwapUsagePercent = inv.SwapUsagePercent
+		if len(inv.Disks) > 0 {
+			p.DiskUsagePercent = make(map[string]float64, len(inv.Disks))
+			for _, d := range inv.Disks {
+				p.DiskUsagePercent[d.Mount] = d.UsagePercent
+			}
+		}
+	}
+	cur := netByName(network)
+	if secs := now.Sub(h.lastAt).Seconds(); secs > 0 {
+		for name, c := range cur {
+			prev, ok := h.prevNet[name]
+			if !ok {
+				continue
+			}
+			if p.NICs == nil {
+				p.NICs = make(map[string]NICRates, len(cur))
+			}
+			p.NICs[name] = NICRates{
+				RxBytesPerSec:   rate(prev.RxBytes, c.RxBytes, secs),
+				TxBytesPerSec:   rate(prev.TxBytes, c.TxBytes, secs),
+				RxPacketsPerSec: rate(prev.RxPackets, c.RxPackets, secs),
+				TxPacketsPerSec: rate(prev.TxPackets, c.TxPackets, secs),
+				RxErrorsPerSec:  rate(prev.RxErrors, c.RxErrors, secs),
+				TxErrorsPerSec:  rate(prev.TxErrors, c.TxErrors, secs),
+			}
+		}
+	}
+	h.lastAt, h.prevNet = now, cur
+	h.prevCPU, h.prevCPUOK = cpu, cpuOK
+	h.points = append(h.points, p)
+	h.prune(now)
+	h.dirty = true
+}
+
+// prune drops points older than retention. Caller holds mu (or owns h).
+func (h *History) prune(now time.Time) {
+	cut := now.Add(-h.retention)
+	i := 0
+	for i < len(h.points) && h.points[i].Time.Before(cut) {
+		i++
+	}
+	if i > 0 {
+		h.points = append(h.points[:0:0], h.points[i:]...)
+	}
+}
+
+// netByName keeps the first maxNICs discovery interfaces of n (name order).
+func netByName(n *hostinfo.Network) map[string]hostinfo.NetInterface {
+	if n == nil {
+		return nil
+	}
+	out := make(map[string]hostinfo.NetInterface)
+	for _, ni := range n.Interfaces {
+		if !ni.Discovery {
+			continue
+		}
+		if len(out) == maxNICs {
+			break
+		}
+		out[ni.Name] = ni
+	}
+	return out
+}
+
+// rate is (cur-prev)/secs; 0 when the counter went backwards (interface re-created).
+func rate(prev, cur uint64, secs float64) float64 {
+	if cur < prev {
+		return 0
+	}
+	return float64(cur-prev) / secs
+}
+
+// Query returns the points in [from, to]. A step longer than the resolution averages the points of each step-long
+// bucket (aligned to multiples of step since the Unix epoch) into one point stamped with the bucket start.
+func (h *History) Query(from, to time.Time, step time.Duration) Result {
+	step = max(step, h.resolution)
+	res := Result{
+		ResolutionSeconds: int(h.resolution / time.Second),
+		StepSeconds:       int(step / time.Second),
+		From:              from.UTC(),
+		To:                to.UTC(),
+		Points:            []Point{},
+	}
+	h.mu.RLock()
+	var in []Point
+	for _, p := range h.points {
+		if !p.Time.Before(from) && !p.Time.After(to) {
+			in = append(in, p)
+		}
+	}
+	h.mu.RUnlock()
+	if step == h.resolution {
+		res.Points = append(res.Points, in...)
+		return res
+	}
+	var bucket []Point
+	var start time.Time
+	for _, p := range in {
+		b := time.Unix(p.Time.Unix()-p.Time.Unix()%int64(step/time.Second), 0).UTC()
+		if len(bucket) > 0 && !b.Equal(start) {
+			res.Points = append(res.Points, average(start, bucket))
+			bucket = bucket[:0]
+		}
+		start = b
+		bucket = append(bucket, p)
+	}
+	if len(bucket) > 0 {
+		res.Points = append(res.Points, average(start, bucket))
+	}
+	return res
+}
+
+// average merges points into one stamped t. Disk and NIC keys are averaged over the points that have them.
+func average(t time.Time, ps []Point) Point {
+	out := Point{Time: t}
+	var memUsed uint64
+	diskN := make(map[string]int)
+	nicN := make(map[string]int)
+	for _, p := range ps {
+		out.CPUUsagePercent += p.CPUUsagePercent
+		out.CPUIOWaitPercent += p.CPUIOWaitPercent
+		out.CPUStealPercent += p.CPUStealPercent
+		memUsed += p.MemoryUsedMB
+		out.MemoryUsagePercent += p.MemoryUsagePercent
+		out.SwapUsagePercent += p.SwapUsagePercent
+		out.Load1 += p.Load1
+		out.Load5 += p.Load5
+		out.Load15 += p.Load15
+		for k, v := range p.DiskUsagePercent {
+			if out.DiskUsagePercent == nil {
+				out.DiskUsagePercent = make(map[string]float64)
+			}
+			out.DiskUsagePercent[k] += v
+			diskN[k]++
+		}
+		for k, v := range p.NICs {
+			if out.NICs == nil {
+				out.NICs = make(map[string]NICRates)
+			}
+			r := out.NICs[k]
+			r.RxBytesPerSec += v.RxBytesPerSec
+			r.TxBytesPerSec += v.TxBytesPerSec
+			r.RxPacketsPerSec += v.RxPacketsPerSec
+			r.TxPacketsPerSec += v.TxPacketsPerSec
+			r.RxErrorsPerSec += v.RxErrorsPerSec
+			r.TxErrorsPerSec += v.TxErrorsPerSec
+			out.NICs[k] = r
+			nicN[k]++
+		}
+	}
+	n := float64(len(ps))
+	out.CPUUsagePercent /= n
+	out.CPUIOWaitPercent /= n
+	out.CPUStealPercent /= n
+	out.MemoryUsedMB = memUsed / uint64(len(ps))
+	out.MemoryUsagePercent /= n
+	out.SwapUsagePercent /= n
+	out.Load1 /= n
+	out.Load5 /= n
+	out.Load15 /= n
+	for k, c := range diskN {
+		out.DiskUsagePercent[k] /= float64(c)
+	}
+	for k, c := range nicN {
+		r, d := out.NICs[k], float64(c)
+		out.NICs[k] = NICRates{
+			RxBytesPerSec:   r.RxBytesPerSec / d,
+			TxBytesPerSec:   r.TxBytesPerSec / d,
+			RxPacketsPerSec: r.RxPacketsPerSec / d,
+			TxPacketsPerSec: r.TxPacketsPerSec / d,
+			RxErrorsPerSec:  r.RxErrorsPerSec / d,
+			TxErrorsPerSec:  r.TxErrorsPerSec / d,
+		}
+	}
+	return out
+}
+
+// Flush writes the history file if points were added since the last write (no-op when not persisted).
+func (h *History) Flush() error {
+	if h.path == "" {
+		return nil
+	}
+	h.mu.Lock()
+	if !h.dirty {
+		h.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(fileFormat{ResolutionSeconds: int(h.resolution / time.Second), Points: h.points})
+	h.dirty = false
+	h.mu.Unlock()
+	if err == nil {
+		err = atomicfile.Write(h.path, data)
+	}
+	if err != nil {
+		h.mu.Lock()
+		h.dirty = true
+		h.mu.Unlock()
+		return fmt.Errorf("metrics: write %s: %w", h.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,539 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"contrabass-agent/maintenance/discovery"
+	"contrabass-agent/maintenance/hostinfo"
+)
+
+// metricsContentType is the Prometheus text exposition format (0.0.4).
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// operationDurationBuckets are the histogram upper bounds (seconds) for upload and apply-update requests.
+// Remote applies upload a bundle and wait for the target's restart, so the range runs to minutes.
+var operationDurationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// maxHealthTargets bounds the remote health series; targets beyond it are not tracked (ip is caller-supplied).
+const maxHealthTargets = 256
+
+// serverMetrics holds what GET /metrics reports that is not read from elsewhere at scrape time:
+// upload / apply-update outcomes and durations, and remote health check results.
+type serverMetrics struct {
+	mu     sync.Mutex
+	ops    map[operationKey]*operationStats
+	health map[string]*healthStats
+}
+
+// operationKey: op is "upload" or "apply"; target "local" or "remote"; result the envelope status ("success" / "fail").
+type operationKey struct {
+	op, target, result string
+}
+
+type operationStats struct {
+	count   uint64
+	sum     float64
+	buckets []uint64 // per operationDurationBuckets, non-cumulative
+}
+
+type healthStats struct {
+	success, fail uint64
+	up            bool
+	at            time.Time
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		ops:    make(map[operationKey]*operationStats),
+		health: make(map[string]*healthStats),
+	}
+}
+
+func (m *serverMetrics) observeOperation(k operationKey, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.ops[k]
+	if st == nil {
+		st = &operationStats{buckets: make([]uint64, len(operationDurationBuckets))}
+		m.ops[k] = st
+	}
+	sec := d.Seconds()
+	st.count++
+	st.sum += sec
+	if i, _ := slices.BinarySearch(operationDurationBuckets, sec); i < len(st.buckets) {
+		st.buckets[i]++
+	}
+}
+
+func (m *serverMetrics) observeHealth(target string, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.health[target]
+	if st == nil {
+		if len(m.health) >= maxHealthTargets {
+			return
+		}
+		st = &healthStats{}
+		m.health[target] = st
+	}
+	if ok {
+		st.success++
+	} else {
+		st.fail++
+	}
+	st.up, st.at = ok, time.Now()
+}
+
+// outcomeWriter remembers the envelope status s.send wrote, so a wrapper can label the request by result.
+type outcomeWriter struct {
+	http.ResponseWriter
+	status string
+	target string // set by the handler (markRemote) when the request acted on another host
+}
+
+// markRemote labels an instrumented request as acting on a remote host. No-op for other writers.
+func markRemote(w http.ResponseWriter) {
+	if ow, ok := w.(*outcomeWriter); ok {
+		ow.target = "remote"
+	}
+}
+
+// instrumentOperation counts POST requests to h by target and result, with their duration.
+func (s *Server) instrumentOperation(op string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			h(w, r)
+			return
+		}
+		start := time.Now()
+		ow := &outcomeWriter{ResponseWriter: w, target: "local"}
+		h(ow, r)
+		s.metrics.observeOperation(operationKey{op: op, target: ow.target, result: resultLabel(ow.status)}, time.Since(start))
+	}
+}
+
+// instrumentRemoteHealth records each remote health check's result per target ip.
+func (s *Server) instrumentRemoteHealth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := strings.TrimSpace(r.URL.Query().Get("ip"))
+		if r.Method != http.MethodGet || ip == "" || ip == "self" {
+			h(w, r)
+			return
+		}
+		ow := &outcomeWriter{ResponseWriter: w}
+		h(ow, r)
+		s.metrics.observeHealth(ip, ow.status == "success")
+	}
+}
+
+func resultLabel(status string) string {
+	if status == "success" {
+		return "success"
+	}
+	return "fail"
+}
+
+// handleMetrics GET /metrics serves host, discovery, update and agent metrics in the Prometheus text format.
+// Host values are read at scrape time; every section is best-effort and left out when its source is unavailable.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/metrics" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p := &promWriter{}
+	s.writeBuildMetrics(p)
+	if s.getHostInfo != nil {
+		if info, err := s.getHostInfo(); err == nil {
+			writeHostMetrics(p, info)
+		}
+	}
+	if s.discovery != nil {
+		writeDiscoveryMetrics(p, s.discovery.Stats())
+	}
+	s.writeOperationMetrics(p)
+	s.writeRemoteHealthMetrics(p)
+	s.writeUpdateHistoryMetrics(p)
+	w.Header().Set("Content-Type", metricsContentType)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(p.buf.Bytes())
+	}
+}
+
+func (s *Server) writeBuildMetrics(p *promWriter) {
+	v := s.version
+	if v == "" {
+		v = "0.0.0-0"
+	}
+	p.family("contrabass_build_info", "gauge", "Agent build: version key and Go version. Always 1.")
+	p.sample("contrabass_build_info", 1, "version", v, "goversion", runtime.Version())
+}
+
+func writeHostMetrics(p *promWriter, info hostinfo.Info) {
+	const mb = 1 << 20
+	p.family("contrabass_host_info", "gauge", "Host identity. Always 1.")
+	labels := []string{"hostname", info.Hostname, "host_ip", info.HostIP}
+	if inv := info.Inventory; inv != nil {
+		labels = append(labels, "kernel", inv.KernelVersion, "os", inv.OS.PrettyName)
+	}
+	p.sample("contrabass_host_info", 1, labels...)
+
+	p.gauge("contrabass_host_cpu_usage_percent", "CPU usage over the last sampler interval, percent of all CPU time.", info.CPUUsagePercent)
+	if c := info.CPU; c != nil {
+		p.family("contrabass_host_cpu_mode_percent", "gauge", "CPU time by mode over the last sampler interval, percent.")
+		for _, m := range []struct {
+			mode string
+			v    float64
+		}{
+			{"user", c.UserPercent}, {"system", c.SystemPercent}, {"iowait", c.IOWaitPercent},
+			{"irq", c.IRQPercent}, {"softirq", c.SoftIRQPercent}, {"steal", c.StealPercent},
+		} {
+			p.sample("contrabass_host_cpu_mode_percent", m.v, "mode", m.mode)
+		}
+		p.family("contrabass_host_cpu_core_usage_percent", "gauge", "Per-CPU usage over the last sampler interval, percent.")
+		for i, v := range c.PerCore {
+			p.sample("contrabass_host_cpu_core_usage_percent", v, "cpu", strconv.Itoa(i))
+		}
+	}
+	p.gauge("contrabass_host_memory_total_bytes", "Total memory.", float64(info.MemoryTotalMB*mb))
+	p.gauge("contrabass_host_memory_used_bytes", "Used memory (total - available).", float64(info.MemoryUsedMB*mb))
+
+	if inv := info.Inventory; inv != nil {
+		p.gauge("contrabass_host_load1", "1-minute load average.", inv.LoadAverage1)
+		p.gauge("contrabass_host_load5", "5-minute load average.", inv.LoadAverage5)
+		p.gauge("contrabass_host_load15", "15-minute load average.", inv.LoadAverage15)
+		p.gauge("contrabass_host_uptime_seconds", "Time since boot.", float64(inv.UptimeSeconds))
+		p.gauge("contrabass_host_boot_time_seconds", "Boot time, unix seconds.", float64(inv.BootTime))
+		p.gauge("contrabass_host_swap_total_bytes", "Total swap.", float64(inv.SwapTotalMB*mb))
+		p.gauge("contrabass_host_swap_used_bytes", "Used swap.", float64(inv.SwapUsedMB*mb))
+
+		disks := []struct {
+			name, help string
+			v          func(d hostinfo.DiskUsage) float64
+		}{
+			{"contrabass_host_filesystem_size_bytes", "Filesystem size.", func(d hostinfo.DiskUsage) float64 { return float64(d.TotalMB * mb) }},
+			{"contrabass_host_filesystem_used_bytes", "Filesystem space used.", func(d hostinfo.DiskUsage) float64 { return float64(d.UsedMB * mb) }},
+			{"contrabass_host_filesystem_avail_bytes", "Filesystem space available to unprivileged users.", func(d hostinfo.DiskUsage) float64 { return float64(d.AvailableMB * mb) }},
+			{"contrabass_host_filesystem_usage_percent", "Filesystem usage as df reports it.", func(d hostinfo.DiskUsage) float64 { return d.UsagePercent }},
+			{"contrabass_host_filesystem_files", "Filesystem inodes (0 without a fixed inode count).", func(d hostinfo.DiskUsage) float64 { return float64(d.Inodes) }},
+			{"contrabass_host_filesystem_files_used", "Filesystem inodes used.", func(d hostinfo.DiskUsage) float64 { return float64(d.InodesUsed) }},
+		}
+		for _, m := range disks {
+			p.family(m.name, "gauge", m.help)
+			for _, d := range inv.Disks {
+				p.sample(m.name, m.v(d), "mount", d.Mount, "device", d.Device, "fstype", d.FSType)
+			}
+		}
+	}
+
+	if n := info.Network; n != nil {
+		p.family("contrabass_host_network_up", "gauge", "1 when the interface operstate is up.")
+		for _, ni := range n.Interfaces {
+			up := 0.0
+			if ni.OperState == "up" {
+				up = 1
+			}
+			p.sample("contrabass_host_network_up", up, "interface", ni.Name)
+		}
+		nics := []struct {
+			name, help string
+			v          func(ni hostinfo.NetInterface) uint64
+		}{
+			{"contrabass_host_network_receive_bytes_total", "Bytes received.", func(ni hostinfo.NetInterface) uint64 { return ni.RxBytes }},
+			{"contrabass_host_network_transmit_bytes_total", "Bytes transmitted.", func(ni hostinfo.NetInterface) uint64 { return ni.TxBytes }},
+			{"contrabass_host_network_receive_packets_total", "Packets received.", func(ni hostinfo.NetInterface) uint64 { return ni.RxPackets }},
+			{"contrabass_host_network_transmit_packets_total", "Packets transmitted.", func(ni hostinfo.NetInterface) uint64 { return ni.TxPackets }},
+			{"contrabass_host_network_receive_errors_total", "Receive errors.", func(ni hostinfo.NetInterface) uint64 { return ni.RxErrors }},
+			{"contrabass_host_network_transmit_errors_total", "Transmit errors.", func(ni hostinfo.NetInterface) uint64 { return ni.TxErrors }},
+		}
+		for _, m := range nics {
+			p.family(m.name, "counter", m.help)
+			for _, ni := range n.Interfaces {
+				p.sample(m.name, float64(m.v(ni)), "interface", ni.Name)
+			}
+		}
+	}
+}
+
+// discoveryCounterMetrics maps DiscoveryCounters to metric names; each is exported per interface
+// (every packet is attributed to exactly one, so sum() gives the total).
+var discoveryCounterMetrics = []struct {
+	name, help string
+	v          func(c discovery.DiscoveryCounters) uint64
+}{
+	{"contrabass_discovery_requests_received_total", "DISCOVERY_REQUEST and DISCOVERY_RELAY_REQUEST packets received.", func(c discovery.DiscoveryCounters) uint64 { return c.RequestsReceived }},
+	{"contrabass_discovery_requests_answered_total", "Requests answered with a DISCOVERY_RESPONSE (or relayed).", func(c discovery.DiscoveryCounters) uint64 { return c.RequestsAnswered }},
+	{"contrabass_discovery_requests_filtered_total", "Requests whose filter did not match this host.", func(c discovery.DiscoveryCounters) uint64 { return c.RequestsFiltered }},
+	{"contrabass_discovery_responses_received_total", "DISCOVERY_RESPONSE packets received.", func(c discovery.DiscoveryCounters) uint64 { return c.ResponsesReceived }},
+	{"contrabass_discovery_responses_delivered_total", "Responses handed to a running discovery.", func(c discovery.DiscoveryCounters) uint64 { return c.ResponsesDelivered }},
+	{"contrabass_discovery_dropped_channel_full_total", "Responses dropped because the run's channel was full.", func(c discovery.DiscoveryCounters) uint64 { return c.DroppedChannelFull }},
+	{"contrabass_discovery_dropped_stale_total", "Responses with no run waiting for their request_id.", func(c discovery.DiscoveryCounters) uint64 { return c.DroppedStale }},
+	{"contrabass_discovery_service_mismatch_total", "Packets for another DiscoveryServiceName.", func(c discovery.DiscoveryCounters) uint64 { return c.ServiceMismatch }},
+	{"contrabass_discovery_parse_failures_total", "Packets that were not a valid discovery message.", func(c discovery.DiscoveryCounters) uint64 { return c.ParseFailures }},
+	{"contrabass_discovery_auth_dropped_total", "Packets that failed signed-mode checks.", func(c discovery.DiscoveryCounters) uint64 { return c.AuthDropped }},
+	{"contrabass_discovery_send_errors_total", "DISCOVERY_RESPONSE writes that failed.", func(c discovery.DiscoveryCounters) uint64 { return c.SendErrors }},
+}
+
+func writeDiscoveryMetrics(p *promWriter, st discovery.DiscoveryStats) {
+	ifaces := make([]string, 0, len(st.Interfaces))
+	for name := range st.Interfaces {
+		ifaces = append(ifaces, name)
+	}
+	slices.Sort(ifaces)
+	for _, m := range discoveryCounterMetrics {
+		p.family(m.name, "counter", m.help)
+		for _, name := range ifaces {
+			p.sample(m.name, float64(m.v(st.Interfaces[name])), "interface", name)
+		}
+	}
+	p.family("contrabass_discovery_auth_failures_total", "counter", "Signed-mode drops by reason.")
+	p.sample("contrabass_discovery_auth_failures_total", float64(st.Auth.Unsigned), "reason", "unsigned")
+	p.sample("contrabass_discovery_auth_failures_total", float64(st.Auth.BadSignature), "reason", "bad_signature")
+	p.sample("contrabass_discovery_auth_failures_total", float64(st.Auth.Stale), "reason", "stale")
+	p.sample("contrabass_discovery_auth_failures_total", float64(st.Auth.Replayed), "reason", "replayed")
+	p.family("contrabass_discovery_responder_answered_total", "counter", "Requests the responder limits let through.")
+	p.sample("contrabass_discovery_responder_answered_total", float64(st.Responder.Answered))
+	p.family("contrabass_discovery_responder_dropped_total", "counter", "Requests dropped by the responder limits, by reason.")
+	p.sample("contrabass_discovery_responder_dropped_total", float64(st.Responder.DroppedNotAllowed), "reason", "not_allowed")
+	p.sample("contrabass_discovery_responder_dropped_total", float64(st.Responder.DroppedReplyPort), "reason", "reply_port")
+	p.sample("contrabass_discovery_responder_dropped_total", float64(st.Responder.DroppedSourceRate), "reason", "source_rate")
+	p.sample("contrabass_discovery_responder_dropped_total", float64(st.Responder.DroppedGlobalBudget), "reason", "global_budget")
+}
+
+func (s *Server) writeOperationMetrics(p *promWriter) {
+	s.metrics.mu.Lock()
+	defer s.metrics.mu.Unlock()
+	keys := make([]operationKey, 0, len(s.metrics.ops))
+	for k := range s.metrics.ops {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b operationKey) int {
+		return strings.Compare(a.op+"\x00"+a.target+"\x00"+a.result, b.op+"\x00"+b.target+"\x00"+b.result)
+	})
+	for _, op := range []struct{ op, help string }{
+		{"upload", "POST …/upload (bundle staging)"},
+		{"apply", "POST …/apply-update"},
+	} {
+		total := "contrabass_" + op.op + "_requests_total"
+		p.family(total, "counter", op.help+" requests by target and result.")
+		for _, k := range keys {
+			if k.op == op.op {
+				p.sample(total, float64(s.metrics.ops[k].count), "target", k.target, "result", k.result)
+			}
+		}
+		hist := "contrabass_" + op.op + "_duration_seconds"
+		p.family(hist, "histogram", op.help+" request duration by target and result.")
+		for _, k := range keys {
+			if k.op != op.op {
+				continue
+			}
+			st := s.metrics.ops[k]
+			var cum uint64
+			for i, le := range operationDurationBuckets {
+				cum += st.buckets[i]
+				p.sample(hist+"_bucket", float64(cum), "target", k.target, "result", k.result, "le", formatFloat(le))
+			}
+			p.sample(hist+"_bucket", float64(st.count), "target", k.target, "result", k.result, "le", "+Inf")
+			p.sample(hist+"_sum", st.sum, "target", k.target, "result", k.result)
+			p.sample(hist+"_count", float64(st.count), "target", k.target, "result", k.result)
+		}
+	}
+}
+
+func (s *Server) writeRemoteHealthMetrics(p *promWriter) {
+	s.metrics.mu.Lock()
+	defer s.metrics.mu.Unlock()
+	targets := make([]string, 0, len(s.metrics.health))
+	for t := range s.metrics.health {
+		targets = append(targets, t)
+	}
+	slices.Sort(targets)
+	p.family("contrabass_remote_health_checks_total", "counter", "GET …/remote-health-check results by target.")
+	for _, t := range targets {
+		st := s.metrics.health[t]
+		p.sample("contrabass_remote_health_checks_total", float64(st.success), "target", t, "result", "success")
+		p.sample("contrabass_remote_health_checks_total", float64(st.fail), "target", t, "result", "fail")
+	}
+	p.family("contrabass_remote_health_up", "gauge", "1 when the target's last health check succeeded.")
+	for _, t := range targets {
+		up := 0.0
+		if s.metrics.health[t].up {
+			up = 1
+		}
+		p.sample("contrabass_remote_health_up", up, "target", t)
+	}
+	p.family("contrabass_remote_health_last_check_timestamp_seconds", "gauge", "Time of the target's last health check, unix seconds.")
+	for _, t := range targets {
+		p.sample("contrabass_remote_health_last_check_timestamp_seconds", float64(s.metrics.health[t].at.Unix()), "target", t)
+	}
+}
+
+// updateHistoryCounts is update_history.log tallied by kind ("update" / "rollback") and result.
+// Rollbacks update.sh runs after a failed update are trigger "auto"; rollback.sh runs are "manual".
+type updateHistoryCounts struct {
+	updates   map[string]uint64 // started, success, fail
+	rollbacks map[[2]string]uint64
+	last      map[[2]string]time.Time // {kind, result} → newest line
+}
+
+// parseUpdateHistory reads the lines update.sh and rollback.sh prepend ("[YYYY-MM-DD HH:MM:SS] msg", local time).
+// Unknown lines are skipped. The counts cover what the file holds, so they drop if it is truncated.
+func parseUpdateHistory(data []byte) updateHistoryCounts {
+	c := updateHistoryCounts{
+		updates:   make(map[string]uint64),
+		rollbacks: make(map[[2]string]uint64),
+		last:      make(map[[2]string]time.Time),
+	}
+	note := func(kind, result string, at time.Time) {
+		k := [2]string{kind, result}
+		if at.After(c.last[k]) {
+			c.last[k] = at
+		}
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		ts, msg, ok := strings.Cut(strings.TrimPrefix(line, "["), "] ")
+		if !ok || !strings.HasPrefix(line, "[") {
+			continue
+		}
+		at, _ := time.ParseInLocation("2006-01-02 15:04:05", ts, time.Local)
+		switch {
+		case strings.HasPrefix(msg, "update "):
+			rest := msg[len("update "):]
+			var result string
+			switch {
+			case strings.HasSuffix(rest, " started"):
+				result = "started"
+			case strings.HasSuffix(rest, " success"):
+				result = "success"
+			case strings.Contains(rest, " failed"):
+				result = "fail"
+				if strings.HasSuffix(rest, ", rollback") {
+					c.rollbacks[[2]string{"auto", "started"}]++
+				}
+			default:
+				continue
+			}
+			c.updates[result]++
+			note("update", result, at)
+		case msg == "rollback completed":
+			c.rollbacks[[2]string{"auto", "success"}]++
+			note("rollback", "success", at)
+		case msg == "rollback started":
+			c.rollbacks[[2]string{"manual", "started"}]++
+			note("rollback", "started", at)
+		case msg == "rollback success":
+			c.rollbacks[[2]string{"manual", "success"}]++
+			note("rollback", "success", at)
+		case strings.HasPrefix(msg, "rollback failed"):
+			c.rollbacks[[2]string{"manual", "fail"}]++
+			note("rollback", "fail", at)
+		}
+	}
+	return c
+}
+
+func (s *Server) writeUpdateHistoryMetrics(p *promWriter) {
+	base := s.deployBase
+	if base == "" {
+		base = "/var/lib/contrabass/mole"
+	}
+	data, err := os.ReadFile(filepath.Join(base, "update_history.log"))
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+	c := parseUpdateHistory(data)
+	p.family("contrabass_updates_total", "counter", "Update outcomes recorded in update_history.log.")
+	for _, result := range []string{"started", "success", "fail"} {
+		p.sample("contrabass_updates_total", float64(c.updates[result]), "result", result)
+	}
+	p.family("contrabass_rollbacks_total", "counter", "Rollback outcomes recorded in update_history.log (auto: after a failed update; manual: rollback.sh).")
+	for _, k := range [][2]string{
+		{"auto", "started"}, {"auto", "success"},
+		{"manual", "started"}, {"manual", "success"}, {"manual", "fail"},
+	} {
+		p.sample("contrabass_rollbacks_total", float64(c.rollbacks[k]), "trigger", k[0], "result", k[1])
+	}
+	p.family("contrabass_update_last_event_timestamp_seconds", "gauge", "Time of the newest update_history.log line of each kind and result, unix seconds.")
+	keys := make([][2]string, 0, len(c.last))
+	for k := range c.last {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b [2]string) int { return strings.Compare(a[0]+"\x00"+a[1], b[0]+"\x00"+b[1]) })
+	for _, k := range keys {
+		if t := c.last[k]; !t.IsZero() {
+			p.sample("contrabass_update_last_event_timestamp_seconds", float64(t.Unix()), "kind", k[0], "result", k[1])
+		}
+	}
+}
+
+// promWriter builds a text exposition body. Callers write each family's HELP/TYPE once, then its samples.
+type promWriter struct {
+	buf bytes.Buffer
+}
+
+func (p *promWriter) family(name, typ, help string) {
+	fmt.Fprintf(&p.buf, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, typ)
+}
+
+// sample writes one line; labels are name/value pairs.
+func (p *promWriter) sample(name string, v float64, labels ...string) {
+	p.buf.WriteString(name)
+	if len(labels) > 0 {
+		p.buf.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				p.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&p.buf, "%s=\"%s\"", labels[i], escapeLabelValue(labels[i+1]))
+		}
+		p.buf.WriteByte('}')
+	}
+	p.buf.WriteByte(' ')
+	p.buf.WriteString(formatFloat(v))
+	p.buf.WriteByte('\n')
+}
+
+// gauge writes a single unlabelled gauge family.
+func (p *promWriter) gauge(name, help string, v float64) {
+	p.family(name, "gauge", help)
+	p.sample(name, v)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string       { return helpEscaper.Replace(s) }
+func escapeLabelValue(s string) string { return labelEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
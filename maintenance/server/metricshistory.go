@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxMetricsHistoryPoints bounds one GET …/metrics/history answer; longer ranges need a larger step.
+const maxMetricsHistoryPoints = 5000
+
+// handleMetricsHistory GET ?ip=&from=&to=&step= returns the host metrics history (Maintenance.MetricsHistory).
+//
+//	ip   — empty or self: this host; otherwise the same request to the remote agent's Gin port (without ip).
+//	from — unix seconds, RFC3339 or a duration before now ("-6h", "90m"); default to - 1h.
+//	to   — same forms; default now.
+//	step — seconds or a Go duration ("5m"); default and minimum the recording resolution. Points in each step are averaged.
+func (s *Server) handleMetricsHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.send(w, "fail", nil, http.StatusMethodNotAllowed)
+		return
+	}
+	q := requestQueryValues(r)
+	if ip := strings.TrimSpace(q.Get("ip")); ip != "" && ip != "self" {
+		q.Del("ip")
+		s.proxyMetricsHistory(w, ip, q.Encode())
+		return
+	}
+	if s.metricsHistory == nil {
+		s.send(w, "fail", "메트릭 이력이 비활성화되어 있습니다 (Maintenance.MetricsHistory.Enabled).", http.StatusServiceUnavailable)
+		return
+	}
+	now := time.Now()
+	to, err := parseMetricsTime(q.Get("to"), now, now)
+	if err != nil {
+		s.send(w, "fail", "to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, err := parseMetricsTime(q.Get("from"), to.Add(-time.Hour), now)
+	if err != nil {
+		s.send(w, "fail", "from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !from.Before(to) {
+		s.send(w, "fail", "from must be before to", http.StatusBadRequest)
+		return
+	}
+	step := s.metricsHistory.Resolution()
+	if v := strings.TrimSpace(q.Get("step")); v != "" {
+		d, err := parseMetricsStep(v)
+		if err != nil {
+			s.send(w, "fail", "step: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		step = max(d, step)
+	}
+	if n := to.Sub(from) / step; n > maxMetricsHistoryPoints {
+		s.send(w, "fail", fmt.Sprintf("range is %d steps (max %d); use a larger step", n, maxMetricsHistoryPoints), http.StatusBadRequest)
+		return
+	}
+	s.send(w, "success", s.metricsHistory.Query(from, to, step), http.StatusOK)
+}
+
+// proxyMetricsHistory forwards the query to the remote agent and relays its envelope (as service-status does).
+func (s *Server) proxyMetricsHistory(w http.ResponseWriter, ip, rawQuery string) {
+	baseURL, err := s.remoteBaseURL(ip)
+	if err != nil {
+		s.send(w, "fail", "원격 메트릭 이력 요청 실패: "+err.Error(), http.StatusOK)
+		return
+	}
+	u := baseURL + s.apiPrefix + "/metrics/history"
+	if rawQuery != "" {
+		u += "?" + rawQuery
+	}
+	resp, err := remoteHTTPClient.Get(u)
+	if err != nil {
+		s.send(w, "fail", "원격 메트릭 이력 요청 실패: "+err.Error(), http.StatusOK)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound {
+		s.send(w, "fail", "원격 에이전트가 메트릭 이력을 지원하지 않습니다.", http.StatusOK)
+		return
+	}
+	var out APIResponse
+	if json.Unmarshal(body, &out) != nil {
+		s.send(w, "fail", "원격 응답 파싱 실패", http.StatusOK)
+		return
+	}
+	s.send(w, out.Status, out.Data, http.StatusOK)
+}
+
+// parseMetricsTime accepts unix seconds, RFC3339, or a duration before now ("-6h" and "6h" both mean six hours ago).
+func parseMetricsTime(v string, def, now time.Time) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return def, nil
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(strings.TrimPrefix(v, "-")); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not unix seconds, RFC3339 or a duration like -6h", v)
+}
+
+// parseMetricsStep accepts whole seconds or a Go duration.
+func parseMetricsStep(v string) (time.Duration, error) {
+	if sec, err := strconv.Atoi(v); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil && d >= time.Second {
+		return d.Truncate(time.Second), nil
+	}
+	return 0, fmt.Errorf("%q is not a positive number of seconds or a duration like 5m", v)
+}
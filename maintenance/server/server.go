@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -24,6 +26,8 @@ import (
 	"contrabass-agent/maintenance/discovery"
 	"contrabass-agent/maintenance/hostinfo"
 	"contrabass-agent/maintenance/hostinfoapi"
+	"contrabass-agent/maintenance/hostregistry"
+	"contrabass-agent/maintenance/metricshistory"
 	"contrabass-agent/maintenance/versionsapi"
 	"contrabass-agent/maintenance/svcstatus"
 )
@@ -114,17 +118,28 @@ type APIResponse struct {
 	Data   interface{} `json:"data"`
 }
 
+// DiscoveryAPIResponse is the GET {API}/discovery body: the usual envelope plus the duplicate identities seen in the run.
+type DiscoveryAPIResponse struct {
+	Status    string                        `json:"status"`
+	Data      []discovery.DiscoveryResponse `json:"data"`
+	Conflicts []discovery.Conflict          `json:"conflicts"`
+}
+
 // Server runs HTTP server (static + API).
 type Server struct {
 	webPrefix            string
 	apiPrefix            string
 	webFS                fs.FS
 	discovery            *discovery.Discovery
+	registry             *hostregistry.Registry
+	metricsHistory       *metricshistory.History
+	metrics              *serverMetrics // GET /metrics: upload/apply and remote health counters
 	getHostInfo          func() (hostinfo.Info, error)
 	version              string
 	servicePort          int
 	remoteProxyPort      int
 	discoveryServiceName string
+	labels               map[string]string
 	systemctlServiceName string
 	deployBase           string
 	installPrefix        string // contrabass-moleU 설치 경로 prefix (versions/ 기준). 비면 deployBase 사용
@@ -143,11 +158,14 @@ type Config struct {
 	APIPrefix            string
 	WebFS                fs.FS
 	Discovery            *discovery.Discovery
+	Registry             *hostregistry.Registry // persistent host registry ({DeployBase}/registry/hosts.json); nil disables …/registry APIs
+	MetricsHistory       *metricshistory.History // Maintenance.MetricsHistory; nil disables local GET …/metrics/history
 	GetHostInfo          func() (hostinfo.Info, error)
 	Version              string
 	ServicePort          int
 	RemoteProxyPort      int // external proxy port (Gin). should be Server.HTTPPort
 	DiscoveryServiceName string
+	Labels               map[string]string // Maintenance.Labels, returned by GET /self
 	SystemctlServiceName string
 	DeployBase           string
 	InstallPrefix        string // contrabass-moleU 설치 경로 prefix. 비면 DeployBase 사용 (versions 목록·삭제, installer)
@@ -167,11 +185,15 @@ func New(cfg Config) *Server {
 		apiPrefix:            strings.TrimSuffix(cfg.APIPrefix, "/"),
 		webFS:                cfg.WebFS,
 		discovery:            cfg.Discovery,
+		registry:             cfg.Registry,
+		metricsHistory:       cfg.MetricsHistory,
+		metrics:              newServerMetrics(),
 		getHostInfo:          cfg.GetHostInfo,
 		version:              cfg.Version,
 		servicePort:          cfg.ServicePort,
 		remoteProxyPort:      cfg.RemoteProxyPort,
 		discoveryServiceName: cfg.DiscoveryServiceName,
+		labels:               cfg.Labels,
 		systemctlServiceName: cfg.SystemctlServiceName,
 		deployBase:           strings.TrimSuffix(cfg.DeployBase, "/"),
 		installPrefix:        strings.TrimSuffix(cfg.InstallPrefix, "/"),
@@ -206,41 +228,61 @@ func (s *Server) remoteBaseURL(ip string) (string, error) {
 	if port <= 0 || port > 65535 {
 		return "", fmt.Errorf("Server.HTTPPort must be 1..65535")
 	}
-	return "http://" + ip + ":" + strconv.Itoa(port), nil
+	// IPv6 needs brackets; a link-local zone ("fe80::1%eth0") must be escaped as %25 in URLs.
+	return "http://" + net.JoinHostPort(strings.Replace(ip, "%", "%25", 1), strconv.Itoa(port)), nil
+}
+
+// remoteSelf is the part of a remote agent's GET {APIPrefix}/self used before calling its update APIs.
+type remoteSelf struct {
+	Version      string   `json:"version"`
+	ProtoVersion int      `json:"proto_version"`
+	Capabilities []string `json:"capabilities"`
 }
 
-// fetchRemoteVersionKey returns the remote agent's version key from GET {APIPrefix}/self.
-func (s *Server) fetchRemoteVersionKey(ip string) (string, error) {
+// fetchRemoteSelf returns the remote agent's version key, protocol version and capabilities from GET {APIPrefix}/self.
+func (s *Server) fetchRemoteSelf(ip string) (remoteSelf, error) {
 	baseURL, err := s.remoteBaseURL(ip)
 	if err != nil {
-		return "", err
+		return remoteSelf{}, err
 	}
 	u := baseURL + s.apiPrefix + "/self"
 	resp, err := remoteHTTPClient.Get(u)
 	if err != nil {
-		return "", err
+		return remoteSelf{}, err
 	}
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return remoteSelf{}, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+		return remoteSelf{}, fmt.Errorf("HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
 	}
 	var out struct {
-		Status string `json:"status"`
-		Data   struct {
-			Version string `json:"version"`
-		} `json:"data"`
+		Status string     `json:"status"`
+		Data   remoteSelf `json:"data"`
 	}
 	if err := json.Unmarshal(body, &out); err != nil {
-		return "", err
+		return remoteSelf{}, err
 	}
 	if out.Status != "success" {
-		return "", fmt.Errorf("remote self: status %q", out.Status)
+		return remoteSelf{}, fmt.Errorf("remote self: status %q", out.Status)
 	}
-	return strings.TrimSpace(out.Data.Version), nil
+	out.Data.Version = strings.TrimSpace(out.Data.Version)
+	return out.Data, nil
+}
+
+// checkRemoteCapabilities fetches the remote /self and fails when the agent lacks any of want.
+// Legacy agents (no proto_version) pass for the features every released agent has (see discovery.HasCapability).
+func (s *Server) checkRemoteCapabilities(ip string, want ...string) error {
+	rs, err := s.fetchRemoteSelf(ip)
+	if err != nil {
+		return fmt.Errorf("원격 정보 조회 실패: %w", err)
+	}
+	if err := discovery.CheckCapabilities(rs.ProtoVersion, rs.Capabilities, want...); err != nil {
+		return fmt.Errorf("원격 에이전트가 이 요청을 지원하지 않습니다 (버전 %s): %w", rs.Version, err)
+	}
+	return nil
 }
 
 // looksLikeBrowser returns true if the request is likely from a browser (e.g. Accept: text/html or User-Agent: Mozilla/...).
@@ -372,20 +414,27 @@ func (s *Server) handleRemoteHealthCheck(w http.ResponseWriter, r *http.Request)
 func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/version", s.handleVersion)
+	mux.HandleFunc("/metrics", s.handleMetrics)
 	mux.HandleFunc("/", s.handleRoot)
 	// API
 	mux.HandleFunc(s.apiPrefix+"/self", s.handleSelf)
 	mux.HandleFunc(s.apiPrefix+"/health", s.handleHealth)
-	mux.HandleFunc(s.apiPrefix+"/remote-health-check", s.handleRemoteHealthCheck)
+	mux.HandleFunc(s.apiPrefix+"/remote-health-check", s.instrumentRemoteHealth(s.handleRemoteHealthCheck))
 	mux.HandleFunc(s.apiPrefix+"/host-info", s.handleHostInfo)
 	mux.HandleFunc(s.apiPrefix+"/discovery", s.handleDiscovery)
 	mux.HandleFunc(s.apiPrefix+"/discovery/stream", s.handleDiscoveryStream)
+	mux.HandleFunc(s.apiPrefix+"/discovery/stats", s.handleDiscoveryStats)
+	mux.HandleFunc(s.apiPrefix+"/peers", s.handlePeers)
+	mux.HandleFunc(s.apiPrefix+"/registry/hosts", s.handleRegistryHosts)
+	mux.HandleFunc(s.apiPrefix+"/registry/host", s.handleRegistryHost)
+	mux.HandleFunc(s.apiPrefix+"/registry/forget", s.handleRegistryForget)
+	mux.HandleFunc(s.apiPrefix+"/metrics/history", s.handleMetricsHistory)
 	mux.HandleFunc(s.apiPrefix+"/service-status", s.handleServiceStatus)
 	mux.HandleFunc(s.apiPrefix+"/service-control", s.handleServiceControl)
-	mux.HandleFunc(s.apiPrefix+"/upload", s.handleUpload)
+	mux.HandleFunc(s.apiPrefix+"/upload", s.instrumentOperation("upload", s.handleUpload))
 	mux.HandleFunc(s.apiPrefix+"/upload/remove", s.handleRemoveUpload)
 	mux.HandleFunc(s.apiPrefix+"/update-status", s.handleUpdateStatus)
-	mux.HandleFunc(s.apiPrefix+"/apply-update", s.handleApplyUpdate)
+	mux.HandleFunc(s.apiPrefix+"/apply-update", s.instrumentOperation("apply", s.handleApplyUpdate))
 	mux.HandleFunc(s.apiPrefix+"/update-log", s.handleUpdateLog)
 	mux.HandleFunc(s.apiPrefix+"/current-config", s.handleCurrentConfig)
 	mux.HandleFunc(s.apiPrefix+"/versions/list", s.handleVersionsList)
@@ -412,6 +461,8 @@ func (s *Server) handleSelf(w http.ResponseWriter, r *http.Request) {
 		Version:              s.version,
 		ServicePort:          s.servicePort,
 		DiscoveryServiceName: s.discoveryServiceName,
+		Labels:               s.labels,
+		Capabilities:         s.discovery.Capabilities(),
 	})
 	s.send(w, "success", data, http.StatusOK)
 }
@@ -432,7 +483,14 @@ func (s *Server) handleHostInfo(w http.ResponseWriter, r *http.Request) {
 		s.send(w, "fail", err.Error(), http.StatusOK)
 		return
 	}
-	s.send(w, "success", resp, http.StatusOK)
+	// The network, hardware and inventory sections are too large for UDP; take them from the target's own /self. Best effort: the host answered.
+	var sec hostinfoapi.RemoteSections
+	if baseURL, err := s.remoteBaseURL(ip); err == nil {
+		if sec, err = hostinfoapi.RemoteSelfSections(baseURL, s.apiPrefix); err != nil {
+			log.Printf("host-info: network, hardware and inventory sections of %s: %v", ip, err)
+		}
+	}
+	s.send(w, "success", sec.Attach(*resp), http.StatusOK)
 }
 
 // Query params for GET .../discovery and .../discovery/stream:
@@ -475,6 +533,40 @@ func parseDiscoveryRunOptions(r *http.Request) (discovery.DiscoveryRunOptions, e
 		}
 		opts.Timeout = time.Duration(sec) * time.Second
 	}
+	if v := strings.TrimSpace(q.Get("limit")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("limit must be a non-negative integer (hosts)")
+		}
+		opts.Limit = n
+	}
+	if v := strings.TrimSpace(q.Get("quiet_ms")); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms < 0 || ms > 600000 {
+			return opts, fmt.Errorf("quiet_ms must be between 0 and 600000 (milliseconds)")
+		}
+		opts.QuietPeriod = time.Duration(ms) * time.Millisecond
+	}
+	f := &discovery.DiscoveryFilter{
+		Hostname:   strings.TrimSpace(q.Get("hostname")),
+		MinVersion: strings.TrimSpace(q.Get("min_version")),
+		MaxVersion: strings.TrimSpace(q.Get("max_version")),
+		CPUUUID:    strings.TrimSpace(q.Get("cpu_uuid")),
+	}
+	// label may repeat and each value may hold several comma-separated selectors (label=role=db&label=env!=prod or label=role=db,env!=prod).
+	for _, v := range q["label"] {
+		sels, err := discovery.ParseLabelSelectors(v)
+		if err != nil {
+			return opts, err
+		}
+		f.Labels = append(f.Labels, sels...)
+	}
+	if !f.IsZero() {
+		if err := f.Validate(); err != nil {
+			return opts, err
+		}
+		opts.Filter = f
+	}
 	return opts, nil
 }
 
@@ -493,17 +585,24 @@ func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
 		s.send(w, "fail", err.Error(), http.StatusBadRequest)
 		return
 	}
-	list, err := s.discovery.DoDiscovery(opts)
+	list, conflicts, err := s.discovery.DoDiscovery(r.Context(), opts)
 	if err != nil {
 		log.Printf("discovery: ERROR: DoDiscovery failed: %v", err)
-		s.send(w, "fail", err.Error(), http.StatusInternalServerError)
+		code := http.StatusInternalServerError
+		if errors.Is(err, discovery.ErrRequestTooLarge) {
+			code = http.StatusBadRequest
+		}
+		s.send(w, "fail", err.Error(), code)
 		return
 	}
 	if list == nil {
 		list = []discovery.DiscoveryResponse{}
 	}
-	log.Printf("discovery API: returning %d host(s)", len(list))
-	s.send(w, "success", list, http.StatusOK)
+	log.Printf("discovery API: returning %d host(s), %d identity conflict(s)", len(list), len(conflicts))
+	// data stays the host array for existing clients; conflicts sits next to it in the envelope.
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(DiscoveryAPIResponse{Status: "success", Data: list, Conflicts: conflicts})
 }
 
 func (s *Server) handleDiscoveryStream(w http.ResponseWriter, r *http.Request) {
@@ -529,7 +628,8 @@ func (s *Server) handleDiscoveryStream(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	ch, err := s.discovery.DoDiscoveryStream(opts)
+	// r.Context() is cancelled when the browser closes the EventSource, which stops the run.
+	ch, err := s.discovery.DoDiscoveryStream(r.Context(), opts)
 	if err != nil {
 		// EventSource cannot read JSON error bodies on non-2xx; send a one-line SSE error event with 200 OK.
 		log.Printf("discovery: ERROR: DoDiscoveryStream failed: %v", err)
@@ -579,6 +679,91 @@ func (s *Server) handleDiscoveryStream(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleDiscoveryStats returns the discovery packet counters (total, per socket, per interface) and the signed-mode / responder-limit drops.
+func (s *Server) handleDiscoveryStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.send(w, "fail", nil, http.StatusMethodNotAllowed)
+		return
+	}
+	s.send(w, "success", s.discovery.Stats(), http.StatusOK)
+}
+
+// handlePeers returns the presence peer table (HELLO/heartbeat/BYE from other agents); no UDP round trip.
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.send(w, "fail", nil, http.StatusMethodNotAllowed)
+		return
+	}
+	s.send(w, "success", s.discovery.Peers(), http.StatusOK)
+}
+
+// handleRegistryHosts lists every host recorded in the persistent registry, most recently seen first.
+func (s *Server) handleRegistryHosts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.send(w, "fail", nil, http.StatusMethodNotAllowed)
+		return
+	}
+	if s.registry == nil {
+		s.send(w, "fail", "호스트 레지스트리가 비활성화되어 있습니다.", http.StatusServiceUnavailable)
+		return
+	}
+	s.send(w, "success", s.registry.List(), http.StatusOK)
+}
+
+// handleRegistryHost GET ?key=<cpu_uuid | noid:hostname> returns one registry record.
+func (s *Server) handleRegistryHost(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.send(w, "fail", nil, http.StatusMethodNotAllowed)
+		return
+	}
+	if s.registry == nil {
+		s.send(w, "fail", "호스트 레지스트리가 비활성화되어 있습니다.", http.StatusServiceUnavailable)
+		return
+	}
+	key := strings.TrimSpace(requestQueryValues(r).Get("key"))
+	if key == "" {
+		s.send(w, "fail", "key가 필요합니다.", http.StatusBadRequest)
+		return
+	}
+	h, ok := s.registry.Get(key)
+	if !ok {
+		s.send(w, "fail", "등록되지 않은 호스트: "+key, http.StatusNotFound)
+		return
+	}
+	s.send(w, "success", h, http.StatusOK)
+}
+
+// handleRegistryForget POST body: { "key": "<cpu_uuid | noid:hostname>" } — removes the record (it is re-added if the host is heard from again).
+func (s *Server) handleRegistryForget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.send(w, "fail", nil, http.StatusMethodNotAllowed)
+		return
+	}
+	if s.registry == nil {
+		s.send(w, "fail", "호스트 레지스트리가 비활성화되어 있습니다.", http.StatusServiceUnavailable)
+		return
+	}
+	var req struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Key) == "" {
+		s.send(w, "fail", "invalid body", http.StatusBadRequest)
+		return
+	}
+	removed, err := s.registry.Forget(req.Key)
+	if err != nil {
+		log.Printf("registry: forget %s: %v", req.Key, err)
+		s.send(w, "fail", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		s.send(w, "fail", "등록되지 않은 호스트: "+req.Key, http.StatusNotFound)
+		return
+	}
+	log.Printf("registry: forgot %s", req.Key)
+	s.send(w, "success", "삭제됨: "+strings.TrimSpace(req.Key), http.StatusOK)
+}
+
 func (s *Server) handleServiceStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		s.send(w, "fail", nil, http.StatusMethodNotAllowed)
@@ -1033,6 +1218,7 @@ func (s *Server) handleApplyUpdate(w http.ResponseWriter, r *http.Request) {
 
 	// 원격 전용: multipart(실행 파일+config+ip) → 원격 업로드 API로 전송 후 원격 apply-update API 호출 (로컬 스테이징·SCP 미사용)
 	if strings.Contains(r.Header.Get("Content-Type"), "multipart/form-data") {
+		markRemote(w)
 		r.Body = http.MaxBytesReader(w, r.Body, s.maxUploadBytes)
 		mr, err := r.MultipartReader()
 		if err != nil {
@@ -1096,6 +1282,10 @@ func (s *Server) handleApplyUpdate(w http.ResponseWriter, r *http.Request) {
 			s.send(w, "fail", "원격 적용 실패: "+err.Error(), http.StatusOK)
 			return
 		}
+		if err := s.checkRemoteCapabilities(ip, discovery.CapBundleUpload); err != nil {
+			s.send(w, "fail", err.Error(), http.StatusOK)
+			return
+		}
 		ctx, cancel := context.WithTimeout(context.Background(), 280*time.Second)
 		defer cancel()
 		if err := s.postUploadBundlePath(ctx, baseURL, s.apiPrefix, bundlePath); err != nil {
@@ -1154,6 +1344,7 @@ func (s *Server) handleApplyUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	markRemote(w)
 	s.doRemoteUpdate(w, ip, version, versionDir)
 }
 
@@ -1171,6 +1362,10 @@ func (s *Server) doRemoteUpdate(w http.ResponseWriter, ip, version, versionDir s
 		s.send(w, "fail", "버전 디렉터리에 실행 파일 "+appmeta.BinaryName+" 이 없습니다: "+versionDir, http.StatusOK)
 		return
 	}
+	if err := s.checkRemoteCapabilities(ip, discovery.CapBundleUpload); err != nil {
+		s.send(w, "fail", err.Error(), http.StatusOK)
+		return
+	}
 	if err := s.postUploadToTarget(ctx, baseURL, s.apiPrefix, versionDir); err != nil {
 		s.send(w, "fail", err.Error(), http.StatusOK)
 		return
@@ -1223,13 +1418,15 @@ func (s *Server) handleUpdateStatus(w http.ResponseWriter, r *http.Request) {
 
 	ip := strings.TrimSpace(r.URL.Query().Get("ip"))
 	var compareKey string
+	var remote remoteSelf
 	if ip != "" && ip != "self" {
-		rv, err := s.fetchRemoteVersionKey(ip)
+		rs, err := s.fetchRemoteSelf(ip)
 		if err != nil {
 			s.send(w, "fail", "원격 버전 조회 실패: "+err.Error(), http.StatusOK)
 			return
 		}
-		compareKey = strings.TrimSpace(rv)
+		remote = rs
+		compareKey = rs.Version
 	} else {
 		// Local: compare against the running agent (same as GET /self / GET /version), not only the current symlink.
 		// Otherwise symlink can already point at staging/versions key N while the process is still N-1 → can_apply stays false.
@@ -1262,6 +1459,12 @@ func (s *Server) handleUpdateStatus(w http.ResponseWriter, r *http.Request) {
 	if ip != "" && ip != "self" {
 		out["remote_ip"] = ip
 		out["remote_current_version"] = compareKey
+		out["remote_proto_version"] = remote.ProtoVersion
+		out["remote_capabilities"] = remote.Capabilities
+		if err := discovery.CheckCapabilities(remote.ProtoVersion, remote.Capabilities, discovery.CapBundleUpload); err != nil {
+			out["can_apply"] = false
+			out["apply_blocked_reason"] = "원격 에이전트가 번들 업로드를 지원하지 않습니다: " + err.Error()
+		}
 	} else {
 		out["current_version"] = compareKey
 	}
@@ -1672,6 +1875,9 @@ func (s *Server) handleCurrentConfig(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) send(w http.ResponseWriter, status string, data interface{}, code int) {
+	if ow, ok := w.(*outcomeWriter); ok {
+		ow.status = status
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	_ = json.NewEncoder(w).Encode(APIResponse{Status: status, Data: data})
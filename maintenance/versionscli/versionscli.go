@@ -8,7 +8,6 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -68,7 +67,7 @@ func RunList(args []string) int {
 		return 0
 	}
 
-	if net.ParseIP(target) == nil {
+	if !cliutil.IsRemoteIP(target) {
 		fmt.Fprintf(os.Stderr, "%s: remote target must be a valid IP address: %q\n", appmeta.BinaryName, target)
 		return 1
 	}
@@ -255,7 +254,7 @@ func RunSwitch(args []string) int {
 		return 0
 	}
 
-	if net.ParseIP(target) == nil {
+	if !cliutil.IsRemoteIP(target) {
 		fmt.Fprintf(os.Stderr, "%s: remote target must be a valid IP address: %q\n", appmeta.BinaryName, target)
 		return 1
 	}
@@ -520,6 +520,10 @@
         var card = applyHostBtn.closest && applyHostBtn.closest('.host-card');
         var hostVersion = card ? (card.getAttribute('data-host-version') || '') : '';
         var stPre = remoteUpdateStatusByIP[ip];
+        if (stPre && stPre.ok && stPre.blocked_reason) {
+          if (summary) summary.textContent = stPre.blocked_reason;
+          return;
+        }
         var canProceed = hasUploadableSelection()
           ? true
           : (stPre && stPre.ok
@@ -538,6 +542,10 @@
           var hv = c.getAttribute('data-host-version') || '';
           var hip = c.getAttribute('data-host-ip') || '';
           var st = remoteUpdateStatusByIP[hip];
+          if (st && st.ok && st.blocked_reason) {
+            applyHostBtn.disabled = true;
+            return;
+          }
           if (hasUploadableSelection()) {
             applyHostBtn.disabled = false;
             return;
@@ -776,6 +784,15 @@
       var card = btn.closest && btn.closest('.host-card');
       if (!card) continue;
       var hostVersion = card.getAttribute('data-host-version') || '';
+      var ip = card.getAttribute('data-host-ip') || '';
+      var st = remoteUpdateStatusByIP[ip];
+
+      // Agent cannot take a bundle at all (capabilities from its /self): block even with a local tar.gz selected.
+      if (st && st.ok && st.blocked_reason) {
+        btn.disabled = true;
+        btn.title = st.blocked_reason;
+        continue;
+      }
 
       if (hasUploadableSelection()) {
         btn.disabled = false;
@@ -785,9 +802,6 @@
         continue;
       }
 
-      var ip = card.getAttribute('data-host-ip') || '';
-      var st = remoteUpdateStatusByIP[ip];
-
       if (!st || st.pending) {
         btn.disabled = true;
         btn.title = '로컬 스테이징과 원격 버전 비교 중…';
@@ -1155,7 +1169,8 @@
           pending: false,
           ok: true,
           can_apply: !!d.can_apply,
-          apply_version: d.apply_version || ''
+          apply_version: d.apply_version || '',
+          blocked_reason: d.apply_blocked_reason || ''
         };
         updateAllHostApplyButtons();
       })